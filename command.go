@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Usage of the subcommands that manage the store instead of running the server
+const commandUsage = `Commands:
+  admin create -email EMAIL -password PASSWORD [-firstname NAME] [-lastname NAME]
+        create a verified admin
+  user promote -email EMAIL -role ROLE
+        set the role of a User: guest, staff or admin`
+
+// Run the subcommand in args against store, writing its output to out
+func runCommand(store Store, out io.Writer, args []string) error {
+	if len(args) < 2 {
+		return ErrUnknownCommand
+	}
+
+	switch args[0] + " " + args[1] {
+	case "admin create":
+		return adminCreateCommand(store, out, args[2:])
+	case "user promote":
+		return userPromoteCommand(store, out, args[2:])
+	default:
+		return ErrUnknownCommand
+	}
+}
+
+// Create a verified admin, e.g. the first one
+func adminCreateCommand(store Store, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("admin create", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	email := flags.String("email", "", "email of the admin")
+	password := flags.String("password", "", "password of the admin")
+	firstname := flags.String("firstname", "Admin", "first name of the admin")
+	lastname := flags.String("lastname", "", "last name of the admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*email) < 6 {
+		return ErrEmailTooShort
+	}
+	if len(*password) < 8 {
+		return ErrPasswordTooShort
+	}
+	if (&User{Email: *email}).exists(store, true) {
+		return ErrEmailAlreadyRegistered
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user := &User{
+		Firstname: *firstname,
+		Lastname:  *lastname,
+		Email:     *email,
+		Password:  string(hashedPassword),
+		Verified:  true,
+		Role:      RoleAdmin,
+	}
+	if _, err := user.insert(store); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Created admin", user.ID, user.Email)
+	return nil
+}
+
+// Set the role of the User with an email
+func userPromoteCommand(store Store, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("user promote", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	email := flags.String("email", "", "email of the user")
+	role := flags.String("role", "", "role to give the user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" || *role == "" {
+		return ErrEmptyParameter
+	}
+
+	user := &User{Email: *email}
+	if err := user.fetch(store); err == ErrNil {
+		return ErrEntityNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := user.setRole(store, *role); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Set the role of user", user.ID, user.Email, "to", user.Role)
+	return nil
+}
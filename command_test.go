@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	var out bytes.Buffer
+
+	// Create an admin
+	if err := runCommand(store, &out, []string{"admin", "create", "-email", "ada.command@example.com", "-password", "abcd1234"}); err != nil {
+		t.Fatal("admin create:", err)
+	}
+	admin := &User{Email: "ada.command@example.com"}
+	if err := admin.fetch(store); err != nil {
+		t.Fatal("user.fetch:", err)
+	}
+	defer admin.delete(store)
+	if !admin.HasRole(RoleAdmin) || !admin.Verified || admin.Password == "abcd1234" {
+		t.Error("admin create:", admin)
+	}
+
+	// The email can't be used twice, and the password has to be long enough
+	if err := runCommand(store, &out, []string{"admin", "create", "-email", "ada.command@example.com", "-password", "abcd1234"}); err != ErrEmailAlreadyRegistered {
+		t.Error("admin create: same email:", err)
+	}
+	if err := runCommand(store, &out, []string{"admin", "create", "-email", "bob.command@example.com", "-password", "abcd"}); err != ErrPasswordTooShort {
+		t.Error("admin create: short password:", err)
+	}
+
+	// Promote a User to staff, and then to admin
+	user := &User{Firstname: "Stan", Email: "stan.command@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+	for _, role := range []string{RoleStaff, RoleAdmin} {
+		if err := runCommand(store, &out, []string{"user", "promote", "-email", user.Email, "-role", role}); err != nil {
+			t.Error("user promote:", err)
+		}
+		if err := user.fetch(store); err != nil || user.Role != role {
+			t.Error("user promote:", user.Role, err)
+		}
+	}
+
+	// Missing roles, unknown Users, roles and commands
+	if err := runCommand(store, &out, []string{"user", "promote", "-email", user.Email}); err != ErrEmptyParameter {
+		t.Error("user promote: no role:", err)
+	}
+	if err := runCommand(store, &out, []string{"user", "promote", "-email", "nobody.command@example.com", "-role", RoleStaff}); err != ErrEntityNotFound {
+		t.Error("user promote: unknown user:", err)
+	}
+	if err := runCommand(store, &out, []string{"user", "promote", "-email", user.Email, "-role", "owner"}); err != ErrInvalidRole {
+		t.Error("user promote: unknown role:", err)
+	}
+	if err := runCommand(store, &out, []string{"user", "delete"}); err != ErrUnknownCommand {
+		t.Error("runCommand: unknown command:", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Where requests carry the CSRF token of their session: a header for scripts,
+// or a form field for HTML forms
+const (
+	csrfHeader    = "X-CSRF-Token"
+	csrfFormField = "csrfToken"
+)
+
+// Get the CSRF token of the session of a request, creating it if the session
+// doesn't have one yet
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := ss.Get(r, "session")
+	if token, ok := session.Values[csrfFormField].(string); ok && err == nil {
+		return token, nil
+	}
+
+	token, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+	session.Values[csrfFormField] = token
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Wrap handler so that requests that change something and are authenticated
+// by the session cookie have to carry the session's CSRF token. Other sites can
+// make browsers send the cookie, but they can't read the token.
+// Requests with a bearer token don't use the cookie, so they're let through.
+func (s *Server) csrfMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if _, err := r.Cookie("session"); err != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			// Uploads are no larger than media
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize)
+			}
+			token = r.FormValue(csrfFormField)
+		}
+
+		session, err := ss.Get(r, "session")
+		expected, _ := session.Values[csrfFormField].(string)
+		if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			http.Error(w, ErrInvalidCSRFToken.Error(), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) csrfHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		token, err := csrfToken(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(map[string]string{"csrfToken": token})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
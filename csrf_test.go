@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+	handler := s.csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Get a token and the cookie of its session
+	response := httptest.NewRecorder()
+	token, err := csrfToken(response, httptest.NewRequest("GET", "/", nil))
+	if err != nil || token == "" {
+		t.Fatal("csrfToken:", err)
+	}
+	cookies := response.Result().Cookies()
+
+	// The token stays the same for the session
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	if again, err := csrfToken(httptest.NewRecorder(), r); err != nil || again != token {
+		t.Error("csrfToken: again:", again, err)
+	}
+
+	// Send a request through the middleware
+	request := func(method string, body *strings.Reader, contentType string, header http.Header, withCookies bool) int {
+		r := httptest.NewRequest(method, "/api/user", body)
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		for k := range header {
+			r.Header.Set(k, header[k][0])
+		}
+		if withCookies {
+			for _, cookie := range cookies {
+				r.AddCookie(cookie)
+			}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	form := func(values url.Values) *strings.Reader {
+		return strings.NewReader(values.Encode())
+	}
+	const formType = "application/x-www-form-urlencoded"
+
+	// Safe methods, requests without the cookie and bearer requests don't need the token
+	if code := request("GET", form(nil), "", nil, true); code != http.StatusOK {
+		t.Error("GET:", code)
+	}
+	if code := request("POST", form(nil), formType, nil, false); code != http.StatusOK {
+		t.Error("POST without cookie:", code)
+	}
+	if code := request("POST", form(nil), formType, http.Header{"Authorization": {"Bearer abc"}}, true); code != http.StatusOK {
+		t.Error("POST with bearer token:", code)
+	}
+
+	// Cookie-authenticated changes need the token
+	if code := request("POST", form(nil), formType, nil, true); code != http.StatusForbidden {
+		t.Error("POST without token:", code)
+	}
+	if code := request("DELETE", form(nil), "", http.Header{csrfHeader: {"wrong"}}, true); code != http.StatusForbidden {
+		t.Error("DELETE with wrong token:", code)
+	}
+	if code := request("DELETE", form(nil), "", http.Header{csrfHeader: {token}}, true); code != http.StatusOK {
+		t.Error("DELETE with token header:", code)
+	}
+	if code := request("POST", form(url.Values{csrfFormField: {token}}), formType, nil, true); code != http.StatusOK {
+		t.Error("POST with token field:", code)
+	}
+
+	// Multipart forms carry the token as a field too
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField(csrfFormField, token)
+	writer.Close()
+	if code := request("POST", strings.NewReader(body.String()), writer.FormDataContentType(), nil, true); code != http.StatusOK {
+		t.Error("POST multipart with token field:", code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Failed logins are counted per client IP and per email, which are locked out
+// for a while once they fail too often. Every lockout within
+// maxLoginLockout lasts twice as long as the one before.
+
+// Subject of the failed logins from a client IP
+func loginIPSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// Subject of the failed logins to an email
+func loginEmailSubject(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// Get how long subject is locked out of logging in, or zero if it isn't
+func loginLockout(store Store, subject string) (time.Duration, error) {
+	until, err := store.Get(fmt.Sprint("loginLockout:", subject))
+	if err == ErrNil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	unixNano, err := strconv.ParseInt(until, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := time.Until(time.Unix(0, unixNano)); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Count a failed login of subject, locking it out once it failed maxFailures
+// times within loginFailureWindow
+func recordLoginFailure(store Store, subject string, maxFailures int) error {
+	key := fmt.Sprint("loginFailures:", subject)
+	failures, err := store.Incr(key)
+	if err != nil {
+		return err
+	}
+	if failures == 1 {
+		if err := store.Expire(key, loginFailureWindow); err != nil {
+			return err
+		}
+	}
+	if failures < maxFailures {
+		return nil
+	}
+
+	// Lock subject out for longer every time
+	lockoutsKey := fmt.Sprint("loginLockouts:", subject)
+	lockouts, err := store.Incr(lockoutsKey)
+	if err != nil {
+		return err
+	}
+	if err := store.Expire(lockoutsKey, maxLoginLockout); err != nil {
+		return err
+	}
+
+	duration := maxLoginLockout
+	if lockouts <= 32 {
+		if d := loginLockoutBase << uint(lockouts-1); d < maxLoginLockout {
+			duration = d
+		}
+	}
+
+	until := time.Now().Add(duration).UnixNano()
+	if err := store.SetEx(fmt.Sprint("loginLockout:", subject), until, duration); err != nil {
+		return err
+	}
+
+	// Start counting again after the lockout
+	return store.Del(key)
+}
+
+// Forget the failed logins and lockouts of subject
+func clearLoginFailures(store Store, subject string) error {
+	return store.Del(
+		fmt.Sprint("loginFailures:", subject),
+		fmt.Sprint("loginLockouts:", subject),
+		fmt.Sprint("loginLockout:", subject),
+	)
+}
+
+// Check if User is blocked, without fetching the rest of it
+func (user *User) isBlocked(store Store) (bool, error) {
+	if blocked, err := store.HGet(fmt.Sprint("user:", user.ID), "blocked"); err == ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return strconv.ParseBool(blocked)
+	}
+}
+
+// Block or unblock User. Blocking logs User out everywhere, and unblocking
+// also lifts the lockout of its email.
+func (user *User) setBlocked(store Store, blocked bool) error {
+	if user.ID == 0 {
+		return ErrMissingKey
+	}
+
+	user.Blocked = blocked
+	user.UpdatedAt = time.Now().Unix()
+
+	if err := store.HMSet(fmt.Sprint("user:", user.ID), map[string]interface{}{
+		"blocked":   user.Blocked,
+		"updatedAt": user.UpdatedAt,
+	}); err != nil {
+		return err
+	}
+
+	if blocked {
+		return user.deleteSessions(store)
+	}
+	return clearLoginFailures(store, loginEmailSubject(user.Email))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginAttempt(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	subject := loginEmailSubject(" Lock.Attempt@Example.com ")
+	if subject != "email:lock.attempt@example.com" {
+		t.Error("loginEmailSubject:", subject)
+	}
+	defer clearLoginFailures(store, subject)
+
+	// Subjects are locked out once they failed too often
+	for i := 0; i < 3; i++ {
+		if lockout, err := loginLockout(store, subject); err != nil || lockout != 0 {
+			t.Fatal("loginLockout: before failing:", lockout, err)
+		}
+		if err := recordLoginFailure(store, subject, 3); err != nil {
+			t.Fatal("recordLoginFailure:", err)
+		}
+	}
+	lockout, err := loginLockout(store, subject)
+	if err != nil || lockout <= 0 || lockout > loginLockoutBase {
+		t.Error("loginLockout: first lockout:", lockout, err)
+	}
+
+	// Every lockout lasts twice as long as the one before
+	for i := 0; i < 3; i++ {
+		if err := recordLoginFailure(store, subject, 3); err != nil {
+			t.Fatal("recordLoginFailure:", err)
+		}
+	}
+	if lockout, err := loginLockout(store, subject); err != nil || lockout <= loginLockoutBase || lockout > 2*loginLockoutBase {
+		t.Error("loginLockout: second lockout:", lockout, err)
+	}
+
+	// Clearing the failures lifts the lockout
+	if err := clearLoginFailures(store, subject); err != nil {
+		t.Error("clearLoginFailures:", err)
+	}
+	if lockout, err := loginLockout(store, subject); err != nil || lockout != 0 {
+		t.Error("loginLockout: cleared:", lockout, err)
+	}
+
+	// Block a User, which logs it out everywhere
+	user := &User{Firstname: "Bea", Email: "bea.attempt@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+	if blocked, err := user.isBlocked(store); err != nil || blocked {
+		t.Error("user.isBlocked: new user:", blocked, err)
+	}
+	userSession := &UserSession{UserID: user.ID}
+	if _, err := userSession.insert(store); err != nil {
+		t.Fatal("userSession.insert:", err)
+	}
+	if err := user.setBlocked(store, true); err != nil {
+		t.Error("user.setBlocked:", err)
+	}
+	if blocked, err := user.isBlocked(store); err != nil || !blocked {
+		t.Error("user.isBlocked: blocked:", blocked, err)
+	}
+	if err := userSession.fetch(store); err != ErrEntityNotFound {
+		t.Error("user.setBlocked: session not deleted:", err)
+	}
+
+	// Unblocking also lifts the lockout of the User's email
+	emailSubject := loginEmailSubject(user.Email)
+	for i := 0; i < maxEmailLoginFailures; i++ {
+		if err := recordLoginFailure(store, emailSubject, maxEmailLoginFailures); err != nil {
+			t.Fatal("recordLoginFailure:", err)
+		}
+	}
+	if err := user.setBlocked(store, false); err != nil {
+		t.Error("user.setBlocked: unblock:", err)
+	}
+	if blocked, err := user.isBlocked(store); err != nil || blocked {
+		t.Error("user.isBlocked: unblocked:", blocked, err)
+	}
+	if lockout, err := loginLockout(store, emailSubject); err != nil || lockout != 0 {
+		t.Error("loginLockout: unblocked:", lockout, err)
+	}
+
+	// Lockouts end by themselves
+	if err := store.SetEx("loginLockout:test:ended", time.Now().Add(-time.Second).UnixNano(), time.Minute); err != nil {
+		t.Fatal("store.SetEx:", err)
+	}
+	defer store.Del("loginLockout:test:ended")
+	if lockout, err := loginLockout(store, "test:ended"); err != nil || lockout != 0 {
+		t.Error("loginLockout: ended:", lockout, err)
+	}
+}
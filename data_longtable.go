@@ -2,101 +2,110 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
-type LongTable map[string]interface{}
+type LongTable struct {
+	ID          int    `redis:"id"`
+	UserID      int    `redis:"userID"`
+	Name        string `redis:"name"`
+	NumSeats    int    `redis:"numSeats"`
+	OpeningTime string `redis:"openingTime"`
+	ClosingTime string `redis:"closingTime"`
+	CreatedAt   int64  `redis:"createdAt"`
+	UpdatedAt   int64  `redis:"updatedAt"`
+}
+
+// LongTableView is the JSON representation of a LongTable
+type LongTableView struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	NumSeats    int    `json:"numSeats"`
+	OpeningTime string `json:"openingTime"`
+	ClosingTime string `json:"closingTime"`
+	CreatedAt   int64  `json:"createdAt"`
+	UpdatedAt   int64  `json:"updatedAt"`
+}
+
+// Get the JSON representation of LongTable
+func (longTable *LongTable) view() LongTableView {
+	return LongTableView{
+		ID:          longTable.ID,
+		Name:        longTable.Name,
+		NumSeats:    longTable.NumSeats,
+		OpeningTime: longTable.OpeningTime,
+		ClosingTime: longTable.ClosingTime,
+		CreatedAt:   longTable.CreatedAt,
+		UpdatedAt:   longTable.UpdatedAt,
+	}
+}
+
+// Get the JSON representation of LongTables
+func longTableViews(longTables []LongTable) []LongTableView {
+	views := []LongTableView{}
+	for i := range longTables {
+		views = append(views, longTables[i].view())
+	}
+	return views
+}
 
-// Check if LongTable exists, with an option to fetch the user
-func (longTable LongTable) exists(fetch bool) (bool, LongTable) {
+// Check if LongTable exists, with an option to fetch it
+func (longTable *LongTable) exists(store Store, fetch bool) bool {
 	// Check if the LongTable exists and retrieve it
 	if fetch {
-		if longTable, err := longTable.fetch(); err != nil {
-			return false, nil
-		} else {
-			return true, longTable
-		}
+		return longTable.fetch(store) == nil
 
 		// Just check if the LongTable exists
 	} else {
-		if ok, err := longTable._exists(); err != nil {
-			return false, nil
-		} else {
-			return ok, nil
-		}
+		ok, err := longTable._exists(store)
+		return ok && err == nil
 	}
 }
 
 // Check if LongTable exists
-func (longTable LongTable) _exists() (bool, error) {
-	if reply, err := db.Do("EXISTS", fmt.Sprint("longTable:", longTable["id"])); err != nil {
-		return false, err
-	} else if count, err := redis.Int(reply, err); err != nil {
-		return false, err
-	} else {
-		return count > 0, nil
-	}
+func (longTable *LongTable) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("longTable:", longTable.ID))
 }
 
-// Fetch LongTable with specified parameters
-func (longTable LongTable) fetch() (LongTable, error) {
-	if longTableID, ok := longTable["id"]; !ok {
-		return longTable, ErrMissingKey
-	} else {
-		if reply, err := db.Do("HGETALL", fmt.Sprint("longTable:", longTableID)); err != nil {
-			return longTable, err
-		} else if retrievedLongTable, err := redis.StringMap(reply, err); err != nil {
-			return longTable, err
-		} else {
-			for k, v := range retrievedLongTable {
-				switch k {
-				case "id":
-					fallthrough
-				case "numSeats":
-					value, err := strconv.Atoi(v)
-					if err != nil {
-						return longTable, err
-					}
-					longTable[k] = value
-				default:
-					longTable[k] = v
-				}
-			}
-		}
+// Fetch LongTable by its ID
+func (longTable *LongTable) fetch(store Store) error {
+	if longTable.ID == 0 {
+		return ErrMissingKey
 	}
 
-	return longTable, nil
+	fields, err := store.HGetAll(fmt.Sprint("longTable:", longTable.ID))
+	if err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	}
+
+	// LongTables used to be stored with 'created_at'
+	if _, ok := fields["createdAt"]; !ok {
+		fields["createdAt"] = fields["created_at"]
+	}
+
+	return scanHash(fields, longTable)
 }
 
 // Insert LongTable with specified parameters
-func (longTable LongTable) insert() (int, error) {
-	var longTableID int
-	if reply, err := db.Do("INCR", "nextLongTableID"); err != nil {
-		return 0, err
-	} else if longTableID, err = redis.Int(reply, err); err != nil {
+func (longTable *LongTable) insert(store Store) (int, error) {
+	longTableID, err := store.Incr("nextLongTableID")
+	if err != nil {
 		return 0, err
 	}
-	longTable["id"] = longTableID
-
-	var args []interface{}
-	args = append(args, fmt.Sprint("longTable:", longTableID))
+	longTable.ID = longTableID
 
 	now := time.Now().Unix()
 
 	// Set longTable
-	longTable["created_at"] = now
-	for k, v := range longTable {
-		args = append(args, k, v)
-	}
-	if _, err := db.Do("HMSET", args...); err != nil {
+	longTable.CreatedAt = now
+	if err := store.HMSet(fmt.Sprint("longTable:", longTableID), hashFields(longTable)); err != nil {
 		return 0, err
 	}
 
 	// Add longTable to longTables list
-	if _, err := db.Do("ZADD", "longTables", now, longTableID); err != nil {
+	if err := store.ZAdd("longTables", now, longTableID); err != nil {
 		return 0, err
 	}
 
@@ -104,21 +113,54 @@ func (longTable LongTable) insert() (int, error) {
 }
 
 // Delete LongTable with specified parameters
-func (longTable LongTable) delete() error {
-	longTableID := longTable["id"]
+func (longTable *LongTable) delete(store Store) error {
+	longTableID := longTable.ID
 
 	// Delete longTable
-	if _, err := db.Do("DEL", fmt.Sprint("longTable:", longTableID)); err != nil {
+	if err := store.Del(fmt.Sprint("longTable:", longTableID)); err != nil {
 		return err
 	}
 
 	// Remove longTable from longTables list
-	if _, err := db.Do("ZREM", "longTables", longTableID); err != nil {
+	if err := store.ZRem("longTables", longTableID); err != nil {
+		return err
+	}
+
+	// Delete the longTableBookings, which releases their seats. The longTable
+	// is already gone, so their seats aren't offered to the waitlists.
+	longTableBookingIDs, err := ids(store.ZRange(fmt.Sprint("longTableBookings:", longTableID), 0, -1))
+	if err != nil {
+		return err
+	}
+	for _, longTableBookingID := range longTableBookingIDs {
+		if err := (&LongTableBooking{ID: longTableBookingID}).delete(store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+	if err := store.Del(fmt.Sprint("longTableBookings:", longTableID)); err != nil {
+		return err
+	}
+
+	// Delete the waitlists of longTable
+	waitlists, err := store.ZRange(fmt.Sprint("longTableWaitlists:", longTableID), 0, -1)
+	if err != nil {
+		return err
+	}
+	for _, waitlist := range waitlists {
+		if err := store.Del(fmt.Sprint("longTableWaitlist:", longTableID, ":", waitlist)); err != nil {
+			return err
+		}
+	}
+	if err := store.Del(fmt.Sprint("longTableWaitlists:", longTableID)); err != nil {
 		return err
 	}
 
-	// Delete longTableBookings from userLongTableBookings
-	if _, err := db.Do("DEL", fmt.Sprint("longTableBookings:", longTableID)); err != nil {
+	// Delete the calendar and sittings of longTable
+	if err := store.Del(
+		fmt.Sprint("longTable:", longTableID, ":capacity"),
+		fmt.Sprint("longTable:", longTableID, ":closures"),
+		fmt.Sprint("longTable:", longTableID, ":sittings"),
+	); err != nil {
 		return err
 	}
 
@@ -126,47 +168,34 @@ func (longTable LongTable) delete() error {
 }
 
 // Update LongTable with specified parameters
-func (longTable LongTable) update() (err error) {
-	var args []interface{}
-
-	if longTableID, ok := longTable["id"]; !ok {
-		return ErrTypeAssertionFailed
-	} else {
-		args = append(args, fmt.Sprint("longTable:", longTableID))
+func (longTable *LongTable) update(store Store) (err error) {
+	if longTable.ID == 0 {
+		return ErrMissingKey
 	}
 
-	longTable["updatedAt"] = time.Now().Unix()
+	longTable.UpdatedAt = time.Now().Unix()
 
 	// Update longTable
-	for k, v := range longTable {
-		args = append(args, k, v)
-	}
-	if _, err := db.Do("HMSET", args...); err != nil {
-		return err
-	}
-
-	return nil
+	return store.HMSet(fmt.Sprint("longTable:", longTable.ID), hashFields(longTable))
 }
 
 // Get LongTables matching specified parameters
-func getLongTables(params map[string]interface{}) ([]LongTable, error) {
+func getLongTables(store Store, params map[string]interface{}) ([]LongTable, error) {
 	count := params["count"].(int)
 
-	return _getLongTables("ZRANGE", "longTables", 0, count-1)
+	return _getLongTables(store, "longTables", 0, count-1)
 }
 
-// Get LongTables with specified raw Redis command
-func _getLongTables(command string, args ...interface{}) ([]LongTable, error) {
+// Get LongTables in the specified range of a sorted set of LongTable IDs
+func _getLongTables(store Store, key string, start, stop int) ([]LongTable, error) {
 	var longTables []LongTable
 
-	if reply, err := db.Do(command, args...); err != nil {
-		return nil, err
-	} else if longTableIDs, err := redis.Ints(reply, err); err != nil {
+	if longTableIDs, err := ids(store.ZRange(key, start, stop)); err != nil {
 		return nil, err
 	} else {
 		for _, longTableID := range longTableIDs {
-			longTable := LongTable{"id": longTableID}
-			if _, err = longTable.fetch(); err != nil {
+			longTable := LongTable{ID: longTableID}
+			if err = longTable.fetch(store); err != nil {
 				return nil, err
 			}
 			longTables = append(longTables, longTable)
@@ -176,77 +205,67 @@ func _getLongTables(command string, args ...interface{}) ([]LongTable, error) {
 	return longTables, nil
 }
 
-func (longTable LongTable) fetchSeats() []int {
-	seats := make([]int, longTable["numSeats"].(int))
+// Get the seats of LongTable at date, which depend on its calendar
+func (longTable *LongTable) fetchSeats(store Store, date string) ([]int, error) {
+	numSeats, err := longTable.numSeatsAt(store, date)
+	if err != nil {
+		return nil, err
+	}
 
+	seats := make([]int, numSeats)
 	for i := 0; i < len(seats); i++ {
 		seats[i] = i
 	}
 
-	return seats
+	return seats, nil
 }
 
-func (longTable LongTable) fetchAvailableSeats(date string) ([]int, error) {
-	seats := longTable.fetchSeats()
-	takenSeats := []int{}
+// Get the seats of LongTable at date and sitting that haven't been booked
+func (longTable *LongTable) fetchAvailableSeats(store Store, date, sitting string) ([]int, error) {
+	seats, err := longTable.fetchSeats(store, date)
+	if err != nil {
+		return nil, err
+	}
 
-	if bookings, err := getLongTableBookings(map[string]interface{}{
-		"longTableID": longTable["id"],
+	bookings, err := getLongTableBookings(store, map[string]interface{}{
+		"longTableID": longTable.ID,
 		"date":        date,
-	}); err != nil {
-		if err != redis.ErrNil {
-			return nil, err
-		}
-	} else {
-		for _, booking := range bookings {
-			if seatPosition, ok := booking["seatPosition"]; ok {
-				pos := seatPosition.(int)
-				takenSeats = append(takenSeats, pos)
-			}
-		}
+		"sitting":     sitting,
+	})
+	if err != nil && err != ErrNil {
+		return nil, err
+	}
 
-		availableSeats := []int{}
-		for i := range seats {
-			taken := false
-			for _, j := range takenSeats {
-				if i == j {
-					taken = true
-					break
-				}
-			}
-			if !taken {
-				availableSeats = append(availableSeats, i)
-			}
+	takenSeats := map[int]bool{}
+	for _, booking := range bookings {
+		for _, seat := range booking.seats() {
+			takenSeats[seat] = true
 		}
-
-		return availableSeats, nil
 	}
 
-	return nil, nil
-}
-
-// Check if seat is available
-func (longTable LongTable) isSeatAvailable(date string, seatPosition int) (bool, error) {
-	if bookings, err := getLongTableBookings(map[string]interface{}{
-		"longTableID": longTable["id"],
-		"date":        date,
-	}); err != nil {
-		if err != redis.ErrNil {
-			return false, err
-		}
-	} else {
-		for _, booking := range bookings {
-			if pos, ok := booking["seatPosition"]; ok {
-				if pos == seatPosition {
-					return false, nil
-				}
-			}
+	availableSeats := []int{}
+	for _, seat := range seats {
+		if !takenSeats[seat] {
+			availableSeats = append(availableSeats, seat)
 		}
 	}
 
-	return true, nil
+	return availableSeats, nil
 }
 
-func (longTable LongTable) AvailableSeats(date string) ([]int, error) {
-	return longTable.fetchAvailableSeats(date)
+// Check if seat is available at date and sitting. It isn't if LongTable is
+// closed at date or doesn't have that many seats then, so LongTable must have
+// been fetched.
+func (longTable *LongTable) isSeatAvailable(store Store, date, sitting string, seatPosition int) (bool, error) {
+	if numSeats, err := longTable.numSeatsAt(store, date); err != nil {
+		return false, err
+	} else if seatPosition < 0 || seatPosition >= numSeats {
+		return false, nil
+	}
+
+	if taken, err := store.Exists(longTableSeatKey(longTable.ID, date, sitting, seatPosition)); err != nil {
+		return false, err
+	} else {
+		return !taken, nil
+	}
 }
@@ -4,188 +4,300 @@ import (
 	"fmt"
 	"strconv"
 	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
-type LongTableBooking map[string]interface{}
+type LongTableBooking struct {
+	ID           int    `redis:"id"`
+	UserID       int    `redis:"userID"`
+	LongTableID  int    `redis:"longTableID"`
+	SeatPosition int    `redis:"seatPosition"`
+	PartySize    int    `redis:"partySize"`
+	Date         string `redis:"date"`
+	Sitting      string `redis:"sitting"`
+	OfferedAt    int64  `redis:"offeredAt"`
+	CreatedAt    int64  `redis:"createdAt"`
+	UpdatedAt    int64  `redis:"updatedAt"`
+}
 
-func (longTableBooking LongTableBooking) exists(fetch bool) (bool, LongTableBooking) {
-	// Check if the longTableBooking exists and retrieve it
-	if fetch {
-		if longTableBooking, err := longTableBooking.fetch(); err != nil {
-			return false, nil
-		} else {
-			return true, longTableBooking
-		}
+// LongTableBookingView is the JSON representation of a LongTableBooking
+type LongTableBookingView struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"userID"`
+	LongTableID  int    `json:"longTableID"`
+	SeatPosition int    `json:"seatPosition"`
+	PartySize    int    `json:"partySize"`
+	Date         string `json:"date"`
+	Sitting      string `json:"sitting,omitempty"`
+	OfferedAt    int64  `json:"offeredAt,omitempty"`
+	CreatedAt    int64  `json:"createdAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+}
 
-		// Just check if the longTableBooking exists
-	} else {
-		if ok, err := longTableBooking._exists(); err != nil {
-			return false, nil
-		} else {
-			return ok, nil
-		}
+// Get the JSON representation of LongTableBooking
+func (longTableBooking *LongTableBooking) view() LongTableBookingView {
+	return LongTableBookingView{
+		ID:           longTableBooking.ID,
+		UserID:       longTableBooking.UserID,
+		LongTableID:  longTableBooking.LongTableID,
+		SeatPosition: longTableBooking.SeatPosition,
+		PartySize:    longTableBooking.partySize(),
+		Date:         longTableBooking.Date,
+		Sitting:      longTableBooking.Sitting,
+		OfferedAt:    longTableBooking.OfferedAt,
+		CreatedAt:    longTableBooking.CreatedAt,
+		UpdatedAt:    longTableBooking.UpdatedAt,
 	}
 }
 
-// Check if LongTableBooking exists
-func (longTableBooking LongTableBooking) _exists() (bool, error) {
-	if reply, err := db.Do("EXISTS", fmt.Sprint("longTableBooking:", longTableBooking["id"])); err != nil {
-		return false, err
-	} else if count, err := redis.Int(reply, err); err != nil {
-		return false, err
-	} else {
-		return count > 0, nil
+// Get the JSON representation of LongTableBookings
+func longTableBookingViews(longTableBookings []LongTableBooking) []LongTableBookingView {
+	views := []LongTableBookingView{}
+	for i := range longTableBookings {
+		views = append(views, longTableBookings[i].view())
 	}
+	return views
 }
 
-// Fetch LongTableBooking with specified parameters
-func (longTableBooking LongTableBooking) fetch() (LongTableBooking, error) {
-	if longTableBookingID, ok := longTableBooking["id"]; !ok {
-		return longTableBooking, ErrMissingKey
+// Check if LongTableBooking exists, with an option to fetch it
+func (longTableBooking *LongTableBooking) exists(store Store, fetch bool) bool {
+	// Check if the longTableBooking exists and retrieve it
+	if fetch {
+		return longTableBooking.fetch(store) == nil
+
+		// Just check if the longTableBooking exists
 	} else {
-		if reply, err := db.Do("HGETALL", fmt.Sprint("longTableBooking:", longTableBookingID)); err != nil {
-			return longTableBooking, err
-		} else if retrievedLongTableBooking, err := redis.StringMap(reply, err); err != nil {
-			return longTableBooking, err
-		} else {
-			for k, v := range retrievedLongTableBooking {
-				switch k {
-				case "id":
-					fallthrough
-				case "userID":
-					fallthrough
-				case "longTableID":
-					fallthrough
-				case "seatPosition":
-					value, err := strconv.Atoi(v)
-					if err != nil {
-						return longTableBooking, err
-					}
-					longTableBooking[k] = value
-				default:
-					longTableBooking[k] = v
-				}
-			}
-		}
+		ok, err := longTableBooking._exists(store)
+		return ok && err == nil
 	}
+}
 
-	return longTableBooking, nil
+// Check if LongTableBooking exists
+func (longTableBooking *LongTableBooking) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("longTableBooking:", longTableBooking.ID))
 }
 
-// Insert LongTableBooking with specified parameters
-func (longTableBooking LongTableBooking) insert() (int, error) {
-	if !hasKeys(longTableBooking, "longTableID", "userID", "date") {
-		return 0, ErrMissingKey
+// Fetch LongTableBooking by its ID
+func (longTableBooking *LongTableBooking) fetch(store Store) error {
+	if longTableBooking.ID == 0 {
+		return ErrMissingKey
 	}
 
-	var longTableBookingID int
-	if reply, err := db.Do("INCR", "nextLongTableBookingID"); err != nil {
-		return 0, err
-	} else if longTableBookingID, err = redis.Int(reply, err); err != nil {
-		return 0, err
+	if fields, err := store.HGetAll(fmt.Sprint("longTableBooking:", longTableBooking.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else {
+		return scanHash(fields, longTableBooking)
 	}
-	longTableBooking["id"] = longTableBookingID
-
-	var args []interface{}
-	args = append(args, fmt.Sprint("longTableBooking:", longTableBookingID))
-
-	now := time.Now().Unix()
+}
 
-	// Set longTableBooking
-	longTableBooking["createdAt"] = now
-	for k, v := range longTableBooking {
-		args = append(args, k, v)
-	}
-	if _, err := db.Do("HMSET", args...); err != nil {
-		return 0, err
+// Get the number of people LongTableBooking is for. Bookings from before
+// parties were booked together are for one.
+func (longTableBooking *LongTableBooking) partySize() int {
+	if longTableBooking.PartySize < 1 {
+		return 1
 	}
+	return longTableBooking.PartySize
+}
 
-	// Add longTableBooking to longTableBookings list
-	if _, err := db.Do("ZADD", fmt.Sprint("longTableBookings:", longTableBooking["longTableID"]), now, longTableBookingID); err != nil {
-		return 0, err
+// Get the seats of LongTableBooking: one for each of its party, next to each
+// other from its SeatPosition
+func (longTableBooking *LongTableBooking) seats() []int {
+	seats := make([]int, longTableBooking.partySize())
+	for i := range seats {
+		seats[i] = longTableBooking.SeatPosition + i
 	}
+	return seats
+}
 
-	// Add longTableBooking to longTableBookings:[LongTableID]:[date] list
-	if _, err := db.Do("ZADD", fmt.Sprint("longTableBookings:", longTableBooking["longTableID"], ":", longTableBooking["date"]), now, longTableBookingID); err != nil {
-		return 0, err
+// Insert LongTableBooking with specified parameters.
+// The seats of the whole party are claimed atomically, so it fails with
+// ErrSeatIsUnavailable if any of them is already taken and
+// ErrUserAlreadyBooked if the User has booked that date and sitting.
+func (longTableBooking *LongTableBooking) insert(store Store) (int, error) {
+	if longTableBooking.LongTableID == 0 || longTableBooking.UserID == 0 || longTableBooking.Date == "" {
+		return 0, ErrMissingKey
 	}
 
-	// Add longTableBooking to userLongTableBookings list
-	if _, err := db.Do("ZADD", fmt.Sprint("userLongTableBookings:", longTableBooking["userID"]), now, longTableBookingID); err != nil {
-		return 0, err
-	}
+	longTableID := longTableBooking.LongTableID
+	userID := longTableBooking.UserID
+	date := longTableBooking.Date
+	sitting := longTableBooking.Sitting
 
-	// Add longTableBooking to userLongTableBookings:[userID]:[date] list
-	if _, err := db.Do("ZADD", fmt.Sprint("userLongTableBookings:", longTableBooking["userID"], ":", longTableBooking["date"]), now, longTableBookingID); err != nil {
-		return 0, err
+	now := time.Now().Unix()
+	longTableBooking.CreatedAt = now
+
+	seatKeys := longTableSeatKeys(longTableID, date, sitting, longTableBooking.seats())
+	userSittingKey := fmt.Sprint("userLongTableBookings:", userID, ":", sittingKey(date, sitting))
+
+	longTableBookingID, err := store.Reserve(&Reservation{
+		Counter: "nextLongTableBookingID",
+		Prefix:  "longTableBooking:",
+		Fields:  hashFields(longTableBooking),
+		Absent:  []string{userSittingKey},
+		Claims:  seatKeys,
+		Indexes: uniqueKeys(
+			fmt.Sprint("longTableBookings:", longTableID),
+			fmt.Sprint("longTableBookings:", longTableID, ":", sittingKey(date, sitting)),
+			fmt.Sprint("userLongTableBookings:", userID),
+			fmt.Sprint("userLongTableBookings:", userID, ":", date),
+			userSittingKey,
+		),
+		Score: now,
+	})
+	if err != nil {
+		return 0, longTableBookingError(err, seatKeys)
 	}
 
+	longTableBooking.ID = longTableBookingID
+
 	return longTableBookingID, nil
 }
 
-// Delete LongTableBooking with specified parameters
-func (longTableBooking LongTableBooking) delete() error {
-	if !hasKeys(longTableBooking, "id", "userID", "date") {
-		return ErrMissingKey
+// Delete LongTableBooking with specified parameters.
+// Each of its seats is then offered to the next User on the waitlist, see promoteWaitlist.
+func (longTableBooking *LongTableBooking) delete(store Store) error {
+	// Fetch the rest of the LongTableBooking so that all its references can be removed
+	if err := longTableBooking.fetch(store); err != nil {
+		return err
 	}
 
-	longTableBookingID := longTableBooking["id"]
+	longTableBookingID := longTableBooking.ID
+	longTableID := longTableBooking.LongTableID
+	userID := longTableBooking.UserID
+	date := longTableBooking.Date
+	sitting := longTableBooking.Sitting
 
 	// Delete longTableBooking
-	if _, err := db.Do("DEL", fmt.Sprint("longTableBooking:", longTableBookingID)); err != nil {
+	if err := store.Del(fmt.Sprint("longTableBooking:", longTableBookingID)); err != nil {
+		return err
+	}
+
+	// Release the seats
+	if err := store.Del(longTableSeatKeys(longTableID, date, sitting, longTableBooking.seats())...); err != nil {
 		return err
 	}
 
 	// Remove longTableBooking from longTableBookings list
-	if _, err := db.Do("ZREM", fmt.Sprint("longTableBookings:", longTableBooking["longTableID"]), longTableBookingID); err != nil {
+	if err := store.ZRem(fmt.Sprint("longTableBookings:", longTableID), longTableBookingID); err != nil {
 		return err
 	}
 
-	// Remove longTableBooking from longTableBookings:[LongTableID]:[date] list
-	if _, err := db.Do("ZREM", fmt.Sprint("longTableBookings:", longTableBooking["longTableID"], ":", longTableBooking["date"]), longTableBookingID); err != nil {
+	// Remove longTableBooking from longTableBookings:[LongTableID]:[date]:[sitting] list
+	if err := store.ZRem(fmt.Sprint("longTableBookings:", longTableID, ":", sittingKey(date, sitting)), longTableBookingID); err != nil {
 		return err
 	}
 
 	// Remove longTableBooking from userLongTableBookings list
-	if _, err := db.Do("ZREM", fmt.Sprint("userLongTableBookings:", longTableBooking["userID"]), longTableBookingID); err != nil {
+	if err := store.ZRem(fmt.Sprint("userLongTableBookings:", userID), longTableBookingID); err != nil {
 		return err
 	}
 
-	// Remove longTableBooking from userLongTableBookings:[userID]:[date] list
-	if _, err := db.Do("ZREM", fmt.Sprint("longTableBookings:", longTableBooking["userID"], ":", longTableBooking["date"]), longTableBookingID); err != nil {
-		return err
+	// Remove longTableBooking from userLongTableBookings:[userID]:[date] and
+	// userLongTableBookings:[userID]:[date]:[sitting] lists
+	for _, key := range uniqueKeys(
+		fmt.Sprint("userLongTableBookings:", userID, ":", date),
+		fmt.Sprint("userLongTableBookings:", userID, ":", sittingKey(date, sitting)),
+	) {
+		if err := store.ZRem(key, longTableBookingID); err != nil {
+			return err
+		}
+	}
+
+	// Withdraw longTableBooking if it was offered from the waitlist
+	if longTableBooking.OfferedAt != 0 {
+		if err := store.ZRem("longTableOffers", longTableBookingID); err != nil {
+			return err
+		}
+		if err := store.ZRem("longTableOfferNotifications", longTableBookingID); err != nil {
+			return err
+		}
 	}
 
+	// Offer the seats to whoever is waiting for them
+	for _, seat := range longTableBooking.seats() {
+		freed := *longTableBooking
+		freed.SeatPosition, freed.PartySize = seat, 1
+		if _, err := promoteWaitlist(store, &freed); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Update LongTableBooking with specified parameters
-func (longTableBooking LongTableBooking) update() (err error) {
-	var args []interface{}
+// Update LongTableBooking with specified parameters.
+// Changing the seat, party size, date or sitting moves the seat claims,
+// failing with ErrSeatIsUnavailable or ErrUserAlreadyBooked like insert does.
+func (longTableBooking *LongTableBooking) update(store Store) (err error) {
+	if longTableBooking.ID == 0 {
+		return ErrMissingKey
+	}
 
-	if longTableBookingID, ok := longTableBooking["id"]; !ok {
-		return ErrTypeAssertionFailed
-	} else {
-		args = append(args, fmt.Sprint("longTableBooking:", longTableBookingID))
+	// Fetch the stored LongTableBooking to find the claims it currently holds
+	stored := LongTableBooking{ID: longTableBooking.ID}
+	if err := stored.fetch(store); err != nil {
+		return err
+	}
+
+	// The LongTable and User of a booking can't be changed
+	if longTableBooking.LongTableID != stored.LongTableID || longTableBooking.UserID != stored.UserID {
+		return ErrIDMismach
 	}
 
-	longTableBooking["updatedAt"] = time.Now().Unix()
+	longTableID, userID, date, sitting := stored.LongTableID, stored.UserID, longTableBooking.Date, longTableBooking.Sitting
 
-	// Update longTableBooking
-	for k, v := range longTableBooking {
-		args = append(args, k, v)
+	// When the booking was made and whether it's still a waitlist offer aren't
+	// up to the caller, so those fields are left as they are stored
+	now := time.Now().Unix()
+	longTableBooking.OfferedAt, longTableBooking.CreatedAt, longTableBooking.UpdatedAt = stored.OfferedAt, stored.CreatedAt, now
+	fields := hashFields(longTableBooking)
+	delete(fields, "offeredAt")
+	delete(fields, "createdAt")
+
+	// Release the seats that are no longer part of the booking
+	seatKeys := longTableSeatKeys(longTableID, date, sitting, longTableBooking.seats())
+	var release []string
+	for _, key := range longTableSeatKeys(longTableID, stored.Date, stored.Sitting, stored.seats()) {
+		if !contains(seatKeys, key) {
+			release = append(release, key)
+		}
 	}
-	if _, err := db.Do("HMSET", args...); err != nil {
-		return err
+
+	reservation := &Reservation{
+		ID:      longTableBooking.ID,
+		Prefix:  "longTableBooking:",
+		Fields:  fields,
+		Claims:  seatKeys,
+		Release: release,
+		Score:   now,
+	}
+
+	// Move the booking to the lists of the new date and sitting
+	if date != stored.Date || sitting != stored.Sitting {
+		userSittingKey := fmt.Sprint("userLongTableBookings:", userID, ":", sittingKey(date, sitting))
+
+		reservation.Absent = []string{userSittingKey}
+		reservation.Unindex = uniqueKeys(
+			fmt.Sprint("longTableBookings:", longTableID, ":", sittingKey(stored.Date, stored.Sitting)),
+			fmt.Sprint("userLongTableBookings:", userID, ":", stored.Date),
+			fmt.Sprint("userLongTableBookings:", userID, ":", sittingKey(stored.Date, stored.Sitting)),
+		)
+		reservation.Indexes = uniqueKeys(
+			fmt.Sprint("longTableBookings:", longTableID, ":", sittingKey(date, sitting)),
+			fmt.Sprint("userLongTableBookings:", userID, ":", date),
+			userSittingKey,
+		)
+	}
+
+	if _, err := store.Reserve(reservation); err != nil {
+		return longTableBookingError(err, seatKeys)
 	}
 
 	return nil
 }
 
 // Get LongTableBookings matching specified parameters
-func getLongTableBookings(params map[string]interface{}) ([]LongTableBooking, error) {
+func getLongTableBookings(store Store, params map[string]interface{}) ([]LongTableBooking, error) {
 	var count int
 
 	if _count, ok := params["count"]; ok {
@@ -207,33 +319,32 @@ func getLongTableBookings(params map[string]interface{}) ([]LongTableBooking, er
 
 	if date, ok := params["date"]; ok {
 		if longTableID, ok := params["longTableID"]; ok {
-			return _getLongTableBookings("ZRANGE", fmt.Sprint("longTableBookings:", longTableID, ":", date), 0, count-1)
+			sitting, _ := params["sitting"].(string)
+			return _getLongTableBookings(store, fmt.Sprint("longTableBookings:", longTableID, ":", sittingKey(fmt.Sprint(date), sitting)), 0, count-1)
 		} else if userID, ok := params["userID"]; ok {
-			return _getLongTableBookings("ZRANGE", fmt.Sprint("userLongTableBookings:", userID, ":", date), 0, count-1)
+			return _getLongTableBookings(store, fmt.Sprint("userLongTableBookings:", userID, ":", date), 0, count-1)
 		}
 	} else {
 		if longTableID, ok := params["longTableID"]; ok {
-			return _getLongTableBookings("ZRANGE", fmt.Sprint("longTableBookings:", longTableID), 0, count-1)
+			return _getLongTableBookings(store, fmt.Sprint("longTableBookings:", longTableID), 0, count-1)
 		} else if userID, ok := params["userID"]; ok {
-			return _getLongTableBookings("ZRANGE", fmt.Sprint("userLongTableBookings:", userID), 0, count-1)
+			return _getLongTableBookings(store, fmt.Sprint("userLongTableBookings:", userID), 0, count-1)
 		}
 	}
 
 	return nil, ErrMissingKey
 }
 
-// Get LongTableBookings with specified raw Redis command
-func _getLongTableBookings(command string, args ...interface{}) ([]LongTableBooking, error) {
+// Get LongTableBookings in the specified range of a sorted set of LongTableBooking IDs
+func _getLongTableBookings(store Store, key string, start, stop int) ([]LongTableBooking, error) {
 	var longTableBookings []LongTableBooking
 
-	if reply, err := db.Do(command, args...); err != nil {
-		return nil, err
-	} else if longTableBookingIDs, err := redis.Ints(reply, err); err != nil {
+	if longTableBookingIDs, err := ids(store.ZRange(key, start, stop)); err != nil {
 		return nil, err
 	} else {
 		for _, longTableBookingID := range longTableBookingIDs {
-			longTableBooking := LongTableBooking{"id": longTableBookingID}
-			if _, err = longTableBooking.fetch(); err != nil {
+			longTableBooking := LongTableBooking{ID: longTableBookingID}
+			if err = longTableBooking.fetch(store); err != nil {
 				return nil, err
 			}
 			longTableBookings = append(longTableBookings, longTableBooking)
@@ -242,3 +353,63 @@ func _getLongTableBookings(command string, args ...interface{}) ([]LongTableBook
 
 	return longTableBookings, nil
 }
+
+// Key of the claim on a LongTable seat at particular date and sitting
+func longTableSeatKey(longTableID interface{}, date, sitting string, seatPosition interface{}) string {
+	return fmt.Sprint("longTableSeat:", longTableID, ":", sittingKey(date, sitting), ":", seatPosition)
+}
+
+// Keys of the claims on LongTable seats at particular date and sitting
+func longTableSeatKeys(longTableID interface{}, date, sitting string, seatPositions []int) []string {
+	keys := make([]string, 0, len(seatPositions))
+	for _, seatPosition := range seatPositions {
+		keys = append(keys, longTableSeatKey(longTableID, date, sitting, seatPosition))
+	}
+	return keys
+}
+
+// Claim the seats of the LongTableBookings of LongTable from before seats were
+// claimed, which are only in the booking lists, so that they can't be booked
+// again. Seats that are already claimed are left alone. It returns how many
+// were claimed.
+func (longTable *LongTable) claimLegacySeats(store Store) (int, error) {
+	longTableBookingIDs, err := ids(store.ZRange(fmt.Sprint("longTableBookings:", longTable.ID), 0, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	claimed := 0
+	for _, longTableBookingID := range longTableBookingIDs {
+		longTableBooking := &LongTableBooking{ID: longTableBookingID}
+		if err := longTableBooking.fetch(store); err == ErrEntityNotFound {
+			continue
+		} else if err != nil {
+			return claimed, err
+		}
+
+		seatKey := longTableSeatKey(longTable.ID, longTableBooking.Date, longTableBooking.Sitting, longTableBooking.SeatPosition)
+		if exists, err := store.Exists(seatKey); err != nil {
+			return claimed, err
+		} else if exists {
+			continue
+		}
+
+		if err := store.Set(seatKey, longTableBooking.ID); err != nil {
+			return claimed, err
+		}
+		claimed++
+	}
+
+	return claimed, nil
+}
+
+// Translate a conflict on one of the seat claims or the User's bookings on that date
+func longTableBookingError(err error, seatKeys []string) error {
+	if conflict, ok := err.(*ConflictError); ok {
+		if contains(seatKeys, conflict.Key) {
+			return ErrSeatIsUnavailable
+		}
+		return ErrUserAlreadyBooked
+	}
+	return err
+}
@@ -1,64 +1,253 @@
 package main
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 func TestLongTableBooking(t *testing.T) {
+	t.Parallel()
+
 	var err error
 
-	if db, err = redis.Dial("tcp", ":6379"); err != nil {
-		t.Error(err)
-	}
-	defer db.Close()
+	store := newTestStore(t)
+	defer store.Close()
 
 	date := time.Now().Format(DateFormat)
 
+	// Seats are only available on LongTables that have them
+	longTable := &LongTable{Name: "Booked longTable", NumSeats: 40}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
 	// Insert longTableBooking
-	longTableBooking := LongTableBooking{
-		"longTableID":  1000,
-		"userID":       2000,
-		"seatPosition": 20,
-		"date":         date,
+	longTableBooking := &LongTableBooking{
+		LongTableID:  longTable.ID,
+		UserID:       2000,
+		SeatPosition: 20,
+		Date:         date,
 	}
 
-	var longTableBookingID int
-	if longTableBookingID, err = longTableBooking.insert(); err != nil {
+	if _, err = longTableBooking.insert(store); err != nil {
 		t.Error("LongTableBooking.insert:", err)
 	}
-	longTableBooking["id"] = longTableBookingID
 
-	// Update longTableBooking
-	longTableBooking["seatPosition"] = 25
-	if err := longTableBooking.update(); err != nil {
+	// Update longTableBooking, which can't make it an offer or change when it was made
+	createdAt := longTableBooking.CreatedAt
+	longTableBooking.SeatPosition, longTableBooking.OfferedAt, longTableBooking.CreatedAt = 25, 1, 1
+	if err := longTableBooking.update(store); err != nil {
 		t.Error("LongTableBooking.update:", err)
 	}
 
+	// The seat has moved
+	if available, err := longTable.isSeatAvailable(store, date, "", 20); err != nil || !available {
+		t.Error("LongTable.isSeatAvailable:", err)
+	}
+	if available, err := longTable.isSeatAvailable(store, date, "", 25); err != nil || available {
+		t.Error("LongTable.isSeatAvailable:", err)
+	}
+
 	// Fetch longTableBooking
-	if _, err := longTableBooking.fetch(); err != nil {
+	fetched := &LongTableBooking{ID: longTableBooking.ID}
+	if err := fetched.fetch(store); err != nil {
 		t.Error("LongTableBooking.fetch:", err)
+	} else if fetched.SeatPosition != 25 || fetched.OfferedAt != 0 || fetched.CreatedAt != createdAt {
+		t.Error("LongTableBooking.fetch:", fetched)
 	}
 
 	// Has longTableBooking
-	if ok, _ := longTableBooking._exists(); !ok {
+	if ok, _ := longTableBooking._exists(store); !ok {
 		t.Error("LongTableBooking._exists")
 	}
 
 	// Get longTableBookings by longTableID
-	if longTableBookings, err := getLongTableBookings(map[string]interface{}{"longTableID": 1000, "count": 5}); err != nil || len(longTableBookings) < 1 {
+	if longTableBookings, err := getLongTableBookings(store, map[string]interface{}{"longTableID": longTable.ID, "count": 5}); err != nil || len(longTableBookings) < 1 {
 		t.Error("getLongTableBookings:", err)
 	}
 
 	// Get longTableBookings by userID
-	if longTableBookings, err := getLongTableBookings(map[string]interface{}{"userID": 2000, "count": 5}); err != nil || len(longTableBookings) < 1 {
+	if longTableBookings, err := getLongTableBookings(store, map[string]interface{}{"userID": 2000, "count": 5}); err != nil || len(longTableBookings) < 1 {
 		t.Error("getLongTableBookings:", err)
 	}
 
 	// Delete longTableBooking
-	if err = longTableBooking.delete(); err != nil {
+	if err = longTableBooking.delete(store); err != nil {
+		t.Error("LongTableBooking.delete:", err)
+	}
+}
+
+func TestLongTableBookingConcurrentInsert(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	date := time.Now().Format(DateFormat)
+
+	longTable := &LongTable{Name: "Contested longTable", NumSeats: 10}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	const numUsers = 50
+
+	var wg sync.WaitGroup
+	longTableBookingIDs := make(chan int, numUsers)
+	errs := make(chan error, numUsers)
+
+	// Let every User try to book the same seat at the same time
+	for i := 0; i < numUsers; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+
+			longTableBooking := &LongTableBooking{
+				LongTableID:  longTable.ID,
+				UserID:       userID,
+				SeatPosition: 7,
+				Date:         date,
+			}
+			if longTableBookingID, err := longTableBooking.insert(store); err != nil {
+				errs <- err
+			} else {
+				longTableBookingIDs <- longTableBookingID
+			}
+		}(3000 + i)
+	}
+	wg.Wait()
+	close(longTableBookingIDs)
+	close(errs)
+
+	if len(longTableBookingIDs) != 1 {
+		t.Errorf("LongTableBooking.insert: %d bookings for the same seat", len(longTableBookingIDs))
+	}
+	for err := range errs {
+		if err != ErrSeatIsUnavailable {
+			t.Error("LongTableBooking.insert:", err)
+		}
+	}
+
+	// Delete the booking that got the seat
+	for longTableBookingID := range longTableBookingIDs {
+		if err := (&LongTableBooking{ID: longTableBookingID}).delete(store); err != nil {
+			t.Error("LongTableBooking.delete:", err)
+		}
+	}
+
+	// The seat is free again
+	if available, err := longTable.isSeatAvailable(store, date, "", 7); err != nil || !available {
+		t.Error("LongTable.isSeatAvailable:", err)
+	}
+}
+
+func TestLongTableBookingParty(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	date := time.Now().Format(DateFormat)
+
+	longTable := &LongTable{Name: "Party longTable", NumSeats: 8}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	single := &LongTableBooking{LongTableID: longTable.ID, UserID: 4000, SeatPosition: 4, Date: date}
+	if _, err := single.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	defer single.delete(store)
+
+	// A party that overlaps another booking gets none of its seats
+	overlapping := &LongTableBooking{LongTableID: longTable.ID, UserID: 4001, SeatPosition: 2, PartySize: 3, Date: date}
+	if _, err := overlapping.insert(store); err != ErrSeatIsUnavailable {
+		t.Error("LongTableBooking.insert: overlapping:", err)
+	}
+	for _, seat := range []int{2, 3} {
+		if available, err := longTable.isSeatAvailable(store, date, "", seat); err != nil || !available {
+			t.Error("LongTable.isSeatAvailable:", seat, err)
+		}
+	}
+
+	// A party that fits gets all of its seats
+	party := &LongTableBooking{LongTableID: longTable.ID, UserID: 4001, SeatPosition: 5, PartySize: 3, Date: date}
+	if _, err := party.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	if available, err := longTable.fetchAvailableSeats(store, date, ""); err != nil || fmt.Sprint(available) != "[0 1 2 3]" {
+		t.Error("LongTable.fetchAvailableSeats:", available, err)
+	}
+
+	// Strategies seat parties next to each other
+	group := &LongTableBooking{LongTableID: longTable.ID, UserID: 4002, PartySize: 3, Date: date}
+	if _, err := group.insertAtAnySeat(store, longTable, GroupSeatStrategy{}); err != nil || group.SeatPosition != 0 {
+		t.Error("LongTableBooking.insertAtAnySeat:", group.SeatPosition, err)
+	}
+	defer group.delete(store)
+
+	// Deleting the party frees all of its seats
+	if err := party.delete(store); err != nil {
 		t.Error("LongTableBooking.delete:", err)
 	}
+	if available, err := longTable.fetchAvailableSeats(store, date, ""); err != nil || fmt.Sprint(available) != "[3 5 6 7]" {
+		t.Error("LongTable.fetchAvailableSeats:", available, err)
+	}
+}
+
+func TestLongTableClaimLegacySeats(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Legacy longTable", NumSeats: 10}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	// Bookings from before seat claims were only written to the booking lists
+	date := "18-12-2016"
+	longTableBookingID, err := store.Incr("nextLongTableBookingID")
+	if err != nil {
+		t.Fatal("Store.Incr:", err)
+	}
+	legacy := &LongTableBooking{ID: longTableBookingID, LongTableID: longTable.ID, UserID: 2500, SeatPosition: 4, Date: date}
+	if err := store.HMSet(fmt.Sprint("longTableBooking:", longTableBookingID), hashFields(legacy)); err != nil {
+		t.Fatal("Store.HMSet:", err)
+	}
+	for _, key := range []string{
+		fmt.Sprint("longTableBookings:", longTable.ID),
+		fmt.Sprint("longTableBookings:", longTable.ID, ":", date),
+		fmt.Sprint("userLongTableBookings:", legacy.UserID),
+		fmt.Sprint("userLongTableBookings:", legacy.UserID, ":", date),
+	} {
+		if err := store.ZAdd(key, 0, longTableBookingID); err != nil {
+			t.Fatal("Store.ZAdd:", err)
+		}
+	}
+	defer legacy.delete(store)
+
+	if claimed, err := longTable.claimLegacySeats(store); err != nil || claimed != 1 {
+		t.Error("LongTable.claimLegacySeats:", claimed, err)
+	}
+	if claimed, err := longTable.claimLegacySeats(store); err != nil || claimed != 0 {
+		t.Error("LongTable.claimLegacySeats: again:", claimed, err)
+	}
+
+	// The seat can't be booked again
+	if available, err := longTable.isSeatAvailable(store, date, "", 4); err != nil || available {
+		t.Error("LongTable.isSeatAvailable:", available, err)
+	}
+	double := &LongTableBooking{LongTableID: longTable.ID, UserID: 2501, SeatPosition: 4, Date: date}
+	if _, err := double.insert(store); err != ErrSeatIsUnavailable {
+		t.Error("LongTableBooking.insert:", err)
+	}
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Get the number of seats of LongTable at date.
+// A closure makes it zero, otherwise an override for the date, or else for its
+// weekday, replaces NumSeats.
+func (longTable *LongTable) numSeatsAt(store Store, date string) (int, error) {
+	t, err := time.Parse(DateFormat, date)
+	if err != nil {
+		return 0, ErrWrongDateFormat
+	}
+
+	if closed, err := longTable.isClosed(store, date); err != nil {
+		return 0, err
+	} else if closed {
+		return 0, nil
+	}
+
+	capacities, err := longTable.capacities(store)
+	if err != nil {
+		return 0, err
+	}
+	for _, day := range []string{date, strings.ToLower(t.Weekday().String())} {
+		if numSeats, ok := capacities[day]; ok {
+			return numSeats, nil
+		}
+	}
+
+	return longTable.NumSeats, nil
+}
+
+// Get the capacity overrides of LongTable, by date or lowercase weekday
+func (longTable *LongTable) capacities(store Store) (map[string]int, error) {
+	fields, err := store.HGetAll(fmt.Sprint("longTable:", longTable.ID, ":capacity"))
+	if err != nil {
+		return nil, err
+	}
+
+	capacities := map[string]int{}
+	for day, value := range fields {
+		if capacities[day], err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+	return capacities, nil
+}
+
+// Override the number of seats of LongTable at a date, e.g. "25-12-2016", or
+// every week on a weekday, e.g. "sunday"
+func (longTable *LongTable) setCapacity(store Store, day string, numSeats int) error {
+	if longTable.ID == 0 {
+		return ErrMissingKey
+	}
+	if !isCalendarDay(day) {
+		return ErrWrongDateFormat
+	}
+	if numSeats < 0 {
+		return ErrInvalidCapacity
+	}
+
+	return store.HMSet(fmt.Sprint("longTable:", longTable.ID, ":capacity"), map[string]interface{}{day: numSeats})
+}
+
+// Remove the capacity override of LongTable at a date or weekday
+func (longTable *LongTable) removeCapacity(store Store, day string) error {
+	return store.HDel(fmt.Sprint("longTable:", longTable.ID, ":capacity"), day)
+}
+
+// Get the closures of LongTable, with their reasons by date
+func (longTable *LongTable) closures(store Store) (map[string]string, error) {
+	return store.HGetAll(fmt.Sprint("longTable:", longTable.ID, ":closures"))
+}
+
+// Check if LongTable is closed at date
+func (longTable *LongTable) isClosed(store Store, date string) (bool, error) {
+	if _, err := store.HGet(fmt.Sprint("longTable:", longTable.ID, ":closures"), date); err == ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close LongTable at date, e.g. for a public holiday
+func (longTable *LongTable) close(store Store, date, reason string) error {
+	if longTable.ID == 0 {
+		return ErrMissingKey
+	}
+	if _, err := time.Parse(DateFormat, date); err != nil {
+		return ErrWrongDateFormat
+	}
+
+	return store.HMSet(fmt.Sprint("longTable:", longTable.ID, ":closures"), map[string]interface{}{date: reason})
+}
+
+// Open LongTable again at a date it was closed
+func (longTable *LongTable) reopen(store Store, date string) error {
+	return store.HDel(fmt.Sprint("longTable:", longTable.ID, ":closures"), date)
+}
+
+// Check if day is a date or a lowercase weekday
+func isCalendarDay(day string) bool {
+	if _, err := time.Parse(DateFormat, day); err == nil {
+		return true
+	}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if day == strings.ToLower(weekday.String()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestLongTableCalendar(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Calendar longTable", NumSeats: 40}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	// 18-12-2016 and 25-12-2016 are Sundays, 19-12-2016 and 26-12-2016 Mondays
+	if err := longTable.setCapacity(store, "sunday", 20); err != nil {
+		t.Error("LongTable.setCapacity: weekday:", err)
+	}
+	if err := longTable.setCapacity(store, "25-12-2016", 30); err != nil {
+		t.Error("LongTable.setCapacity: date:", err)
+	}
+	if err := longTable.setCapacity(store, "someday", 30); err != ErrWrongDateFormat {
+		t.Error("LongTable.setCapacity: invalid day:", err)
+	}
+	if err := longTable.setCapacity(store, "monday", -1); err != ErrInvalidCapacity {
+		t.Error("LongTable.setCapacity: invalid capacity:", err)
+	}
+	if err := longTable.close(store, "26-12-2016", "Boxing Day"); err != nil {
+		t.Error("LongTable.close:", err)
+	}
+
+	// Closures come first, then dates, then weekdays
+	for date, numSeats := range map[string]int{"18-12-2016": 20, "19-12-2016": 40, "25-12-2016": 30, "26-12-2016": 0} {
+		if seats, err := longTable.fetchAvailableSeats(store, date, ""); err != nil || len(seats) != numSeats {
+			t.Error("LongTable.fetchAvailableSeats:", date, len(seats), err)
+		}
+	}
+	if available, err := longTable.isSeatAvailable(store, "18-12-2016", "", 25); err != nil || available {
+		t.Error("LongTable.isSeatAvailable: beyond capacity:", available, err)
+	}
+	if available, err := longTable.isSeatAvailable(store, "26-12-2016", "", 0); err != nil || available {
+		t.Error("LongTable.isSeatAvailable: closed:", available, err)
+	}
+	if closures, err := longTable.closures(store); err != nil || closures["26-12-2016"] != "Boxing Day" {
+		t.Error("LongTable.closures:", closures, err)
+	}
+
+	// Booked seats aren't available
+	longTableBooking := &LongTableBooking{LongTableID: longTable.ID, UserID: 4000, SeatPosition: 3, Date: "18-12-2016"}
+	if _, err := longTableBooking.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	defer longTableBooking.delete(store)
+	if seats, err := longTable.fetchAvailableSeats(store, "18-12-2016", ""); err != nil || len(seats) != 19 || seats[3] != 4 {
+		t.Error("LongTable.fetchAvailableSeats: booked:", seats, err)
+	}
+
+	// Removing the overrides restores NumSeats
+	if err := longTable.reopen(store, "26-12-2016"); err != nil {
+		t.Error("LongTable.reopen:", err)
+	}
+	if err := longTable.removeCapacity(store, "sunday"); err != nil {
+		t.Error("LongTable.removeCapacity:", err)
+	}
+	for _, date := range []string{"18-12-2016", "26-12-2016"} {
+		if numSeats, err := longTable.numSeatsAt(store, date); err != nil || numSeats != 40 {
+			t.Error("LongTable.numSeatsAt:", date, numSeats, err)
+		}
+	}
+	if _, err := longTable.numSeatsAt(store, "2016-12-18"); err != ErrWrongDateFormat {
+		t.Error("LongTable.numSeatsAt: wrong format:", err)
+	}
+}
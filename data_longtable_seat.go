@@ -0,0 +1,158 @@
+package main
+
+// How often assigning a seat is tried again when someone else takes it first
+const maxSeatAssignmentAttempts = 3
+
+// SeatStrategy picks the seats for a LongTableBooking that doesn't have them,
+// from the seats that are available at its date and sitting, in ascending
+// order. It returns the first of a row of free seats next to each other, one
+// for each of the booking's party.
+type SeatStrategy interface {
+	PickSeat(store Store, longTableBooking *LongTableBooking, available []int) (int, error)
+}
+
+// Strategies that bookings can ask for by name
+var seatStrategies = map[string]SeatStrategy{
+	"first":       FirstFreeSeatStrategy{},
+	"together":    GroupSeatStrategy{},
+	"connections": ConnectionSeatStrategy{},
+}
+
+// Strategy of bookings that don't ask for one
+const defaultSeatStrategy = "first"
+
+// Row of free seats next to each other
+type seatRow struct {
+	start, length int
+}
+
+// Get the rows of free seats in available, in ascending order
+func seatRows(available []int) []seatRow {
+	var rows []seatRow
+	for i, seat := range available {
+		if i > 0 && seat == available[i-1]+1 {
+			rows[len(rows)-1].length++
+		} else {
+			rows = append(rows, seatRow{start: seat, length: 1})
+		}
+	}
+	return rows
+}
+
+// FirstFreeSeatStrategy picks the row of free seats with the lowest positions
+// that the party fits in
+type FirstFreeSeatStrategy struct{}
+
+func (strategy FirstFreeSeatStrategy) PickSeat(store Store, longTableBooking *LongTableBooking, available []int) (int, error) {
+	for _, row := range seatRows(available) {
+		if row.length >= longTableBooking.partySize() {
+			return row.start, nil
+		}
+	}
+	return 0, ErrSeatIsUnavailable
+}
+
+// GroupSeatStrategy keeps groups together, by seating the party at the start of
+// the shortest row of free seats that it fits in, so that the longest rows stay
+// free for larger groups that arrive later
+type GroupSeatStrategy struct{}
+
+func (strategy GroupSeatStrategy) PickSeat(store Store, longTableBooking *LongTableBooking, available []int) (int, error) {
+	var best *seatRow
+	for _, row := range seatRows(available) {
+		if row.length >= longTableBooking.partySize() && (best == nil || row.length < best.length) {
+			row := row
+			best = &row
+		}
+	}
+	if best == nil {
+		return 0, ErrSeatIsUnavailable
+	}
+	return best.start, nil
+}
+
+// ConnectionSeatStrategy sits Users next to their connections who booked the
+// same date and sitting, the earliest connection first. Without a row of free
+// seats next to one that the party fits in, it keeps groups together.
+type ConnectionSeatStrategy struct{}
+
+func (strategy ConnectionSeatStrategy) PickSeat(store Store, longTableBooking *LongTableBooking, available []int) (int, error) {
+	// Check that the party fits anywhere at all
+	together, err := GroupSeatStrategy{}.PickSeat(store, longTableBooking, available)
+	if err != nil {
+		return 0, err
+	}
+
+	connectionIDs, err := (&User{ID: longTableBooking.UserID}).otherUserIDs(store)
+	if err != nil {
+		return 0, err
+	}
+
+	longTableBookings, err := getLongTableBookings(store, map[string]interface{}{
+		"longTableID": longTableBooking.LongTableID,
+		"date":        longTableBooking.Date,
+		"sitting":     longTableBooking.Sitting,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	free := map[int]bool{}
+	for _, seat := range available {
+		free[seat] = true
+	}
+	fits := func(start int) bool {
+		for seat := start; seat < start+longTableBooking.partySize(); seat++ {
+			if !free[seat] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Find the seats of the connections' parties, in the order of the connections
+	booked := map[int]*LongTableBooking{}
+	for i := range longTableBookings {
+		booked[longTableBookings[i].UserID] = &longTableBookings[i]
+	}
+	for _, connectionID := range connectionIDs {
+		connection, ok := booked[connectionID]
+		if !ok {
+			continue
+		}
+
+		// Sit right after the connection's party, or else right before it
+		seats := connection.seats()
+		for _, start := range []int{seats[len(seats)-1] + 1, seats[0] - longTableBooking.partySize()} {
+			if fits(start) {
+				return start, nil
+			}
+		}
+	}
+
+	return together, nil
+}
+
+// Insert LongTableBooking at the seats that strategy picks from the seats of
+// LongTable that are available then, all of them or none. It fails with
+// ErrSeatIsUnavailable if there are none, and ErrUserAlreadyBooked like insert
+// does.
+func (longTableBooking *LongTableBooking) insertAtAnySeat(store Store, longTable *LongTable, strategy SeatStrategy) (int, error) {
+	for attempt := 1; ; attempt++ {
+		available, err := longTable.fetchAvailableSeats(store, longTableBooking.Date, longTableBooking.Sitting)
+		if err != nil {
+			return 0, err
+		}
+
+		if longTableBooking.SeatPosition, err = strategy.PickSeat(store, longTableBooking, available); err != nil {
+			return 0, err
+		}
+
+		// Someone else might have taken the seats in the meantime
+		longTableBookingID, err := longTableBooking.insert(store)
+		if err == ErrSeatIsUnavailable && attempt < maxSeatAssignmentAttempts {
+			continue
+		}
+		return longTableBookingID, err
+	}
+}
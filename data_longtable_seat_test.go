@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestSeatStrategies(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		strategy  SeatStrategy
+		partySize int
+		available []int
+		seat      int
+	}{
+		{FirstFreeSeatStrategy{}, 1, []int{2, 3, 7}, 2},
+		{FirstFreeSeatStrategy{}, 2, []int{1, 3, 4, 7}, 3},
+		{GroupSeatStrategy{}, 1, []int{0, 1, 2, 3, 4}, 0},
+		{GroupSeatStrategy{}, 1, []int{1, 2, 3, 5, 8, 9}, 5},
+		{GroupSeatStrategy{}, 1, []int{0, 1, 4, 5, 6}, 0},
+		{GroupSeatStrategy{}, 2, []int{1, 5, 6, 7}, 5},
+		{GroupSeatStrategy{}, 2, []int{0, 1, 2, 4, 5, 8}, 4},
+	} {
+		longTableBooking := &LongTableBooking{PartySize: test.partySize}
+		if seat, err := test.strategy.PickSeat(nil, longTableBooking, test.available); err != nil || seat != test.seat {
+			t.Errorf("%T.PickSeat: %d: %v: %d %v", test.strategy, test.partySize, test.available, seat, err)
+		}
+	}
+
+	// Parties aren't split over rows of free seats
+	if _, err := (FirstFreeSeatStrategy{}).PickSeat(nil, &LongTableBooking{PartySize: 3}, []int{0, 1, 3, 4}); err != ErrSeatIsUnavailable {
+		t.Error("FirstFreeSeatStrategy.PickSeat: split:", err)
+	}
+
+	for name, strategy := range seatStrategies {
+		if _, err := strategy.PickSeat(nil, &LongTableBooking{}, []int{}); err != ErrSeatIsUnavailable {
+			t.Error("SeatStrategy.PickSeat: full:", name, err)
+		}
+	}
+}
+
+func TestConnectionSeatStrategy(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Connected longTable", NumSeats: 6}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	var users []*User
+	for _, firstname := range []string{"Gus", "Hal", "Ivy"} {
+		user := &User{Firstname: firstname, Email: firstname + ".seat@example.com"}
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+		users = append(users, user)
+	}
+	for _, pair := range [][2]*User{{users[0], users[2]}, {users[2], users[0]}} {
+		if err := pair[0].addUser(store, pair[1]); err != nil {
+			t.Fatal("User.addUser:", err)
+		}
+	}
+
+	// A stranger sits at the end of the table
+	date := "18-12-2016"
+	stranger := &LongTableBooking{LongTableID: longTable.ID, UserID: users[1].ID, SeatPosition: 5, Date: date}
+	if _, err := stranger.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	defer stranger.delete(store)
+
+	// Without connections at the table, the party is kept together
+	first := &LongTableBooking{LongTableID: longTable.ID, UserID: users[0].ID, Date: date}
+	if _, err := first.insertAtAnySeat(store, longTable, ConnectionSeatStrategy{}); err != nil || first.SeatPosition != 0 {
+		t.Fatal("LongTableBooking.insertAtAnySeat:", first.SeatPosition, err)
+	}
+	defer first.delete(store)
+
+	// Connections sit next to each other
+	second := &LongTableBooking{LongTableID: longTable.ID, UserID: users[2].ID, Date: date}
+	if _, err := second.insertAtAnySeat(store, longTable, ConnectionSeatStrategy{}); err != nil || second.SeatPosition != 1 {
+		t.Error("LongTableBooking.insertAtAnySeat: next to a connection:", second.SeatPosition, err)
+	}
+	defer second.delete(store)
+
+	// Users still can't book twice
+	again := &LongTableBooking{LongTableID: longTable.ID, UserID: users[2].ID, Date: date}
+	if _, err := again.insertAtAnySeat(store, longTable, FirstFreeSeatStrategy{}); err != ErrUserAlreadyBooked {
+		t.Error("LongTableBooking.insertAtAnySeat: again:", err)
+	}
+}
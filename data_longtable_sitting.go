@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sitting is a named time window in which a LongTable is booked, e.g.
+// breakfast, lunch and dinner. LongTables without Sittings are booked for the
+// whole day, from their opening time to their closing time.
+type Sitting struct {
+	Name        string
+	OpeningTime string
+	ClosingTime string
+}
+
+// SittingView is the JSON representation of a Sitting
+type SittingView struct {
+	Name        string `json:"name"`
+	OpeningTime string `json:"openingTime"`
+	ClosingTime string `json:"closingTime"`
+}
+
+// Get the JSON representation of Sitting
+func (sitting *Sitting) view() SittingView {
+	return SittingView{
+		Name:        sitting.Name,
+		OpeningTime: sitting.OpeningTime,
+		ClosingTime: sitting.ClosingTime,
+	}
+}
+
+// Get the JSON representation of Sittings
+func sittingViews(sittings []Sitting) []SittingView {
+	views := []SittingView{}
+	for i := range sittings {
+		views = append(views, sittings[i].view())
+	}
+	return views
+}
+
+// Get the Sittings of LongTable, by opening time
+func (longTable *LongTable) sittings(store Store) ([]Sitting, error) {
+	fields, err := store.HGetAll(fmt.Sprint("longTable:", longTable.ID, ":sittings"))
+	if err != nil {
+		return nil, err
+	}
+
+	sittings := []Sitting{}
+	for name, hours := range fields {
+		parts := strings.SplitN(hours, "-", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidSitting
+		}
+		sittings = append(sittings, Sitting{Name: name, OpeningTime: parts[0], ClosingTime: parts[1]})
+	}
+	sort.Slice(sittings, func(i, j int) bool {
+		if sittings[i].OpeningTime != sittings[j].OpeningTime {
+			return sittings[i].OpeningTime < sittings[j].OpeningTime
+		}
+		return sittings[i].Name < sittings[j].Name
+	})
+
+	return sittings, nil
+}
+
+// Get the Sitting of LongTable with name. LongTables without Sittings only
+// have the whole day, which has no name.
+// It fails with ErrUnknownSitting if LongTable doesn't have that Sitting.
+func (longTable *LongTable) sitting(store Store, name string) (*Sitting, error) {
+	sittings, err := longTable.sittings(store)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sittings) == 0 && name == "" {
+		return &Sitting{OpeningTime: longTable.OpeningTime, ClosingTime: longTable.ClosingTime}, nil
+	}
+	for i := range sittings {
+		if sittings[i].Name == name {
+			return &sittings[i], nil
+		}
+	}
+
+	return nil, ErrUnknownSitting
+}
+
+// Add Sitting to LongTable, or change the hours of the Sitting with its name
+func (longTable *LongTable) setSitting(store Store, sitting *Sitting) error {
+	if longTable.ID == 0 {
+		return ErrMissingKey
+	}
+
+	// The name is part of keys, so it can't contain their separator
+	if sitting.Name == "" || strings.Contains(sitting.Name, ":") {
+		return ErrInvalidSitting
+	}
+	if _, err := time.Parse(TimeFormat, sitting.OpeningTime); err != nil {
+		return ErrInvalidSitting
+	}
+	if _, err := time.Parse(TimeFormat, sitting.ClosingTime); err != nil {
+		return ErrInvalidSitting
+	}
+
+	return store.HMSet(fmt.Sprint("longTable:", longTable.ID, ":sittings"), map[string]interface{}{
+		sitting.Name: sitting.OpeningTime + "-" + sitting.ClosingTime,
+	})
+}
+
+// Remove the Sitting of LongTable with name. Its bookings are kept.
+func (longTable *LongTable) removeSitting(store Store, name string) error {
+	return store.HDel(fmt.Sprint("longTable:", longTable.ID, ":sittings"), name)
+}
+
+// Check that Sitting can be booked at date, given the current time in the
+// property's timezone and how many days ahead it can be booked, if limited.
+// On the day itself, it can be booked until it closes.
+func (sitting *Sitting) checkBookingTime(date string, now time.Time, horizon int) error {
+	day, err := time.ParseInLocation(DateFormat, date, now.Location())
+	if err != nil {
+		return ErrWrongDateFormat
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if day.Before(today) {
+		return ErrBookingInPast
+	}
+	if horizon > 0 && day.After(today.AddDate(0, 0, horizon)) {
+		return ErrBookingTooFarAhead
+	}
+
+	if closingTime, ok := sitting.closingTimeAt(day); ok && !now.Before(closingTime) {
+		return ErrBookingAfterClosingTime
+	}
+
+	return nil
+}
+
+// Get when Sitting closes on day. Sittings that close at or before their
+// opening time close after midnight. Without hours, it isn't known.
+func (sitting *Sitting) closingTimeAt(day time.Time) (time.Time, bool) {
+	openingTime, err := time.Parse(TimeFormat, sitting.OpeningTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+	closingTime, err := time.Parse(TimeFormat, sitting.ClosingTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	closing := time.Date(day.Year(), day.Month(), day.Day(), closingTime.Hour(), closingTime.Minute(), 0, 0, day.Location())
+	if !closingTime.After(openingTime) {
+		closing = closing.AddDate(0, 0, 1)
+	}
+	return closing, true
+}
+
+// Get the part of the keys of LongTable bookings that identifies when they are:
+// the date, followed by the Sitting if the LongTable has them
+func sittingKey(date, sitting string) string {
+	if sitting == "" {
+		return date
+	}
+	return date + ":" + sitting
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLongTableSittings(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Sittings longTable", NumSeats: 10, OpeningTime: "07:00", ClosingTime: "23:00"}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	// Without sittings, the LongTable is booked for the whole day
+	if sitting, err := longTable.sitting(store, ""); err != nil || sitting.Name != "" || sitting.ClosingTime != "23:00" {
+		t.Error("LongTable.sitting: whole day:", sitting, err)
+	}
+
+	for _, sitting := range []Sitting{{"dinner", "19:00", "23:00"}, {"breakfast", "07:00", "10:00"}} {
+		if err := longTable.setSitting(store, &sitting); err != nil {
+			t.Error("LongTable.setSitting:", err)
+		}
+	}
+	for _, sitting := range []Sitting{{"", "07:00", "10:00"}, {"brunch:late", "11:00", "14:00"}, {"lunch", "noon", "14:00"}} {
+		if err := longTable.setSitting(store, &sitting); err != ErrInvalidSitting {
+			t.Error("LongTable.setSitting: invalid:", sitting, err)
+		}
+	}
+	if sittings, err := longTable.sittings(store); err != nil || len(sittings) != 2 || sittings[0].Name != "breakfast" {
+		t.Error("LongTable.sittings:", sittings, err)
+	}
+
+	// With sittings, one of them has to be booked
+	if _, err := longTable.sitting(store, ""); err != ErrUnknownSitting {
+		t.Error("LongTable.sitting: whole day:", err)
+	}
+	if sitting, err := longTable.sitting(store, "dinner"); err != nil || sitting.OpeningTime != "19:00" {
+		t.Error("LongTable.sitting:", sitting, err)
+	}
+
+	// The same seat can be booked at every sitting, even by the same User
+	date := "18-12-2016"
+	breakfast := &LongTableBooking{LongTableID: longTable.ID, UserID: 5000, SeatPosition: 1, Date: date, Sitting: "breakfast"}
+	dinner := &LongTableBooking{LongTableID: longTable.ID, UserID: 5000, SeatPosition: 1, Date: date, Sitting: "dinner"}
+	for _, longTableBooking := range []*LongTableBooking{breakfast, dinner} {
+		if _, err := longTableBooking.insert(store); err != nil {
+			t.Fatal("LongTableBooking.insert:", longTableBooking.Sitting, err)
+		}
+		defer longTableBooking.delete(store)
+	}
+
+	// But not twice at one sitting
+	again := &LongTableBooking{LongTableID: longTable.ID, UserID: 5000, SeatPosition: 2, Date: date, Sitting: "dinner"}
+	if _, err := again.insert(store); err != ErrUserAlreadyBooked {
+		t.Error("LongTableBooking.insert: same sitting:", err)
+	}
+
+	if available, err := longTable.isSeatAvailable(store, date, "dinner", 1); err != nil || available {
+		t.Error("LongTable.isSeatAvailable:", available, err)
+	}
+	if seats, err := longTable.fetchAvailableSeats(store, date, "breakfast"); err != nil || len(seats) != 9 {
+		t.Error("LongTable.fetchAvailableSeats:", seats, err)
+	}
+	if longTableBookings, err := getLongTableBookings(store, map[string]interface{}{"userID": 5000, "date": date}); err != nil || len(longTableBookings) != 2 {
+		t.Error("getLongTableBookings: by date:", longTableBookings, err)
+	}
+
+	// Moving a booking to another sitting moves its seat
+	if err := updateLongTableBooking(store, breakfast.ID, func(longTableBooking *LongTableBooking) {
+		longTableBooking.Sitting = "dinner"
+	}); err != ErrUserAlreadyBooked {
+		t.Error("LongTableBooking.update: to a booked sitting:", err)
+	}
+	if err := dinner.delete(store); err != nil {
+		t.Error("LongTableBooking.delete:", err)
+	}
+	if err := updateLongTableBooking(store, breakfast.ID, func(longTableBooking *LongTableBooking) {
+		longTableBooking.Sitting = "dinner"
+	}); err != nil {
+		t.Error("LongTableBooking.update:", err)
+	}
+	for sitting, numSeats := range map[string]int{"breakfast": 10, "dinner": 9} {
+		if seats, err := longTable.fetchAvailableSeats(store, date, sitting); err != nil || len(seats) != numSeats {
+			t.Error("LongTable.fetchAvailableSeats: moved:", sitting, seats, err)
+		}
+	}
+
+	// Removing a sitting keeps its bookings
+	if err := longTable.removeSitting(store, "breakfast"); err != nil {
+		t.Error("LongTable.removeSitting:", err)
+	}
+	if _, err := longTable.sitting(store, "breakfast"); err != ErrUnknownSitting {
+		t.Error("LongTable.sitting: removed:", err)
+	}
+}
+
+// Fetch a LongTableBooking, change it and update it
+func updateLongTableBooking(store Store, longTableBookingID int, change func(*LongTableBooking)) error {
+	longTableBooking := &LongTableBooking{ID: longTableBookingID}
+	if err := longTableBooking.fetch(store); err != nil {
+		return err
+	}
+	change(longTableBooking)
+	return longTableBooking.update(store)
+}
+
+func TestSittingCheckBookingTime(t *testing.T) {
+	t.Parallel()
+
+	singapore := time.FixedZone("SGT", 8*60*60)
+
+	// It's 21:30 on 18-12-2016 in Singapore, but still the 18th in UTC
+	now := time.Date(2016, 12, 18, 21, 30, 0, 0, singapore)
+
+	sitting := &Sitting{OpeningTime: "11:00", ClosingTime: "22:00"}
+	for date, expected := range map[string]error{
+		"18-12-2016": nil,
+		"17-12-2016": ErrBookingInPast,
+		"16-02-2017": nil,
+		"17-02-2017": ErrBookingTooFarAhead,
+		"2016-12-18": ErrWrongDateFormat,
+	} {
+		if err := sitting.checkBookingTime(date, now, 60); err != expected {
+			t.Error("Sitting.checkBookingTime:", date, err)
+		}
+	}
+	if err := sitting.checkBookingTime("17-02-2017", now, 0); err != nil {
+		t.Error("Sitting.checkBookingTime: no horizon:", err)
+	}
+
+	// Today can't be booked once the Sitting has closed
+	if err := sitting.checkBookingTime("18-12-2016", now.Add(time.Hour), 60); err != ErrBookingAfterClosingTime {
+		t.Error("Sitting.checkBookingTime: closed:", err)
+	}
+
+	// Unless it closes after midnight
+	sitting.ClosingTime = "02:00"
+	if err := sitting.checkBookingTime("18-12-2016", now.Add(time.Hour), 60); err != nil {
+		t.Error("Sitting.checkBookingTime: closes after midnight:", err)
+	}
+
+	// Dates are in the property's timezone; at 23:00 UTC it's already the 19th in Singapore
+	if err := sitting.checkBookingTime("18-12-2016", time.Date(2016, 12, 18, 23, 0, 0, 0, time.UTC).In(singapore), 60); err != ErrBookingInPast {
+		t.Error("Sitting.checkBookingTime: timezone:", err)
+	}
+}
@@ -1,55 +1,106 @@
 package main
 
 import (
+	"fmt"
 	"testing"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 func TestLongTable(t *testing.T) {
+	t.Parallel()
+
 	var err error
 
-	if db, err = redis.Dial("tcp", ":6379"); err != nil {
-		t.Error(err)
-	}
-	defer db.Close()
+	store := newTestStore(t)
+	defer store.Close()
 
 	// Insert longTable
-	longTable := LongTable{
-		"name":     "Some longTable",
-		"numSeats": 40,
+	longTable := &LongTable{
+		Name:     "Some longTable",
+		NumSeats: 40,
 	}
 
-	var longTableID int
-	if longTableID, err = longTable.insert(); err != nil {
+	if _, err = longTable.insert(store); err != nil {
 		t.Error("LongTable.insert:", err)
 	}
-	longTable["id"] = longTableID
 
 	// Update longTable
-	longTable["name"] = "Some longer longTable"
-	longTable["numSeats"] = 50
-	if err := longTable.update(); err != nil {
+	longTable.Name = "Some longer longTable"
+	longTable.NumSeats = 50
+	if err := longTable.update(store); err != nil {
 		t.Error("LongTable.update:", err)
 	}
 
 	// Get longTable
-	if _, err := longTable.fetch(); err != nil {
+	fetched := &LongTable{ID: longTable.ID}
+	if err := fetched.fetch(store); err != nil {
 		t.Error("LongTable.fetch:", err)
+	} else if *fetched != *longTable {
+		t.Error("LongTable.fetch:", fetched)
 	}
 
 	// Has longTable
-	if ok, _ := longTable._exists(); !ok {
+	if ok, _ := longTable._exists(store); !ok {
 		t.Error("LongTable._exists")
 	}
 
 	// Get longTables
-	if longTables, err := getLongTables(map[string]interface{}{"count": 5}); err != nil || len(longTables) < 1 {
+	if longTables, err := getLongTables(store, map[string]interface{}{"count": 5}); err != nil || len(longTables) < 1 {
 		t.Error("getLongTables")
 	}
 
 	// Delete longTable
-	if err = longTable.delete(); err != nil {
+	if err = longTable.delete(store); err != nil {
 		t.Error("LongTable.delete:", err)
 	}
 }
+
+func TestLongTableDelete(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Deleted longTable", NumSeats: 1}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+
+	// A fully booked date with a waitlist, and a calendar
+	date := "18-12-2016"
+	longTableBooking := &LongTableBooking{LongTableID: longTable.ID, UserID: 3000, Date: date}
+	if _, err := longTableBooking.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	if err := longTable.joinWaitlist(store, date, "", 3001); err != nil {
+		t.Fatal("LongTable.joinWaitlist:", err)
+	}
+	if err := longTable.setCapacity(store, "sunday", 2); err != nil {
+		t.Fatal("LongTable.setCapacity:", err)
+	}
+
+	if err := longTable.delete(store); err != nil {
+		t.Fatal("LongTable.delete:", err)
+	}
+
+	// Nothing of longTable is left
+	for _, key := range []string{
+		fmt.Sprint("longTable:", longTable.ID),
+		fmt.Sprint("longTable:", longTable.ID, ":capacity"),
+		fmt.Sprint("longTableBooking:", longTableBooking.ID),
+		fmt.Sprint("longTableBookings:", longTable.ID),
+		fmt.Sprint("longTableBookings:", longTable.ID, ":", date),
+		longTableSeatKey(longTable.ID, date, "", 0),
+		fmt.Sprint("userLongTableBookings:", longTableBooking.UserID, ":", date),
+		longTableWaitlistKey(longTable.ID, date, ""),
+		fmt.Sprint("longTableWaitlists:", longTable.ID),
+	} {
+		if exists, err := store.Exists(key); err != nil || exists {
+			t.Error("LongTable.delete: left", key, err)
+		}
+	}
+
+	// The User on the waitlist wasn't offered the seat
+	if exists, err := store.Exists(fmt.Sprint("userLongTableBookings:", 3001)); err != nil || exists {
+		t.Error("LongTable.delete: promoted the waitlist:", err)
+	}
+}
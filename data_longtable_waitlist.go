@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Join the waitlist of LongTable at date and sitting, behind the Users that
+// joined before. It fails with ErrAlreadyWaitlisted if User is already on it.
+func (longTable *LongTable) joinWaitlist(store Store, date, sitting string, userID int) error {
+	if longTable.ID == 0 || userID == 0 || date == "" {
+		return ErrMissingKey
+	}
+
+	waitlistKey := longTableWaitlistKey(longTable.ID, date, sitting)
+	if _, err := store.ZScore(waitlistKey, userID); err == nil {
+		return ErrAlreadyWaitlisted
+	} else if err != ErrNil {
+		return err
+	}
+
+	// Keep track of the waitlists of LongTable, so that they're deleted with it
+	if err := store.ZAdd(fmt.Sprint("longTableWaitlists:", longTable.ID), time.Now().Unix(), sittingKey(date, sitting)); err != nil {
+		return err
+	}
+
+	return store.ZAdd(waitlistKey, time.Now().UnixNano(), userID)
+}
+
+// Leave the waitlist of LongTable at date and sitting
+func (longTable *LongTable) leaveWaitlist(store Store, date, sitting string, userID int) error {
+	return store.ZRem(longTableWaitlistKey(longTable.ID, date, sitting), userID)
+}
+
+// Get the IDs of the Users on the waitlist of LongTable at date and sitting, in
+// the order they joined
+func (longTable *LongTable) waitlist(store Store, date, sitting string) ([]int, error) {
+	return ids(store.ZRange(longTableWaitlistKey(longTable.ID, date, sitting), 0, -1))
+}
+
+// Get the position of User on the waitlist of LongTable at date and sitting,
+// starting at 1, or 0 if User isn't on it
+func (longTable *LongTable) waitlistPosition(store Store, date, sitting string, userID int) (int, error) {
+	userIDs, err := longTable.waitlist(store, date, sitting)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range userIDs {
+		if id == userID {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Offer the seat that a deleted LongTableBooking freed to the first User on the
+// waitlist of its date and sitting. The seat is booked for that User right
+// away, as an offer that the User has to claim before it passes to the next.
+// Users who can't be booked anymore, or are blocked or unverified, are skipped,
+// and nobody is offered seats at sittings that have passed or closed.
+// Each User is taken off the waitlist in one step, so that promotions that run
+// at the same time never offer seats to the same User.
+func promoteWaitlist(store Store, freed *LongTableBooking) (*LongTableBooking, error) {
+	longTable := &LongTable{ID: freed.LongTableID}
+	if err := longTable.fetch(store); err == ErrEntityNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The seat might not be available anymore, e.g. if the LongTable closed
+	if available, err := longTable.isSeatAvailable(store, freed.Date, freed.Sitting, freed.SeatPosition); err != nil || !available {
+		return nil, err
+	}
+
+	// Nobody is offered a seat at a sitting that can't be booked anymore
+	if sitting, err := longTable.sitting(store, freed.Sitting); err == ErrUnknownSitting {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := sitting.checkBookingTime(freed.Date, time.Now(), 0); err != nil {
+		return nil, nil
+	}
+
+	waitlistKey := longTableWaitlistKey(freed.LongTableID, freed.Date, freed.Sitting)
+	for {
+		// Take the User off the waitlist, keeping its place in case the seat is taken meanwhile
+		member, joinedAt, err := store.ZPopMin(waitlistKey)
+		if err == ErrNil {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, err
+		}
+
+		user := &User{ID: userID}
+		if exists := user.exists(store, true); !exists || user.Blocked || !user.Verified {
+			continue
+		}
+
+		offer := &LongTableBooking{
+			LongTableID:  freed.LongTableID,
+			UserID:       userID,
+			SeatPosition: freed.SeatPosition,
+			Date:         freed.Date,
+			Sitting:      freed.Sitting,
+			OfferedAt:    time.Now().Unix(),
+		}
+		if _, err := offer.insert(store); err == ErrUserAlreadyBooked {
+			continue
+		} else if err != nil {
+			// Give the User their place back
+			if err := store.ZAdd(waitlistKey, joinedAt, userID); err != nil {
+				return nil, err
+			}
+			if err == ErrSeatIsUnavailable {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		// Keep track of the offer until it's claimed, and until the User has been told
+		if err := store.ZAdd("longTableOffers", offer.OfferedAt, offer.ID); err != nil {
+			return nil, err
+		}
+		if err := store.ZAdd("longTableOfferNotifications", offer.OfferedAt, offer.ID); err != nil {
+			return nil, err
+		}
+
+		return offer, nil
+	}
+}
+
+// Claim the seat that was offered to the User of LongTableBooking from the
+// waitlist, which makes it a regular booking.
+// The offer is taken in one step, so it fails with ErrNoWaitlistOffer if
+// LongTableBooking isn't an offer anymore, e.g. because it was claimed or
+// withdrawn in the meantime.
+func (longTableBooking *LongTableBooking) claimOffer(store Store) error {
+	if offeredAt, err := store.HGetDel(fmt.Sprint("longTableBooking:", longTableBooking.ID), "offeredAt"); err == ErrNil {
+		return ErrNoWaitlistOffer
+	} else if err != nil {
+		return err
+	} else if offeredAt == "" || offeredAt == "0" {
+		return ErrNoWaitlistOffer
+	}
+
+	longTableBooking.OfferedAt = 0
+	return store.ZRem("longTableOffers", longTableBooking.ID)
+}
+
+// Check if the offer of LongTableBooking has passed its claim window at now
+func (longTableBooking *LongTableBooking) offerExpired(claimWindow time.Duration, now time.Time) bool {
+	return longTableBooking.OfferedAt != 0 && !now.Before(time.Unix(longTableBooking.OfferedAt, 0).Add(claimWindow))
+}
+
+// Get the LongTableBookings that are offers which haven't been claimed, oldest first
+func pendingWaitlistOffers(store Store) ([]LongTableBooking, error) {
+	return _getLongTableBookings(store, "longTableOffers", 0, -1)
+}
+
+// Get the LongTableBookings that are offers which their Users haven't been told about
+func unnotifiedWaitlistOffers(store Store) ([]LongTableBooking, error) {
+	longTableBookingIDs, err := ids(store.ZRange("longTableOfferNotifications", 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	var longTableBookings []LongTableBooking
+	for _, longTableBookingID := range longTableBookingIDs {
+		longTableBooking := LongTableBooking{ID: longTableBookingID}
+		if err := longTableBooking.fetch(store); err == ErrEntityNotFound {
+			// The offer was declined before anyone was told
+			if err := store.ZRem("longTableOfferNotifications", longTableBookingID); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		longTableBookings = append(longTableBookings, longTableBooking)
+	}
+
+	return longTableBookings, nil
+}
+
+// Record that the User of LongTableBooking has been told about its offer
+func (longTableBooking *LongTableBooking) offerNotified(store Store) error {
+	return store.ZRem("longTableOfferNotifications", longTableBooking.ID)
+}
+
+// Key of the waitlist of a LongTable at date and sitting
+func longTableWaitlistKey(longTableID int, date, sitting string) string {
+	return fmt.Sprint("longTableWaitlist:", longTableID, ":", sittingKey(date, sitting))
+}
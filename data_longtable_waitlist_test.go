@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Get the pending waitlist offers of LongTable, leaving out those of the
+// LongTables of other tests
+func longTableWaitlistOffers(store Store, longTable *LongTable) ([]LongTableBooking, error) {
+	offers, err := pendingWaitlistOffers(store)
+	var own []LongTableBooking
+	for _, offer := range offers {
+		if offer.LongTableID == longTable.ID {
+			own = append(own, offer)
+		}
+	}
+	return own, err
+}
+
+func TestLongTableWaitlist(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Waitlist longTable", NumSeats: 1}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	var users []*User
+	for _, firstname := range []string{"Ada", "Ben", "Cas"} {
+		user := &User{Firstname: firstname, Email: firstname + ".waitlist@example.com", Verified: true}
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+		users = append(users, user)
+	}
+
+	date := time.Now().AddDate(0, 0, 1).Format(DateFormat)
+	booking := &LongTableBooking{LongTableID: longTable.ID, UserID: users[0].ID, Date: date}
+	if _, err := booking.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+
+	// The others wait in the order they joined
+	for _, user := range users[1:] {
+		if err := longTable.joinWaitlist(store, date, "", user.ID); err != nil {
+			t.Error("LongTable.joinWaitlist:", err)
+		}
+	}
+	if err := longTable.joinWaitlist(store, date, "", users[1].ID); err != ErrAlreadyWaitlisted {
+		t.Error("LongTable.joinWaitlist: again:", err)
+	}
+	if position, err := longTable.waitlistPosition(store, date, "", users[2].ID); err != nil || position != 2 {
+		t.Error("LongTable.waitlistPosition:", position, err)
+	}
+
+	// Freeing the seat offers it to the first in line
+	if err := booking.delete(store); err != nil {
+		t.Fatal("LongTableBooking.delete:", err)
+	}
+	offers, err := longTableWaitlistOffers(store, longTable)
+	if err != nil || len(offers) != 1 || offers[0].UserID != users[1].ID || offers[0].OfferedAt == 0 {
+		t.Fatal("pendingWaitlistOffers:", offers, err)
+	}
+	offer := &offers[0]
+	defer offer.delete(store)
+	if position, err := longTable.waitlistPosition(store, date, "", users[2].ID); err != nil || position != 1 {
+		t.Error("LongTable.waitlistPosition: promoted:", position, err)
+	}
+	if available, err := longTable.isSeatAvailable(store, date, "", 0); err != nil || available {
+		t.Error("LongTable.isSeatAvailable: offered:", available, err)
+	}
+	if notify, err := unnotifiedWaitlistOffers(store); err != nil || len(notify) != 1 || notify[0].ID != offer.ID {
+		t.Error("unnotifiedWaitlistOffers:", notify, err)
+	}
+	if err := offer.offerNotified(store); err != nil {
+		t.Error("LongTableBooking.offerNotified:", err)
+	}
+
+	// Claiming the offer makes it a regular booking, only once
+	stale := *offer
+	if err := offer.claimOffer(store); err != nil {
+		t.Error("LongTableBooking.claimOffer:", err)
+	}
+	if err := stale.claimOffer(store); err != ErrNoWaitlistOffer {
+		t.Error("LongTableBooking.claimOffer: again:", err)
+	}
+	if offers, err := longTableWaitlistOffers(store, longTable); err != nil || len(offers) != 0 {
+		t.Error("pendingWaitlistOffers: claimed:", offers, err)
+	}
+	if fetched := (&LongTableBooking{ID: offer.ID}); !fetched.exists(store, true) || fetched.OfferedAt != 0 {
+		t.Error("LongTableBooking.fetch: claimed:", fetched)
+	}
+
+	// Leaving empties the waitlist
+	if err := longTable.leaveWaitlist(store, date, "", users[2].ID); err != nil {
+		t.Error("LongTable.leaveWaitlist:", err)
+	}
+	if userIDs, err := longTable.waitlist(store, date, ""); err != nil || len(userIDs) != 0 {
+		t.Error("LongTable.waitlist:", userIDs, err)
+	}
+
+	// Seats freed at dates that have passed aren't offered
+	past := &LongTableBooking{LongTableID: longTable.ID, UserID: users[0].ID, Date: "18-12-2016"}
+	if _, err := past.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert: past:", err)
+	}
+	if err := longTable.joinWaitlist(store, past.Date, "", users[2].ID); err != nil {
+		t.Error("LongTable.joinWaitlist: past:", err)
+	}
+	if err := past.delete(store); err != nil {
+		t.Error("LongTableBooking.delete: past:", err)
+	}
+	if offers, err := longTableWaitlistOffers(store, longTable); err != nil || len(offers) != 0 {
+		t.Error("pendingWaitlistOffers: past:", offers, err)
+	}
+	if userIDs, err := longTable.waitlist(store, past.Date, ""); err != nil || len(userIDs) != 1 {
+		t.Error("LongTable.waitlist: past:", userIDs, err)
+	}
+}
+
+func TestLongTableWaitlistConcurrentPromotion(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	longTable := &LongTable{Name: "Concurrent waitlist longTable", NumSeats: 2}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	// Blocked and unverified Users are first in line, but are skipped
+	date := time.Now().AddDate(0, 0, 1).Format(DateFormat)
+	var users []*User
+	for _, firstname := range []string{"Gil", "Hal", "Ivy", "Jo", "Kit"} {
+		user := &User{Firstname: firstname, Email: firstname + ".concurrent@example.com", Verified: firstname != "Hal"}
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+		if err := longTable.joinWaitlist(store, date, "", user.ID); err != nil {
+			t.Fatal("LongTable.joinWaitlist:", err)
+		}
+		users = append(users, user)
+	}
+	if err := users[0].setBlocked(store, true); err != nil {
+		t.Fatal("User.setBlocked:", err)
+	}
+
+	// Both seats are freed at once
+	var wait sync.WaitGroup
+	promoted := make([]*LongTableBooking, 2)
+	for seat := range promoted {
+		wait.Add(1)
+		go func(seat int) {
+			defer wait.Done()
+			freed := &LongTableBooking{LongTableID: longTable.ID, SeatPosition: seat, Date: date}
+			offer, err := promoteWaitlist(store, freed)
+			if err != nil || offer == nil {
+				t.Error("promoteWaitlist:", seat, offer, err)
+				return
+			}
+			promoted[seat] = offer
+		}(seat)
+	}
+	wait.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Each seat goes to a different User, and the last one keeps waiting
+	offered := map[int]bool{promoted[0].UserID: true, promoted[1].UserID: true}
+	if !offered[users[2].ID] || !offered[users[3].ID] {
+		t.Error("promoteWaitlist: offered to:", promoted[0].UserID, promoted[1].UserID)
+	}
+	if userIDs, err := longTable.waitlist(store, date, ""); err != nil || len(userIDs) != 1 || userIDs[0] != users[4].ID {
+		t.Error("LongTable.waitlist:", userIDs, err)
+	}
+
+	if err := longTable.leaveWaitlist(store, date, "", users[4].ID); err != nil {
+		t.Error("LongTable.leaveWaitlist:", err)
+	}
+	for _, offer := range promoted {
+		if err := offer.delete(store); err != nil {
+			t.Error("LongTableBooking.delete:", err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Content types accepted for Media, with the file extension they're stored with
+var mediaContentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+type Media struct {
+	ID          int    `redis:"id"`
+	UserID      int    `redis:"userID"`
+	Collection  string `redis:"collection"`
+	Name        string `redis:"name"`
+	Filename    string `redis:"filename"`
+	ContentType string `redis:"contentType"`
+	Size        int64  `redis:"size"`
+	CreatedAt   int64  `redis:"createdAt"`
+	UpdatedAt   int64  `redis:"updatedAt"`
+}
+
+// MediaView is the JSON representation of a Media
+type MediaView struct {
+	ID          int    `json:"id"`
+	Collection  string `json:"collection"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	CreatedAt   int64  `json:"createdAt"`
+	UpdatedAt   int64  `json:"updatedAt"`
+}
+
+// Get the JSON representation of Media
+func (media *Media) view() MediaView {
+	return MediaView{
+		ID:          media.ID,
+		Collection:  media.Collection,
+		Name:        media.Name,
+		URL:         fmt.Sprint("/media/", media.ID),
+		ContentType: media.ContentType,
+		Size:        media.Size,
+		CreatedAt:   media.CreatedAt,
+		UpdatedAt:   media.UpdatedAt,
+	}
+}
+
+// Get the JSON representation of Media items
+func mediaViews(media []Media) []MediaView {
+	views := []MediaView{}
+	for i := range media {
+		views = append(views, media[i].view())
+	}
+	return views
+}
+
+// Check if Media exists, with an option to fetch it
+func (media *Media) exists(store Store, fetch bool) bool {
+	// Check if the Media exists and retrieve it
+	if fetch {
+		return media.fetch(store) == nil
+
+		// Just check if the Media exists
+	} else {
+		ok, err := media._exists(store)
+		return ok && err == nil
+	}
+}
+
+// Check if Media exists
+func (media *Media) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("media:", media.ID))
+}
+
+// Fetch Media by its ID
+func (media *Media) fetch(store Store) error {
+	if media.ID == 0 {
+		return ErrMissingKey
+	}
+
+	if fields, err := store.HGetAll(fmt.Sprint("media:", media.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else {
+		return scanHash(fields, media)
+	}
+}
+
+// Insert Media with specified parameters
+func (media *Media) insert(store Store) (int, error) {
+	if media.Collection == "" || media.Filename == "" {
+		return 0, ErrMissingKey
+	}
+	if _, ok := mediaContentTypes[media.ContentType]; !ok {
+		return 0, ErrUnsupportedMediaType
+	}
+
+	mediaID, err := store.Incr("nextMediaID")
+	if err != nil {
+		return 0, err
+	}
+	media.ID = mediaID
+
+	now := time.Now().Unix()
+
+	// Set media
+	media.CreatedAt = now
+	if err := store.HMSet(fmt.Sprint("media:", mediaID), hashFields(media)); err != nil {
+		return 0, err
+	}
+
+	// Add media to mediaCollection:[collection] list
+	if err := store.ZAdd(fmt.Sprint("mediaCollection:", media.Collection), now, mediaID); err != nil {
+		return 0, err
+	}
+
+	return mediaID, nil
+}
+
+// Delete Media with specified parameters.
+// The file itself is left to the caller.
+func (media *Media) delete(store Store) error {
+	// Fetch the rest of the Media so that all its references can be removed
+	if err := media.fetch(store); err != nil {
+		return err
+	}
+
+	// Delete media
+	if err := store.Del(fmt.Sprint("media:", media.ID)); err != nil {
+		return err
+	}
+
+	// Remove media from mediaCollection:[collection] list
+	if err := store.ZRem(fmt.Sprint("mediaCollection:", media.Collection), media.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Update Media with specified parameters
+func (media *Media) update(store Store) error {
+	if media.ID == 0 || media.Collection == "" {
+		return ErrMissingKey
+	}
+
+	// Fetch the stored Media to find the collection it's currently in
+	stored := Media{ID: media.ID}
+	if err := stored.fetch(store); err != nil {
+		return err
+	}
+
+	media.UpdatedAt = time.Now().Unix()
+
+	// Update media
+	if err := store.HMSet(fmt.Sprint("media:", media.ID), hashFields(media)); err != nil {
+		return err
+	}
+
+	// Move media to its new collection
+	if media.Collection != stored.Collection {
+		if err := store.ZRem(fmt.Sprint("mediaCollection:", stored.Collection), media.ID); err != nil {
+			return err
+		}
+		if err := store.ZAdd(fmt.Sprint("mediaCollection:", media.Collection), stored.CreatedAt, media.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the Media in a collection, in the order they were added
+func getMediaCollection(store Store, collection string) ([]Media, error) {
+	var media []Media
+
+	if mediaIDs, err := ids(store.ZRange(fmt.Sprint("mediaCollection:", collection), 0, -1)); err != nil {
+		return nil, err
+	} else {
+		for _, mediaID := range mediaIDs {
+			item := Media{ID: mediaID}
+			if err = item.fetch(store); err != nil {
+				return nil, err
+			}
+			media = append(media, item)
+		}
+	}
+
+	return media, nil
+}
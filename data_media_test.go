@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestMedia(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	// Insert media
+	menu := &Media{UserID: 2000, Collection: "test-menu", Name: "Menu", Filename: "menu.pdf", ContentType: "application/pdf"}
+	photo := &Media{UserID: 2000, Collection: "test-menu", Name: "Lobby", Filename: "lobby.jpg", ContentType: "image/jpeg"}
+	for _, media := range []*Media{menu, photo} {
+		if _, err = media.insert(store); err != nil {
+			t.Fatal("Media.insert:", err)
+		}
+	}
+
+	// Only images and PDFs are accepted
+	script := &Media{Collection: "test-menu", Filename: "x.html", ContentType: "text/html; charset=utf-8"}
+	if _, err = script.insert(store); err != ErrUnsupportedMediaType {
+		t.Error("Media.insert: unsupported type:", err)
+	}
+
+	// Move the photo to the gallery
+	photo.Collection = "test-gallery"
+	if err = photo.update(store); err != nil {
+		t.Error("Media.update:", err)
+	}
+
+	// Get media by collection
+	if media, err := getMediaCollection(store, "test-menu"); err != nil || len(media) != 1 || media[0].ID != menu.ID {
+		t.Error("getMediaCollection:", media, err)
+	}
+	if media, err := getMediaCollection(store, "test-gallery"); err != nil || len(media) != 1 || media[0].ContentType != "image/jpeg" {
+		t.Error("getMediaCollection:", media, err)
+	}
+
+	// Delete media
+	for _, media := range []*Media{menu, photo} {
+		if err = media.delete(store); err != nil {
+			t.Error("Media.delete:", err)
+		}
+	}
+	if ok, _ := photo._exists(store); ok {
+		t.Error("Media._exists")
+	}
+}
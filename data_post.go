@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of Posts
+var postTypes = []string{"offer", "event", "review"}
+
+type Post struct {
+	ID          int    `redis:"id"`
+	Type        string `redis:"type"`
+	UserID      int    `redis:"userID"`
+	Title       string `redis:"title"`
+	Description string `redis:"description"`
+	Link        string `redis:"link"`
+	ImageURL    string `redis:"imageURL"`
+	CreatedAt   int64  `redis:"createdAt"`
+	UpdatedAt   int64  `redis:"updatedAt"`
+
+	// Stored as a separate hash, e.g. the date of an event or the rating of a review
+	Meta map[string]string `redis:"-"`
+}
+
+// PostView is the JSON representation of a Post
+type PostView struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	UserID      int               `json:"userID"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Link        string            `json:"link"`
+	ImageURL    string            `json:"imageURL"`
+	CreatedAt   int64             `json:"createdAt"`
+	UpdatedAt   int64             `json:"updatedAt"`
+	Meta        map[string]string `json:"meta"`
+}
+
+// Get the JSON representation of Post
+func (post *Post) view() PostView {
+	return PostView{
+		ID:          post.ID,
+		Type:        post.Type,
+		UserID:      post.UserID,
+		Title:       post.Title,
+		Description: post.Description,
+		Link:        post.Link,
+		ImageURL:    post.ImageURL,
+		CreatedAt:   post.CreatedAt,
+		UpdatedAt:   post.UpdatedAt,
+		Meta:        post.Meta,
+	}
+}
+
+// Get the JSON representation of Posts
+func postViews(posts []Post) []PostView {
+	views := []PostView{}
+	for i := range posts {
+		views = append(views, posts[i].view())
+	}
+	return views
+}
+
+// Check if Post exists, with an option to fetch it
+func (post *Post) exists(store Store, fetch bool) bool {
+	// Check if the Post exists and retrieve it
+	if fetch {
+		return post.fetch(store) == nil
+
+		// Just check if the Post exists
+	} else {
+		ok, err := post._exists(store)
+		return ok && err == nil
+	}
+}
+
+// Check if Post exists
+func (post *Post) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("post:", post.ID))
+}
+
+// Fetch Post by its ID
+func (post *Post) fetch(store Store) error {
+	if post.ID == 0 {
+		return ErrMissingKey
+	}
+
+	if fields, err := store.HGetAll(fmt.Sprint("post:", post.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else if err := scanHash(fields, post); err != nil {
+		return err
+	}
+
+	// Get Post meta
+	meta, err := store.HGetAll(fmt.Sprint("post:", post.ID, ":meta"))
+	if err != nil {
+		return err
+	}
+	post.Meta = meta
+
+	return nil
+}
+
+// Insert Post with specified parameters
+func (post *Post) insert(store Store) (int, error) {
+	if !contains(postTypes, post.Type) {
+		return 0, ErrInvalidPostType
+	}
+
+	postID, err := store.Incr("nextPostID")
+	if err != nil {
+		return 0, err
+	}
+	post.ID = postID
+
+	now := time.Now().Unix()
+
+	// Set post
+	post.CreatedAt = now
+	if err := store.HMSet(fmt.Sprint("post:", postID), hashFields(post)); err != nil {
+		return 0, err
+	}
+
+	// Set post meta
+	if err := post.setMeta(store); err != nil {
+		return 0, err
+	}
+
+	// Add post to posts list
+	if err := store.ZAdd("posts", now, postID); err != nil {
+		return 0, err
+	}
+
+	// Add post to posts:[type] list
+	if err := store.ZAdd(fmt.Sprint("posts:", post.Type), now, postID); err != nil {
+		return 0, err
+	}
+
+	return postID, nil
+}
+
+// Delete Post with specified parameters
+func (post *Post) delete(store Store) error {
+	// Fetch the rest of the Post so that all its references can be removed
+	if err := post.fetch(store); err != nil {
+		return err
+	}
+
+	// Delete post and its meta
+	if err := store.Del(fmt.Sprint("post:", post.ID), fmt.Sprint("post:", post.ID, ":meta")); err != nil {
+		return err
+	}
+
+	// Remove post from posts list
+	if err := store.ZRem("posts", post.ID); err != nil {
+		return err
+	}
+
+	// Remove post from posts:[type] list
+	if err := store.ZRem(fmt.Sprint("posts:", post.Type), post.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Update Post with specified parameters.
+// The meta is replaced unless it's nil.
+func (post *Post) update(store Store) error {
+	if post.ID == 0 {
+		return ErrMissingKey
+	}
+	if !contains(postTypes, post.Type) {
+		return ErrInvalidPostType
+	}
+
+	// Fetch the stored Post to find the type list it's currently in
+	stored := Post{ID: post.ID}
+	if err := stored.fetch(store); err != nil {
+		return err
+	}
+
+	post.UpdatedAt = time.Now().Unix()
+
+	// Update post
+	if err := store.HMSet(fmt.Sprint("post:", post.ID), hashFields(post)); err != nil {
+		return err
+	}
+
+	// Replace post meta
+	if post.Meta != nil {
+		if err := store.Del(fmt.Sprint("post:", post.ID, ":meta")); err != nil {
+			return err
+		}
+		if err := post.setMeta(store); err != nil {
+			return err
+		}
+	}
+
+	// Move post to the list of its new type
+	if post.Type != stored.Type {
+		if err := store.ZRem(fmt.Sprint("posts:", stored.Type), post.ID); err != nil {
+			return err
+		}
+		if err := store.ZAdd(fmt.Sprint("posts:", post.Type), stored.CreatedAt, post.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set Post meta
+func (post *Post) setMeta(store Store) error {
+	if len(post.Meta) == 0 {
+		return nil
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range post.Meta {
+		fields[k] = v
+	}
+	return store.HMSet(fmt.Sprint("post:", post.ID, ":meta"), fields)
+}
+
+// Get a page of Posts matching specified parameters, newest first
+func getPosts(store Store, params map[string]interface{}) ([]Post, error) {
+	count, _ := params["count"].(int)
+	page, _ := params["page"].(int)
+	if count <= 0 {
+		count = 20
+	}
+	if page <= 0 {
+		page = 1
+	}
+
+	start := (page - 1) * count
+	stop := start + count - 1
+
+	if postType, _ := params["type"].(string); postType != "" {
+		if !contains(postTypes, postType) {
+			return nil, ErrInvalidPostType
+		}
+		return _getPosts(store, fmt.Sprint("posts:", postType), start, stop)
+	}
+
+	return _getPosts(store, "posts", start, stop)
+}
+
+// Get Posts in the specified range of a sorted set of Post IDs, newest first
+func _getPosts(store Store, key string, start, stop int) ([]Post, error) {
+	var posts []Post
+
+	if postIDs, err := ids(store.ZRevRange(key, start, stop)); err != nil {
+		return nil, err
+	} else {
+		for _, postID := range postIDs {
+			post := Post{ID: postID}
+			if err = post.fetch(store); err != nil {
+				return nil, err
+			}
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPost(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	// Insert posts
+	offer := &Post{Type: "offer", UserID: 2000, Title: "Half-price breakfast"}
+	event := &Post{Type: "event", UserID: 2000, Title: "Movie night", Meta: map[string]string{"date": "01-05-2030"}}
+	review := &Post{Type: "review", UserID: 2000, Title: "Great stay"}
+	for _, post := range []*Post{offer, event, review} {
+		if _, err = post.insert(store); err != nil {
+			t.Fatal("Post.insert:", err)
+		}
+	}
+
+	// Posts must have a known type
+	if _, err = (&Post{Type: "rumour", Title: "?"}).insert(store); err != ErrInvalidPostType {
+		t.Error("Post.insert: invalid type:", err)
+	}
+
+	// Update review into an offer and replace the event meta
+	review.Type = "offer"
+	if err = review.update(store); err != nil {
+		t.Error("Post.update:", err)
+	}
+	event.Meta = map[string]string{"location": "Rooftop"}
+	if err = event.update(store); err != nil {
+		t.Error("Post.update:", err)
+	}
+
+	// Fetch post
+	fetched := &Post{ID: event.ID}
+	if err = fetched.fetch(store); err != nil {
+		t.Error("Post.fetch:", err)
+	} else if fetched.Title != "Movie night" || len(fetched.Meta) != 1 || fetched.Meta["location"] != "Rooftop" {
+		t.Error("Post.fetch:", fetched)
+	}
+
+	// Get posts by type, one per page
+	seen := map[int]bool{}
+	for page := 1; page <= 3; page++ {
+		posts, err := getPosts(store, map[string]interface{}{"type": "offer", "page": page, "count": 1})
+		if err != nil {
+			t.Error("getPosts:", err)
+		} else if page <= 2 && (len(posts) != 1 || posts[0].Type != "offer" || seen[posts[0].ID]) {
+			t.Error("getPosts: page", page, posts)
+		} else if page == 3 && len(posts) != 0 {
+			t.Error("getPosts: page", page, posts)
+		} else if len(posts) == 1 {
+			seen[posts[0].ID] = true
+		}
+	}
+	if posts, err := getPosts(store, map[string]interface{}{"type": "review"}); err != nil || len(posts) != 0 {
+		t.Error("getPosts: review", posts, err)
+	}
+
+	// Delete posts
+	for _, post := range []*Post{offer, event, review} {
+		if err = post.delete(store); err != nil {
+			t.Error("Post.delete:", err)
+		}
+	}
+	if ok, _ := store.Exists(fmt.Sprint("post:", event.ID, ":meta")); ok {
+		t.Error("Post.delete: meta")
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type Room struct {
+	ID        int    `redis:"id"`
+	UserID    int    `redis:"userID"`
+	Name      string `redis:"name"`
+	Type      string `redis:"type"`
+	Capacity  int    `redis:"capacity"`
+	CreatedAt int64  `redis:"createdAt"`
+	UpdatedAt int64  `redis:"updatedAt"`
+}
+
+// RoomView is the JSON representation of a Room
+type RoomView struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Capacity  int    `json:"capacity"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Get the JSON representation of Room
+func (room *Room) view() RoomView {
+	return RoomView{
+		ID:        room.ID,
+		Name:      room.Name,
+		Type:      room.Type,
+		Capacity:  room.Capacity,
+		CreatedAt: room.CreatedAt,
+		UpdatedAt: room.UpdatedAt,
+	}
+}
+
+// Get the JSON representation of Rooms
+func roomViews(rooms []Room) []RoomView {
+	views := []RoomView{}
+	for i := range rooms {
+		views = append(views, rooms[i].view())
+	}
+	return views
+}
+
+// Check if Room exists, with an option to fetch it
+func (room *Room) exists(store Store, fetch bool) bool {
+	// Check if the Room exists and retrieve it
+	if fetch {
+		return room.fetch(store) == nil
+
+		// Just check if the Room exists
+	} else {
+		ok, err := room._exists(store)
+		return ok && err == nil
+	}
+}
+
+// Check if Room exists
+func (room *Room) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("room:", room.ID))
+}
+
+// Fetch Room by its ID
+func (room *Room) fetch(store Store) error {
+	if room.ID == 0 {
+		return ErrMissingKey
+	}
+
+	if fields, err := store.HGetAll(fmt.Sprint("room:", room.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else {
+		return scanHash(fields, room)
+	}
+}
+
+// Insert Room with specified parameters
+func (room *Room) insert(store Store) (int, error) {
+	roomID, err := store.Incr("nextRoomID")
+	if err != nil {
+		return 0, err
+	}
+	room.ID = roomID
+
+	now := time.Now().Unix()
+
+	// Set room
+	room.CreatedAt = now
+	if err := store.HMSet(fmt.Sprint("room:", roomID), hashFields(room)); err != nil {
+		return 0, err
+	}
+
+	// Add room to rooms list
+	if err := store.ZAdd("rooms", now, roomID); err != nil {
+		return 0, err
+	}
+
+	// Add room to rooms:[type] list
+	if err := store.ZAdd(fmt.Sprint("rooms:", room.Type), now, roomID); err != nil {
+		return 0, err
+	}
+
+	return roomID, nil
+}
+
+// Delete Room with specified parameters, and its RoomBookings
+func (room *Room) delete(store Store) error {
+	// Fetch the rest of the Room so that all its references can be removed
+	if err := room.fetch(store); err != nil {
+		return err
+	}
+
+	// Delete room
+	if err := store.Del(fmt.Sprint("room:", room.ID)); err != nil {
+		return err
+	}
+
+	// Remove room from rooms list
+	if err := store.ZRem("rooms", room.ID); err != nil {
+		return err
+	}
+
+	// Remove room from rooms:[type] list
+	if err := store.ZRem(fmt.Sprint("rooms:", room.Type), room.ID); err != nil {
+		return err
+	}
+
+	// Delete the roomBookings of room, which releases their nights
+	roomBookingIDs, err := ids(store.ZRange(fmt.Sprint("room:", room.ID, ":bookings"), 0, -1))
+	if err != nil {
+		return err
+	}
+	for _, roomBookingID := range roomBookingIDs {
+		if err := (&RoomBooking{ID: roomBookingID}).delete(store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+	if err := store.Del(fmt.Sprint("room:", room.ID, ":bookings")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Check if Room has RoomBookings that haven't checked out at now
+func (room *Room) hasUpcomingBookings(store Store, now time.Time) (bool, error) {
+	roomBookings, err := _getRoomBookings(store, fmt.Sprint("room:", room.ID, ":bookings"), 0, -1)
+	if err != nil {
+		return false, err
+	}
+
+	today, err := parseDate(now.Format(DateFormat))
+	if err != nil {
+		return false, err
+	}
+	for _, roomBooking := range roomBookings {
+		if checkout, err := parseDate(roomBooking.CheckoutDate); err != nil {
+			return false, err
+		} else if checkout.After(today) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Update Room with specified parameters
+func (room *Room) update(store Store) error {
+	if room.ID == 0 {
+		return ErrMissingKey
+	}
+
+	// Fetch the stored Room to find the type list it's currently in
+	stored := Room{ID: room.ID}
+	if err := stored.fetch(store); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	room.UpdatedAt = now
+
+	// Update room
+	if err := store.HMSet(fmt.Sprint("room:", room.ID), hashFields(room)); err != nil {
+		return err
+	}
+
+	// Move room to the list of its new type
+	if room.Type != stored.Type {
+		if err := store.ZRem(fmt.Sprint("rooms:", stored.Type), room.ID); err != nil {
+			return err
+		}
+		if err := store.ZAdd(fmt.Sprint("rooms:", room.Type), stored.CreatedAt, room.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get Rooms matching specified parameters
+func getRooms(store Store, params map[string]interface{}) ([]Room, error) {
+	count, _ := params["count"].(int)
+
+	if roomType, _ := params["type"].(string); roomType != "" {
+		return _getRooms(store, fmt.Sprint("rooms:", roomType), 0, count-1)
+	}
+
+	return _getRooms(store, "rooms", 0, count-1)
+}
+
+// Get Rooms in the specified range of a sorted set of Room IDs
+func _getRooms(store Store, key string, start, stop int) ([]Room, error) {
+	var rooms []Room
+
+	if roomIDs, err := ids(store.ZRange(key, start, stop)); err != nil {
+		return nil, err
+	} else {
+		for _, roomID := range roomIDs {
+			room := Room{ID: roomID}
+			if err = room.fetch(store); err != nil {
+				return nil, err
+			}
+			rooms = append(rooms, room)
+		}
+	}
+
+	return rooms, nil
+}
+
+// Get Rooms matching specified parameters that are free for every night from
+// checkinDate up to checkoutDate, and fit at least the number of 'guests'
+func getAvailableRooms(store Store, checkinDate, checkoutDate string, params map[string]interface{}) ([]Room, error) {
+	nights, err := stayNights(checkinDate, checkoutDate)
+	if err != nil {
+		return nil, err
+	}
+
+	guests, _ := params["guests"].(int)
+
+	rooms, err := getRooms(store, map[string]interface{}{"type": params["type"]})
+	if err != nil {
+		return nil, err
+	}
+
+	availableRooms := []Room{}
+	for i := range rooms {
+		if rooms[i].Capacity < guests {
+			continue
+		}
+		if available, err := rooms[i].isAvailable(store, nights); err != nil {
+			return nil, err
+		} else if available {
+			availableRooms = append(availableRooms, rooms[i])
+		}
+	}
+
+	return availableRooms, nil
+}
+
+// Check if Room is free for all of the nights
+func (room *Room) isAvailable(store Store, nights []string) (bool, error) {
+	for _, key := range roomNightKeys(room.ID, nights) {
+		if taken, err := store.Exists(key); err != nil {
+			return false, err
+		} else if taken {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Get the dates of the nights of a stay, from the checkin date up to the day
+// before the checkout date
+func stayNights(checkinDate, checkoutDate string) ([]string, error) {
+	checkin, err := parseDate(checkinDate)
+	if err != nil {
+		return nil, ErrWrongDateFormat
+	}
+	checkout, err := parseDate(checkoutDate)
+	if err != nil {
+		return nil, ErrWrongDateFormat
+	}
+
+	// Check if checkout is after checkin
+	if !checkout.After(checkin) {
+		return nil, ErrCheckoutBeforeCheckin
+	}
+
+	var nights []string
+	for night := checkin; night.Before(checkout); night = night.AddDate(0, 0, 1) {
+		if len(nights) == maxRoomBookingNights {
+			return nil, ErrStayTooLong
+		}
+		nights = append(nights, night.Format(DateFormat))
+	}
+
+	return nights, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Longest stay that can be booked at once
+const maxRoomBookingNights = 90
+
+type RoomBooking struct {
+	ID           int    `redis:"id"`
+	UserID       int    `redis:"userID"`
+	RoomID       int    `redis:"roomID"`
+	CheckinDate  string `redis:"checkinDate"`
+	CheckoutDate string `redis:"checkoutDate"`
+	CreatedAt    int64  `redis:"createdAt"`
+	UpdatedAt    int64  `redis:"updatedAt"`
+}
+
+// RoomBookingView is the JSON representation of a RoomBooking
+type RoomBookingView struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"userID"`
+	RoomID       int    `json:"roomID"`
+	CheckinDate  string `json:"checkinDate"`
+	CheckoutDate string `json:"checkoutDate"`
+	CreatedAt    int64  `json:"createdAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+}
+
+// Get the JSON representation of RoomBooking
+func (roomBooking *RoomBooking) view() RoomBookingView {
+	return RoomBookingView{
+		ID:           roomBooking.ID,
+		UserID:       roomBooking.UserID,
+		RoomID:       roomBooking.RoomID,
+		CheckinDate:  roomBooking.CheckinDate,
+		CheckoutDate: roomBooking.CheckoutDate,
+		CreatedAt:    roomBooking.CreatedAt,
+		UpdatedAt:    roomBooking.UpdatedAt,
+	}
+}
+
+// Get the JSON representation of RoomBookings
+func roomBookingViews(roomBookings []RoomBooking) []RoomBookingView {
+	views := []RoomBookingView{}
+	for i := range roomBookings {
+		views = append(views, roomBookings[i].view())
+	}
+	return views
+}
+
+// Check if RoomBooking exists, with an option to fetch it
+func (roomBooking *RoomBooking) exists(store Store, fetch bool) bool {
+	// Check if the roomBooking exists and retrieve it
+	if fetch {
+		return roomBooking.fetch(store) == nil
+
+		// Just check if the roomBooking exists
+	} else {
+		ok, err := roomBooking._exists(store)
+		return ok && err == nil
+	}
+}
+
+// Check if RoomBooking exists
+func (roomBooking *RoomBooking) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("roomBooking:", roomBooking.ID))
+}
+
+// Fetch RoomBooking by its ID
+func (roomBooking *RoomBooking) fetch(store Store) error {
+	if roomBooking.ID == 0 {
+		return ErrMissingKey
+	}
+
+	if fields, err := store.HGetAll(fmt.Sprint("roomBooking:", roomBooking.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else {
+		return scanHash(fields, roomBooking)
+	}
+}
+
+// Get the dates of the nights of the stay
+func (roomBooking *RoomBooking) nights() ([]string, error) {
+	return stayNights(roomBooking.CheckinDate, roomBooking.CheckoutDate)
+}
+
+// Insert RoomBooking with specified parameters.
+// Every night of the stay is claimed atomically, so it fails with
+// ErrRoomIsUnavailable if another stay in the Room overlaps it.
+func (roomBooking *RoomBooking) insert(store Store) (int, error) {
+	if roomBooking.RoomID == 0 || roomBooking.UserID == 0 {
+		return 0, ErrMissingKey
+	}
+
+	nights, err := roomBooking.nights()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	roomBooking.CreatedAt = now
+
+	roomBookingID, err := store.Reserve(&Reservation{
+		Counter: "nextRoomBookingID",
+		Prefix:  "roomBooking:",
+		Fields:  hashFields(roomBooking),
+		Claims:  roomNightKeys(roomBooking.RoomID, nights),
+		Indexes: []string{fmt.Sprint("roomBookings:", roomBooking.UserID), fmt.Sprint("room:", roomBooking.RoomID, ":bookings")},
+		Score:   now,
+	})
+	if err != nil {
+		return 0, roomBookingError(err)
+	}
+
+	roomBooking.ID = roomBookingID
+
+	return roomBookingID, nil
+}
+
+// Delete RoomBooking with specified parameters
+func (roomBooking *RoomBooking) delete(store Store) error {
+	// Fetch the rest of the RoomBooking so that all its references can be removed
+	if err := roomBooking.fetch(store); err != nil {
+		return err
+	}
+
+	nights, err := roomBooking.nights()
+	if err != nil {
+		return err
+	}
+
+	// Delete roomBooking
+	if err := store.Del(fmt.Sprint("roomBooking:", roomBooking.ID)); err != nil {
+		return err
+	}
+
+	// Release the nights of the stay
+	if err := store.Del(roomNightKeys(roomBooking.RoomID, nights)...); err != nil {
+		return err
+	}
+
+	// Remove roomBooking from roomBookings list
+	if err := store.ZRem(fmt.Sprint("roomBookings:", roomBooking.UserID), roomBooking.ID); err != nil {
+		return err
+	}
+
+	// Remove roomBooking from room:[roomID]:bookings list
+	if err := store.ZRem(fmt.Sprint("room:", roomBooking.RoomID, ":bookings"), roomBooking.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Update RoomBooking with specified parameters.
+// Changing the dates moves the night claims, failing with ErrRoomIsUnavailable
+// like insert does.
+func (roomBooking *RoomBooking) update(store Store) error {
+	if roomBooking.ID == 0 {
+		return ErrMissingKey
+	}
+
+	// Fetch the stored RoomBooking to find the nights it currently holds
+	stored := RoomBooking{ID: roomBooking.ID}
+	if err := stored.fetch(store); err != nil {
+		return err
+	}
+
+	// The Room and User of a booking can't be changed
+	if roomBooking.RoomID != stored.RoomID || roomBooking.UserID != stored.UserID {
+		return ErrIDMismach
+	}
+
+	nights, err := roomBooking.nights()
+	if err != nil {
+		return err
+	}
+	oldNights, err := stored.nights()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	roomBooking.UpdatedAt = now
+
+	// Release the nights that are no longer part of the stay
+	claims := roomNightKeys(roomBooking.RoomID, nights)
+	var release []string
+	for _, key := range roomNightKeys(stored.RoomID, oldNights) {
+		if !contains(claims, key) {
+			release = append(release, key)
+		}
+	}
+
+	if _, err := store.Reserve(&Reservation{
+		ID:      roomBooking.ID,
+		Prefix:  "roomBooking:",
+		Fields:  hashFields(roomBooking),
+		Claims:  claims,
+		Release: release,
+		Score:   now,
+	}); err != nil {
+		return roomBookingError(err)
+	}
+
+	return nil
+}
+
+// Get RoomBookings matching specified parameters
+func getRoomBookings(store Store, params map[string]interface{}) ([]RoomBooking, error) {
+	var count int
+
+	if _count, ok := params["count"]; ok {
+		switch v := _count.(type) {
+		case int:
+			count = v
+		case string:
+			if v == "" {
+				count = 100
+			} else {
+				if _count, err := strconv.Atoi(v); err != nil {
+					return nil, err
+				} else {
+					count = _count
+				}
+			}
+		}
+	}
+
+	if userID, ok := params["userID"]; ok {
+		return _getRoomBookings(store, fmt.Sprint("roomBookings:", userID), 0, count-1)
+	} else if roomID, ok := params["roomID"]; ok {
+		return _getRoomBookings(store, fmt.Sprint("room:", roomID, ":bookings"), 0, count-1)
+	}
+
+	return nil, ErrMissingKey
+}
+
+// Get RoomBookings in the specified range of a sorted set of RoomBooking IDs
+func _getRoomBookings(store Store, key string, start, stop int) ([]RoomBooking, error) {
+	var roomBookings []RoomBooking
+
+	if roomBookingIDs, err := ids(store.ZRange(key, start, stop)); err != nil {
+		return nil, err
+	} else {
+		for _, roomBookingID := range roomBookingIDs {
+			roomBooking := RoomBooking{ID: roomBookingID}
+			if err = roomBooking.fetch(store); err != nil {
+				return nil, err
+			}
+			roomBookings = append(roomBookings, roomBooking)
+		}
+	}
+
+	return roomBookings, nil
+}
+
+// Add the RoomBookings of every User to the lists of their Rooms, which
+// RoomBookings from before those lists aren't in yet
+func indexRoomBookingsByRoom(store Store) error {
+	userIDs, err := ids(store.ZRange("users", 0, -1))
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		roomBookings, err := _getRoomBookings(store, fmt.Sprint("roomBookings:", userID), 0, -1)
+		if err != nil {
+			return err
+		}
+		for _, roomBooking := range roomBookings {
+			if err := store.ZAdd(fmt.Sprint("room:", roomBooking.RoomID, ":bookings"), roomBooking.CreatedAt, roomBooking.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Keys of the claims on a Room for each of the nights
+func roomNightKeys(roomID int, nights []string) []string {
+	keys := make([]string, 0, len(nights))
+	for _, night := range nights {
+		keys = append(keys, fmt.Sprint("roomNight:", roomID, ":", night))
+	}
+	return keys
+}
+
+// Translate a conflict on one of the night claims
+func roomBookingError(err error) error {
+	if _, ok := err.(*ConflictError); ok {
+		return ErrRoomIsUnavailable
+	}
+	return err
+}
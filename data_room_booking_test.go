@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestRoomBooking(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	// Insert roomBooking
+	roomBooking := &RoomBooking{
+		RoomID:       1000,
+		UserID:       2000,
+		CheckinDate:  "01-03-2030",
+		CheckoutDate: "04-03-2030",
+	}
+
+	if _, err = roomBooking.insert(store); err != nil {
+		t.Error("RoomBooking.insert:", err)
+	}
+
+	// Checkout must be after checkin
+	invalid := &RoomBooking{RoomID: 1000, UserID: 2001, CheckinDate: "10-03-2030", CheckoutDate: "10-03-2030"}
+	if _, err = invalid.insert(store); err != ErrCheckoutBeforeCheckin {
+		t.Error("RoomBooking.insert: same day checkout:", err)
+	}
+
+	// Overlapping stays in the same Room are refused
+	overlapping := &RoomBooking{RoomID: 1000, UserID: 2001, CheckinDate: "03-03-2030", CheckoutDate: "05-03-2030"}
+	if _, err = overlapping.insert(store); err != ErrRoomIsUnavailable {
+		t.Error("RoomBooking.insert: overlapping stay:", err)
+	}
+
+	// Stays can start on the checkout date of another
+	adjacent := &RoomBooking{RoomID: 1000, UserID: 2001, CheckinDate: "04-03-2030", CheckoutDate: "06-03-2030"}
+	if _, err = adjacent.insert(store); err != nil {
+		t.Error("RoomBooking.insert: adjacent stay:", err)
+	}
+	defer adjacent.delete(store)
+
+	// Update roomBooking to a shorter stay, which frees the last night
+	roomBooking.CheckoutDate = "03-03-2030"
+	if err := roomBooking.update(store); err != nil {
+		t.Error("RoomBooking.update:", err)
+	}
+	overlapping.CheckoutDate = "04-03-2030"
+	if _, err = overlapping.insert(store); err != nil {
+		t.Error("RoomBooking.insert: freed night:", err)
+	}
+	defer overlapping.delete(store)
+
+	// Extending it again conflicts with the new stay
+	roomBooking.CheckoutDate = "04-03-2030"
+	if err := roomBooking.update(store); err != ErrRoomIsUnavailable {
+		t.Error("RoomBooking.update: overlapping stay:", err)
+	}
+
+	// Fetch roomBooking
+	fetched := &RoomBooking{ID: roomBooking.ID}
+	if err := fetched.fetch(store); err != nil {
+		t.Error("RoomBooking.fetch:", err)
+	} else if fetched.CheckoutDate != "03-03-2030" {
+		t.Error("RoomBooking.fetch:", fetched)
+	}
+
+	// Get roomBookings by userID
+	if roomBookings, err := getRoomBookings(store, map[string]interface{}{"userID": 2000, "count": 5}); err != nil || len(roomBookings) != 1 {
+		t.Error("getRoomBookings:", err)
+	}
+
+	// Delete roomBooking
+	if err = fetched.delete(store); err != nil {
+		t.Error("RoomBooking.delete:", err)
+	}
+	if ok, _ := fetched._exists(store); ok {
+		t.Error("RoomBooking._exists")
+	}
+}
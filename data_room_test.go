@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRoom(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	// Insert rooms
+	single := &Room{Name: "101", Type: "test-single", Capacity: 1}
+	double := &Room{Name: "102", Type: "test-single", Capacity: 2}
+	for _, room := range []*Room{single, double} {
+		if _, err = room.insert(store); err != nil {
+			t.Fatal("Room.insert:", err)
+		}
+	}
+	defer single.delete(store)
+
+	// Update room type
+	double.Type = "test-double"
+	if err = double.update(store); err != nil {
+		t.Error("Room.update:", err)
+	}
+	defer double.delete(store)
+
+	// Get rooms by type
+	if rooms, err := getRooms(store, map[string]interface{}{"type": "test-single"}); err != nil || len(rooms) != 1 || rooms[0].ID != single.ID {
+		t.Error("getRooms:", rooms, err)
+	}
+
+	// Book the double room
+	roomBooking := &RoomBooking{RoomID: double.ID, UserID: 2000, CheckinDate: "10-04-2030", CheckoutDate: "12-04-2030"}
+	if _, err = roomBooking.insert(store); err != nil {
+		t.Fatal("RoomBooking.insert:", err)
+	}
+	defer roomBooking.delete(store)
+
+	// Only stays that don't overlap the booking find the double room
+	for _, test := range []struct {
+		checkin, checkout string
+		available         bool
+	}{
+		{"08-04-2030", "10-04-2030", true},
+		{"09-04-2030", "11-04-2030", false},
+		{"11-04-2030", "13-04-2030", false},
+		{"12-04-2030", "14-04-2030", true},
+	} {
+		rooms, err := getAvailableRooms(store, test.checkin, test.checkout, map[string]interface{}{"type": "test-double"})
+		if err != nil || (len(rooms) == 1) != test.available {
+			t.Error("getAvailableRooms:", test.checkin, test.checkout, rooms, err)
+		}
+	}
+
+	// Rooms that are too small aren't found
+	if rooms, err := getAvailableRooms(store, "08-04-2030", "09-04-2030", map[string]interface{}{"type": "test-single", "guests": 2}); err != nil || len(rooms) != 0 {
+		t.Error("getAvailableRooms: guests:", rooms, err)
+	}
+
+	// The search checks the dates
+	if _, err := getAvailableRooms(store, "09-04-2030", "08-04-2030", nil); err != ErrCheckoutBeforeCheckin {
+		t.Error("getAvailableRooms: checkout before checkin:", err)
+	}
+}
+
+func TestRoomDelete(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	room := &Room{Name: "103", Type: "test-deleted", Capacity: 1}
+	if _, err := room.insert(store); err != nil {
+		t.Fatal("Room.insert:", err)
+	}
+
+	roomBooking := &RoomBooking{RoomID: room.ID, UserID: 2100, CheckinDate: "10-04-2030", CheckoutDate: "12-04-2030"}
+	if _, err := roomBooking.insert(store); err != nil {
+		t.Fatal("RoomBooking.insert:", err)
+	}
+	if roomBookings, err := getRoomBookings(store, map[string]interface{}{"roomID": room.ID, "count": 5}); err != nil || len(roomBookings) != 1 {
+		t.Error("getRoomBookings: roomID:", roomBookings, err)
+	}
+
+	// The stay is upcoming until the checkout date
+	for now, upcoming := range map[string]bool{"01-04-2030": true, "11-04-2030": true, "12-04-2030": false} {
+		day, _ := time.Parse(DateFormat, now)
+		if ok, err := room.hasUpcomingBookings(store, day); err != nil || ok != upcoming {
+			t.Error("Room.hasUpcomingBookings:", now, ok, err)
+		}
+	}
+
+	// Deleting the room deletes its bookings and releases their nights
+	if err := room.delete(store); err != nil {
+		t.Fatal("Room.delete:", err)
+	}
+	for _, key := range append(roomNightKeys(room.ID, []string{"10-04-2030", "11-04-2030"}),
+		fmt.Sprint("roomBooking:", roomBooking.ID),
+		fmt.Sprint("roomBookings:", roomBooking.UserID),
+		fmt.Sprint("room:", room.ID, ":bookings"),
+	) {
+		if exists, err := store.Exists(key); err != nil || exists {
+			t.Error("Room.delete: left", key, err)
+		}
+	}
+}
+
+func TestRoomStaff(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	guest := &User{Firstname: "Gwen", Email: "gwen.frontdesk@example.com"}
+	other := &User{Firstname: "Otto", Email: "otto.frontdesk@example.com"}
+	staff := &User{Firstname: "Stef", Email: "stef.frontdesk@example.com", Role: RoleStaff}
+	for _, user := range []*User{guest, other, staff} {
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+	}
+
+	request := func(user *User, method, target string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for _, cookie := range loginCookies(t, s, user) {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	manageRoom := s.requireRole(RoleStaff, s.roomHandler)
+
+	// Staff add rooms, guests don't
+	if response := request(guest, "POST", "/api/room?name=105&type=test-frontdesk&capacity=1", manageRoom); response.Code != http.StatusForbidden {
+		t.Error("roomHandler: guest:", response.Code)
+	}
+	response := request(staff, "POST", "/api/room?name=105&type=test-frontdesk&capacity=1", manageRoom)
+	if response.Code != http.StatusOK {
+		t.Fatal("roomHandler: staff:", response.Code, response.Body)
+	}
+	room := &Room{}
+	if room.ID, _ = strconv.Atoi(response.Body.String()); !room.exists(store, true) {
+		t.Fatal("roomHandler: staff: room wasn't added")
+	}
+	defer room.delete(store)
+
+	// Staff change the stays of guests, other guests don't
+	roomBooking := &RoomBooking{RoomID: room.ID, UserID: guest.ID, CheckinDate: "10-04-2030", CheckoutDate: "12-04-2030"}
+	if _, err := roomBooking.insert(store); err != nil {
+		t.Fatal("RoomBooking.insert:", err)
+	}
+	defer roomBooking.delete(store)
+	target := fmt.Sprint("/api/roombooking?id=", roomBooking.ID)
+	if response := request(other, "DELETE", target, s.roomBookingHandler); response.Code != http.StatusForbidden {
+		t.Error("roomBookingHandler: other guest:", response.Code)
+	}
+	if response := request(staff, "DELETE", target, s.roomBookingHandler); response.Code != http.StatusOK {
+		t.Error("roomBookingHandler: staff:", response.Code, response.Body)
+	}
+	if (&RoomBooking{ID: roomBooking.ID}).exists(store, false) {
+		t.Error("roomBookingHandler: staff: stay wasn't cancelled")
+	}
+}
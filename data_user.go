@@ -4,171 +4,279 @@ import (
 	"fmt"
 	"strconv"
 	"time"
+)
+
+type User struct {
+	ID             int    `redis:"id"`
+	Firstname      string `redis:"firstname"`
+	Lastname       string `redis:"lastname"`
+	Nickname       string `redis:"nickname"`
+	Description    string `redis:"description"`
+	Email          string `redis:"email"`
+	Password       string `redis:"password"`
+	Blocked        bool   `redis:"blocked"`
+	Verified       bool   `redis:"verified"`
+	Birthdate      string `redis:"birthdate"`
+	Gender         string `redis:"gender"`
+	ImageURL       string `redis:"imageURL"`
+	TravellingAs   string `redis:"travellingAs"`
+	WechatNumber   string `redis:"wechatNumber"`
+	LineNumber     string `redis:"lineNumber"`
+	FacebookNumber string `redis:"facebookNumber"`
+	SkypeNumber    string `redis:"skypeNumber"`
+	WhatsappNumber string `redis:"whatsappNumber"`
+	Role           string `redis:"role"`
+	SessionVersion int    `redis:"sessionVersion"`
+	CreatedAt      int64  `redis:"createdAt"`
+	UpdatedAt      int64  `redis:"updatedAt"`
+
+	// Stored as separate sorted sets
+	Interests          []string `redis:"-"`
+	Connections        []User   `redis:"-"`
+	ConnectionRequests []User   `redis:"-"`
+}
+
+// Visibility is how much of a User is shown to whoever views it
+type Visibility int
 
-	"github.com/garyburd/redigo/redis"
+const (
+	// Name, picture and interests, shown to everyone
+	VisibilityPublic Visibility = iota
+	// Email, birthdate and contact numbers, shown to connected users
+	VisibilityConnected
+	// Connections, connection requests and timestamps, shown to the User only
+	VisibilitySelf
 )
 
-type User map[string]interface{}
+// UserView is the JSON representation of a User.
+// Fields left out at the viewer's Visibility are omitted.
+type UserView struct {
+	ID             int        `json:"id"`
+	Firstname      string     `json:"firstname"`
+	Lastname       string     `json:"lastname"`
+	Nickname       string     `json:"nickname"`
+	Description    string     `json:"description"`
+	ImageURL       string     `json:"imageURL"`
+	TravellingAs   string     `json:"travellingAs"`
+	Interests      []string   `json:"interests"`
+	Verified       bool       `json:"verified,omitempty"`
+	Role           string     `json:"role,omitempty"`
+	Email          string     `json:"email,omitempty"`
+	Birthdate      string     `json:"birthdate,omitempty"`
+	Gender         string     `json:"gender,omitempty"`
+	WechatNumber   string     `json:"wechatNumber,omitempty"`
+	LineNumber     string     `json:"lineNumber,omitempty"`
+	FacebookNumber string     `json:"facebookNumber,omitempty"`
+	SkypeNumber    string     `json:"skypeNumber,omitempty"`
+	WhatsappNumber string     `json:"whatsappNumber,omitempty"`
+	CreatedAt      int64      `json:"createdAt,omitempty"`
+	UpdatedAt      int64      `json:"updatedAt,omitempty"`
+	Connections    []UserView `json:"connections,omitempty"`
+
+	// Users who asked to connect, which connecting to them accepts
+	ConnectionRequests []UserView `json:"connectionRequests,omitempty"`
+}
 
-// Check if User exists, with an option to fetch the user
-func (user User) exists(fetch bool) (bool, User) {
-	// Check if the User exists and retrieve it
-	if fetch {
-		if user, err := user.fetch(); err != nil {
-			return false, nil
-		} else {
-			return true, user
+// Get the JSON representation of User at a Visibility.
+// The password is never included.
+func (user *User) view(visibility Visibility) UserView {
+	view := UserView{
+		ID:           user.ID,
+		Firstname:    user.Firstname,
+		Lastname:     user.Lastname,
+		Nickname:     user.Nickname,
+		Description:  user.Description,
+		ImageURL:     user.ImageURL,
+		TravellingAs: user.TravellingAs,
+		Interests:    user.Interests,
+	}
+	if visibility >= VisibilityConnected {
+		view.Email = user.Email
+		view.Birthdate = user.Birthdate
+		view.Gender = user.Gender
+		view.WechatNumber = user.WechatNumber
+		view.LineNumber = user.LineNumber
+		view.FacebookNumber = user.FacebookNumber
+		view.SkypeNumber = user.SkypeNumber
+		view.WhatsappNumber = user.WhatsappNumber
+	}
+	if visibility >= VisibilitySelf {
+		view.Verified = user.Verified
+		view.Role = user.Role
+		view.CreatedAt = user.CreatedAt
+		view.UpdatedAt = user.UpdatedAt
+		for i := range user.Connections {
+			view.Connections = append(view.Connections, user.Connections[i].view(VisibilityConnected))
 		}
-
-		// Just check if the User exists
-	} else {
-		if ok, err := user._exists(); err != nil {
-			return false, nil
-		} else {
-			return ok, nil
+		for i := range user.ConnectionRequests {
+			view.ConnectionRequests = append(view.ConnectionRequests, user.ConnectionRequests[i].view(VisibilityPublic))
 		}
 	}
+	return view
 }
 
-// Check if User exists
-func (user User) _exists() (bool, error) {
-	if reply, err := db.Do("EXISTS", fmt.Sprint("user:", user["id"])); err != nil {
-		return false, err
-	} else if count, err := redis.Int(reply, err); err != nil {
-		return false, err
-	} else {
-		return count > 0, nil
-	}
+// Get how much of User is shown to viewer, which is nil for guests
+func (user *User) visibilityTo(store Store, viewer *User) (Visibility, error) {
+	if viewer == nil {
+		return VisibilityPublic, nil
+	} else if viewer.ID == user.ID {
+		return VisibilitySelf, nil
+	} else if connected, err := viewer.IsConnectedTo(store, user); err != nil {
+		return VisibilityPublic, err
+	} else if connected {
+		return VisibilityConnected, nil
+	}
+	return VisibilityPublic, nil
 }
 
-// Fetch User with specified parameters
-func (user User) fetch() (User, error) {
-	var err error
-
-	ok, key := hasKey(user, "id", "email")
-	if !ok {
-		return nil, ErrMissingKey
+// Get the JSON representation of User as shown to viewer
+func (user *User) viewFor(store Store, viewer *User) (UserView, error) {
+	visibility, err := user.visibilityTo(store, viewer)
+	if err != nil {
+		return UserView{}, err
 	}
+	return user.view(visibility), nil
+}
 
-	switch key {
-	case "id":
-		user, err = _fetchUser("HGETALL", fmt.Sprint("user:", user["id"]))
-	case "email":
-		var reply interface{}
-		var userID int
-
-		if reply, err = db.Do("GET", fmt.Sprint("user:email:", user["email"])); err != nil {
-			return nil, err
-		} else if userID, err = redis.Int(reply, err); err != nil {
+// Get the JSON representation of Users as shown to viewer
+func userViews(store Store, users []User, viewer *User) ([]UserView, error) {
+	views := []UserView{}
+	for i := range users {
+		if view, err := users[i].viewFor(store, viewer); err != nil {
 			return nil, err
 		} else {
-			user, err = _fetchUser("HGETALL", fmt.Sprint("user:", userID))
+			views = append(views, view)
 		}
 	}
-	if err != nil {
-		return nil, err
+	return views, nil
+}
+
+// Check if User exists, with an option to fetch the user
+func (user *User) exists(store Store, fetch bool) bool {
+	// Check if the User exists and retrieve it
+	if fetch {
+		return user.fetch(store) == nil
+
+		// Just check if the User exists
+	} else {
+		ok, err := user._exists(store)
+		return ok && err == nil
 	}
+}
 
-	if interests, err := user.interests(); err != nil {
-		return nil, err
+// Check if User exists
+func (user *User) _exists(store Store) (bool, error) {
+	return store.Exists(fmt.Sprint("user:", user.ID))
+}
+
+// Fetch User by its ID, or by its email if the ID isn't set
+func (user *User) fetch(store Store) error {
+	if err := user.fetchWithoutConnections(store); err != nil {
+		return err
+	}
+
+	if connections, err := user.connections(store); err != nil {
+		return err
 	} else {
-		user["interests"] = interests
+		user.Connections = connections
 	}
 
-	if connections, err := user.Connections(); err != nil {
-		return nil, err
+	if connectionRequests, err := user.connectionRequests(store); err != nil {
+		return err
 	} else {
-		user["connections"] = connections
+		user.ConnectionRequests = connectionRequests
 	}
 
-	return user, nil
+	return nil
 }
 
-// Fetch User with specified parameters without connections
-func fetchUserWithoutConnections(user User) (User, error) {
-	var err error
+// Fetch User by its ID, or by its email if the ID isn't set, without connections
+func (user *User) fetchWithoutConnections(store Store) error {
+	if user.ID == 0 {
+		if user.Email == "" {
+			return ErrMissingKey
+		}
 
-	ok, key := hasKey(user, "id", "email")
-	if !ok {
-		return nil, ErrMissingKey
+		if userID, err := store.Get(fmt.Sprint("user:email:", user.Email)); err != nil {
+			return err
+		} else if user.ID, err = strconv.Atoi(userID); err != nil {
+			return err
+		}
+	}
+
+	fields, err := store.HGetAll(fmt.Sprint("user:", user.ID))
+	if err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
 	}
 
-	switch key {
-	case "id":
-		user, err = _fetchUser("HGETALL", fmt.Sprint("user:", user["id"]))
-	case "email":
-		var reply interface{}
-		var userID int
+	// Users signed up before email verification count as verified
+	if _, ok := fields["verified"]; !ok {
+		fields["verified"] = "1"
+	}
 
-		if reply, err = db.Do("GET", fmt.Sprint("user:email:", user["email"])); err != nil {
-			return nil, err
-		} else if userID, err = redis.Int(reply, err); err != nil {
-			return nil, err
+	// Users from before roles are admins if they had the admin privilege
+	if _, ok := fields["role"]; !ok {
+		if fields["privilege"] == "admin" {
+			fields["role"] = RoleAdmin
 		} else {
-			user, err = _fetchUser("HGETALL", fmt.Sprint("user:", userID))
+			fields["role"] = RoleGuest
 		}
 	}
-	if err != nil {
-		return nil, err
+
+	if err := scanHash(fields, user); err != nil {
+		return err
 	}
 
-	if interests, err := user.interests(); err != nil {
-		return nil, err
+	if interests, err := user.interests(store); err != nil {
+		return err
 	} else {
-		user["interests"] = interests
+		user.Interests = interests
 	}
 
-	return user, nil
+	return nil
 }
 
-// Insert User with specified parameters
-func (user User) insert() (int, error) {
-	if ok, _ := hasKey(user, "email"); !ok {
-		return 0, ErrMissingKey
-	}
+// Fields of Users that update leaves alone: they're written by setRole,
+// setBlocked, setVerified and resetPassword
+var userProtectedFields = []string{"role", "blocked", "verified", "sessionVersion"}
 
-	var userID int
-	if reply, err := db.Do("INCR", "nextUserID"); err != nil {
-		return 0, err
-	} else if userID, err = redis.Int(reply, err); err != nil {
+// Insert User with specified parameters
+func (user *User) insert(store Store) (int, error) {
+	userID, err := store.Incr("nextUserID")
+	if err != nil {
 		return 0, err
 	}
-	user["id"] = userID
-
-	var args []interface{}
-	args = append(args, fmt.Sprint("user:", userID))
+	user.ID = userID
 
 	now := time.Now().Unix()
 
-	// Set User
-	user["createdAt"] = now
-	for k, v := range user {
-		// Ignore 'interests' as it's stored as separate sorted set
-		if k == "interests" {
-			continue
-		}
-		args = append(args, k, v)
+	// Users start out as guests
+	if user.Role == "" {
+		user.Role = RoleGuest
 	}
-	if _, err := db.Do("HMSET", args...); err != nil {
+
+	// Set User
+	user.CreatedAt = now
+	if err := store.HMSet(fmt.Sprint("user:", userID), hashFields(user)); err != nil {
 		return 0, err
 	}
 
 	// Add User to users list
-	if _, err := db.Do("ZADD", "users", now, userID); err != nil {
+	if err := store.ZAdd("users", now, userID); err != nil {
 		return 0, err
 	}
 
 	// Add User email reference
-	if err := user.setEmailReference(""); err != nil {
+	if err := user.setEmailReference(store); err != nil {
 		return 0, err
 	}
 
 	// Update User interests if exist
-	if interests, ok := user["interests"]; ok {
-		if interests, ok := interests.([]string); ok {
-			if err := user.setInterests(interests); err != nil {
-				return 0, err
-			}
+	if user.Interests != nil {
+		if err := user.setInterests(store, user.Interests); err != nil {
+			return 0, err
 		}
 	}
 
@@ -176,42 +284,77 @@ func (user User) insert() (int, error) {
 }
 
 // Delete User with specified parameters
-func (user User) delete() error {
-	userID := user["id"]
+func (user *User) delete(store Store) error {
+	userID := user.ID
 
 	// Remove User email reference
-	if err := user.deleteEmailReference(); err != nil {
+	if err := user.deleteEmailReference(store); err != nil {
 		return err
 	}
 
 	// Delete User
-	if _, err := db.Do("DEL", fmt.Sprint("user:", userID)); err != nil {
+	if err := store.Del(fmt.Sprint("user:", userID)); err != nil {
 		return err
 	}
 
 	// Remove User from users list
-	if _, err := db.Do("ZREM", "users", userID); err != nil {
+	if err := store.ZRem("users", userID); err != nil {
 		return err
 	}
 
-	// Delete longTableBookings from userLongTableBookings
-	if _, err := db.Do("DEL", fmt.Sprint("userLongTableBookings:", userID)); err != nil {
+	// Delete longTableBookings, which frees their seats, and userLongTableBookings
+	longTableBookingIDs, err := ids(store.ZRange(fmt.Sprint("userLongTableBookings:", userID), 0, -1))
+	if err != nil {
+		return err
+	}
+	for _, longTableBookingID := range longTableBookingIDs {
+		if err := (&LongTableBooking{ID: longTableBookingID}).delete(store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+	if err := store.Del(fmt.Sprint("userLongTableBookings:", userID)); err != nil {
 		return err
 	}
 
-	// Delete userConnections
-	if otherUserIDs, err := user.otherUserIDs(); err != nil {
+	// Delete roomBookings, which frees their nights, and the roomBookings list
+	roomBookingIDs, err := ids(store.ZRange(fmt.Sprint("roomBookings:", userID), 0, -1))
+	if err != nil {
 		return err
-	} else {
+	}
+	for _, roomBookingID := range roomBookingIDs {
+		if err := (&RoomBooking{ID: roomBookingID}).delete(store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+	if err := store.Del(fmt.Sprint("roomBookings:", userID)); err != nil {
+		return err
+	}
+
+	// Delete userConnections, including those that aren't mutual
+	for _, key := range []string{fmt.Sprint("userConnections:", userID), fmt.Sprint("userConnectionRequests:", userID)} {
+		otherUserIDs, err := ids(store.ZRange(key, 0, -1))
+		if err != nil {
+			return err
+		}
 		for _, otherUserID := range otherUserIDs {
-			if err = user.removeUser(User{"id": otherUserID}); err != nil {
+			if err = user.removeUser(store, &User{ID: otherUserID}); err != nil {
 				return err
 			}
 		}
 	}
 
 	// Delete interests
-	if err := user.clearInterests(); err != nil {
+	if err := user.clearInterests(store); err != nil {
+		return err
+	}
+
+	// Delete provider identities
+	if err := user.unlinkProviders(store); err != nil {
+		return err
+	}
+
+	// Log out everywhere
+	if err := user.deleteSessions(store); err != nil {
 		return err
 	}
 
@@ -219,53 +362,37 @@ func (user User) delete() error {
 }
 
 // Update User with specified parameters
-func (user User) update() (err error) {
-	var args []interface{}
-
-	if userID, ok := user["id"]; !ok {
+func (user *User) update(store Store) (err error) {
+	if user.ID == 0 {
 		return ErrMissingKey
-	} else {
-		if userID, ok := userID.(int); !ok {
-			return ErrTypeAssertionFailed
-		} else {
-			args = append(args, fmt.Sprint("user:", userID))
-		}
 	}
 
-	user["updatedAt"] = time.Now().Unix()
+	user.UpdatedAt = time.Now().Unix()
 
 	// Delete email reference
-	if _, ok := user["email"]; ok {
-		if err := user.deleteEmailReference(); err != nil {
-			return err
-		}
+	if err := user.deleteEmailReference(store); err != nil {
+		return err
 	}
 
-	// Update User
-	for k, v := range user {
-		// Ignore 'interests' as it's stored as separate sorted set
-		if k == "interests" {
-			continue
-		}
-		args = append(args, k, v)
+	// Update User, except for the fields that only change on their own, so that
+	// updating a User fetched before they changed doesn't undo them
+	fields := hashFields(user)
+	for _, name := range userProtectedFields {
+		delete(fields, name)
 	}
-	if _, err := db.Do("HMSET", args...); err != nil {
+	if err := store.HMSet(fmt.Sprint("user:", user.ID), fields); err != nil {
 		return err
 	}
 
 	// Set email reference
-	if email, ok := user["email"]; ok {
-		if err := user.setEmailReference(email.(string)); err != nil {
-			return err
-		}
+	if err := user.setEmailReference(store); err != nil {
+		return err
 	}
 
 	// Update User interests if exist
-	if interests, ok := user["interests"]; ok {
-		if interests, ok := interests.([]string); ok {
-			if err := user.setInterests(interests); err != nil {
-				return err
-			}
+	if user.Interests != nil {
+		if err := user.setInterests(store, user.Interests); err != nil {
+			return err
 		}
 	}
 
@@ -273,14 +400,14 @@ func (user User) update() (err error) {
 }
 
 // Get Users matching specified parameters
-func fetchUsers(params map[string]interface{}) ([]User, error) {
+func fetchUsers(store Store, params map[string]interface{}) ([]User, error) {
 	count := params["count"].(int)
 
 	if interests, ok := params["interests"].([]string); ok {
 		var allUsers []User
 
 		for _, interest := range interests {
-			if users, err := _fetchUsers("ZRANGE", fmt.Sprint("interest:", interest), 0, count-1); err != nil {
+			if users, err := _fetchUsers(store, fmt.Sprint("interest:", interest), 0, count-1); err != nil {
 				return nil, err
 			} else {
 				for _, user := range users {
@@ -294,45 +421,19 @@ func fetchUsers(params map[string]interface{}) ([]User, error) {
 		return allUsers, nil
 	}
 
-	return _fetchUsers("ZRANGE", "users", 0, count-1)
-}
-
-// Get User with specified raw Redis command
-func _fetchUser(command string, args ...interface{}) (User, error) {
-	user := User{}
-
-	if reply, err := db.Do(command, args...); err != nil {
-		return nil, err
-	} else if retrievedUser, err := redis.StringMap(reply, err); err != nil {
-		return nil, err
-	} else {
-		for k, v := range retrievedUser {
-			switch k {
-			case "id":
-				if userID, err := strconv.Atoi(v); err != nil {
-					return user, err
-				} else {
-					user[k] = userID
-				}
-			default:
-				user[k] = v
-			}
-		}
-	}
-
-	return user, nil
+	return _fetchUsers(store, "users", 0, count-1)
 }
 
-// Get Users with specified raw Redis command
-func _fetchUsers(command string, args ...interface{}) ([]User, error) {
+// Get Users in the specified range of a sorted set of User IDs
+func _fetchUsers(store Store, key string, start, stop int) ([]User, error) {
 	var users []User
 
-	if userIDs, err := _fetchUserIDs(command, args...); err != nil {
+	if userIDs, err := ids(store.ZRange(key, start, stop)); err != nil {
 		return nil, err
 	} else {
 		for _, userID := range userIDs {
-			user := User{"id": userID}
-			if user, err = user.fetch(); err != nil {
+			user := User{ID: userID}
+			if err = user.fetch(store); err != nil {
 				return nil, err
 			} else {
 				users = append(users, user)
@@ -343,103 +444,81 @@ func _fetchUsers(command string, args ...interface{}) ([]User, error) {
 	return users, nil
 }
 
-// Get User IDs with specified raw Redis command
-func _fetchUserIDs(command string, args ...interface{}) ([]int, error) {
-	if reply, err := db.Do(command, args...); err != nil {
-		return nil, err
-	} else if userIDs, err := redis.Ints(reply, err); err != nil {
-		return nil, err
-	} else {
-		return userIDs, nil
-	}
-}
-
 // Check if []Users contains User
-func (user User) in(users []User) bool {
+func (user *User) in(users []User) bool {
 	for _, u := range users {
-		if u["id"] == user["id"] {
+		if u.ID == user.ID {
 			return true
 		}
 	}
 	return false
 }
 
-func (user User) set(key, value string) error {
-	if value != "" {
-		switch key {
-		case "birthdate":
-			if _, err := parseDate(value); err != nil {
-				return ErrWrongDateFormat
-			} else {
-				user["birthdate"] = value
-			}
-		default:
-			user[key] = value
-		}
-	}
-
-	return nil
-}
-
-// Add otherUser as current User's connection
-func (user User) addUser(otherUser User) error {
-	now := time.Now().Unix()
-	if _, err := db.Do("ZADD", fmt.Sprint("userConnections:", user["id"]), now, otherUser["id"]); err != nil {
+// Connect current User to otherUser. Users are only connected once both have
+// connected to each other, so until otherUser connects back, it's a request
+// that otherUser sees.
+func (user *User) addUser(store Store, otherUser *User) error {
+	if err := store.ZAdd(fmt.Sprint("userConnections:", user.ID), time.Now().Unix(), otherUser.ID); err != nil {
 		return err
 	}
-	if _, err := db.Do("ZADD", fmt.Sprint("userConnections:", otherUser["id"]), now, user["id"]); err != nil {
+
+	// Connecting back accepts the request of otherUser
+	if requested, err := otherUser.hasConnectedTo(store, user); err != nil {
 		return err
+	} else if requested {
+		return store.ZRem(fmt.Sprint("userConnectionRequests:", user.ID), otherUser.ID)
 	}
-	return nil
+	return store.ZAdd(fmt.Sprint("userConnectionRequests:", otherUser.ID), time.Now().Unix(), user.ID)
 }
 
-// Remove otherUser from current User's connection
-func (user User) removeUser(otherUser User) error {
-	if _, err := db.Do("ZREM", fmt.Sprint("userConnections:", user["id"]), otherUser["id"]); err != nil {
-		return err
-	}
-	if _, err := db.Do("ZREM", fmt.Sprint("userConnections:", otherUser["id"]), user["id"]); err != nil {
-		return err
+// Remove otherUser from current User's connections, or the request of either
+// to connect to the other
+func (user *User) removeUser(store Store, otherUser *User) error {
+	for _, pair := range [][2]*User{{user, otherUser}, {otherUser, user}} {
+		if err := store.ZRem(fmt.Sprint("userConnections:", pair[0].ID), pair[1].ID); err != nil {
+			return err
+		}
+		if err := store.ZRem(fmt.Sprint("userConnectionRequests:", pair[0].ID), pair[1].ID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Get current User's connected users' IDs
-func (user User) otherUserIDs() ([]int, error) {
-	if reply, err := db.Do("ZRANGE", fmt.Sprint("userConnections:", user["id"]), 0, -1); err != nil {
-		return nil, err
-	} else if userIDs, err := redis.Ints(reply, err); err != nil {
+// Get the IDs of the Users that current User is connected to, both ways
+func (user *User) otherUserIDs(store Store) ([]int, error) {
+	userIDs, err := ids(store.ZRange(fmt.Sprint("userConnections:", user.ID), 0, -1))
+	if err != nil {
 		return nil, err
-	} else {
-		return userIDs, nil
 	}
-}
 
-// Get current User's interests
-func (user User) interests() ([]string, error) {
-	if reply, err := db.Do("ZRANGE", fmt.Sprint("user:", user["id"], ":interests"), 0, -1); err != nil {
-		if err == redis.ErrNil {
-			return nil, nil
+	var connectedIDs []int
+	for _, userID := range userIDs {
+		if connected, err := (&User{ID: userID}).hasConnectedTo(store, user); err != nil {
+			return nil, err
+		} else if connected {
+			connectedIDs = append(connectedIDs, userID)
 		}
-		return nil, err
-	} else if interests, err := redis.Strings(reply, err); err != nil {
-		return nil, err
-	} else {
-		return interests, nil
 	}
+	return connectedIDs, nil
+}
+
+// Get current User's interests
+func (user *User) interests(store Store) ([]string, error) {
+	return store.ZRange(fmt.Sprint("user:", user.ID, ":interests"), 0, -1)
 }
 
 // Set current User's interests
-func (user User) setInterests(interests []string) error {
-	if err := user.clearInterests(); err != nil {
+func (user *User) setInterests(store Store, interests []string) error {
+	if err := user.clearInterests(store); err != nil {
 		return err
 	}
 
 	for _, interest := range interests {
-		if _, err := db.Do("ZADD", fmt.Sprint("interest:", interest), time.Now().Unix(), user["id"]); err != nil {
+		if err := store.ZAdd(fmt.Sprint("interest:", interest), time.Now().Unix(), user.ID); err != nil {
 			return err
 		}
-		if _, err := db.Do("ZADD", fmt.Sprint("user:", user["id"], ":interests"), time.Now().Unix(), interest); err != nil {
+		if err := store.ZAdd(fmt.Sprint("user:", user.ID, ":interests"), time.Now().Unix(), interest); err != nil {
 			return err
 		}
 	}
@@ -448,131 +527,119 @@ func (user User) setInterests(interests []string) error {
 }
 
 // Clear current User's interests
-func (user User) clearInterests() error {
-	if interests, err := user.interests(); err == nil {
+func (user *User) clearInterests(store Store) error {
+	if interests, err := user.interests(store); err != nil {
+		return err
+	} else {
 		// Delete interests
-		if _, err := db.Do("DEL", fmt.Sprint("user:", user["id"], ":interests")); err != nil {
+		if err := store.Del(fmt.Sprint("user:", user.ID, ":interests")); err != nil {
 			return err
 		}
 
 		// Remove User from interests
 		for _, interest := range interests {
-			if _, err := db.Do("ZREM", fmt.Sprint("interest:", interest), user["id"]); err != nil {
+			if err := store.ZRem(fmt.Sprint("interest:", interest), user.ID); err != nil {
 				return err
 			}
 		}
-	} else if err != redis.ErrNil {
-		return err
 	}
 
 	return nil
 }
 
-func (user User) emailAddress() (string, error) {
-	if reply, err := db.Do("HGET", fmt.Sprint("user:", user["id"]), "email"); err != nil {
-		return "", err
-	} else if email, err := redis.String(reply, err); err != nil {
-		return "", err
-	} else {
-		return email, nil
-	}
+func (user *User) emailAddress(store Store) (string, error) {
+	return store.HGet(fmt.Sprint("user:", user.ID), "email")
 }
 
-func (user User) setEmailReference(email string) error {
-	if email == "" {
-		email = user["email"].(string)
+// Users from social logins may have no email, which isn't referenced
+func (user *User) setEmailReference(store Store) error {
+	if user.Email == "" {
+		return nil
 	}
-	if _, err := db.Do("SET", fmt.Sprint("user:email:", email), user["id"]); err != nil {
-		return err
-	}
-	return nil
+	return store.Set(fmt.Sprint("user:email:", user.Email), user.ID)
 }
 
-func (user User) deleteEmailReference() error {
-	if email, err := user.emailAddress(); err != nil {
-		return err
-	} else if _, err := db.Do("DEL", fmt.Sprint("user:email:", email)); err != nil {
+func (user *User) deleteEmailReference(store Store) error {
+	if email, err := user.emailAddress(store); err != nil {
 		return err
+	} else if email == "" {
+		return nil
+	} else {
+		return store.Del(fmt.Sprint("user:email:", email))
 	}
-	return nil
 }
 
-func (user User) longTableBookings() ([]LongTableBooking, error) {
-	return getLongTableBookings(map[string]interface{}{"userID": user["id"]})
+func (user *User) longTableBookings(store Store) ([]LongTableBooking, error) {
+	return getLongTableBookings(store, map[string]interface{}{"userID": user.ID})
 }
 
-// Check if User has already booked the LongTable at particular date
-func (user User) bookedLongTable(longTable LongTable, date string) (bool, error) {
-	if reply, err := db.Do("ZRANGE", fmt.Sprint("userLongTableBookings:", user["id"], ":", date), 0, -1); err != nil {
-		return false, err
-	} else if longTableBookingIDs, err := redis.Ints(reply, err); err != nil {
-		return false, err
-	} else {
-		for _, longTableBookingID := range longTableBookingIDs {
-			if _, err := db.Do("ZSCORE", fmt.Sprint("userLongTableBookings:", user["id"]), longTableBookingID); err != nil {
-				if err != redis.ErrNil {
-					return false, err
-				}
-			} else {
-				return true, nil
-			}
-		}
-	}
+func (user *User) roomBookings(store Store) ([]RoomBooking, error) {
+	return getRoomBookings(store, map[string]interface{}{"userID": user.ID})
+}
 
-	return false, nil
+// Check if User has already booked a LongTable at particular date and sitting
+func (user *User) bookedLongTable(store Store, date, sitting string) (bool, error) {
+	return store.Exists(fmt.Sprint("userLongTableBookings:", user.ID, ":", sittingKey(date, sitting)))
 }
 
-func (user User) Connections() ([]User, error) {
-	var users []User
+// Get current User's connected users
+func (user *User) connections(store Store) ([]User, error) {
+	userIDs, err := user.otherUserIDs(store)
+	if err != nil {
+		return nil, err
+	}
+	if len(userIDs) > 100 {
+		userIDs = userIDs[:100]
+	}
+	return fetchUsersWithoutConnections(store, userIDs)
+}
 
-	if userIDs, err := _fetchUserIDs("ZRANGE", fmt.Sprint("userConnections:", user["id"]), 0, 100); err != nil {
+// Get the users who asked to connect to current User
+func (user *User) connectionRequests(store Store) ([]User, error) {
+	userIDs, err := ids(store.ZRange(fmt.Sprint("userConnectionRequests:", user.ID), 0, 100))
+	if err != nil {
 		return nil, err
-	} else {
-		for _, userID := range userIDs {
-			user := User{"id": userID}
+	}
+	return fetchUsersWithoutConnections(store, userIDs)
+}
 
-			if user, err = fetchUserWithoutConnections(user); err != nil {
-				return nil, err
-			} else {
-				users = append(users, user)
-			}
+// Fetch the Users with userIDs, without their connections
+func fetchUsersWithoutConnections(store Store, userIDs []int) ([]User, error) {
+	var users []User
+	for _, userID := range userIDs {
+		user := User{ID: userID}
+		if err := user.fetchWithoutConnections(store); err != nil {
+			return nil, err
 		}
+		users = append(users, user)
 	}
-
 	return users, nil
 }
 
-func (user User) InterestedIn(interest string) bool {
-	if interests, ok := user["interests"]; ok {
-		if interests, ok := interests.([]string); ok {
-			for _, v := range interests {
-				if v == interest {
-					return true
-				}
-			}
+func (user *User) InterestedIn(interest string) bool {
+	for _, v := range user.Interests {
+		if v == interest {
+			return true
 		}
 	}
 	return false
 }
 
-func (user User) LongTableBookings() ([]LongTableBooking, error) {
-	return user.longTableBookings()
-}
-
-func (user User) SimilarUsers() ([]User, error) {
-	if interests, err := user.interests(); err != nil {
+// Get Users that share interests with current User
+func (user *User) similarUsers(store Store) ([]User, error) {
+	if interests, err := user.interests(store); err != nil {
 		return nil, err
 	} else {
 		var allUsers []User
 
-		if users, err := fetchUsers(map[string]interface{}{
+		if users, err := fetchUsers(store, map[string]interface{}{
 			"count":     0,
 			"interests": interests,
 		}); err != nil {
 			return nil, err
 		} else {
 			for k := range users {
-				if users[k]["id"] != user["id"] {
+				if users[k].ID != user.ID {
 					allUsers = append(allUsers, users[k])
 				}
 			}
@@ -581,14 +648,20 @@ func (user User) SimilarUsers() ([]User, error) {
 	}
 }
 
-func (user User) IsConnectedTo(otherUser User) (bool, error) {
-	reply, err := db.Do("ZSCORE", fmt.Sprint("userConnections:", user["id"]), otherUser["id"])
-	if _, err = redis.Int(reply, err); err != nil {
-		if err != redis.ErrNil {
-			return false, err
-		}
+// Check if current User and otherUser connected to each other
+func (user *User) IsConnectedTo(store Store, otherUser *User) (bool, error) {
+	if connected, err := user.hasConnectedTo(store, otherUser); err != nil || !connected {
+		return false, err
+	}
+	return otherUser.hasConnectedTo(store, user)
+}
+
+// Check if current User connected to otherUser, whether or not otherUser connected back
+func (user *User) hasConnectedTo(store Store, otherUser *User) (bool, error) {
+	if _, err := store.ZScore(fmt.Sprint("userConnections:", user.ID), otherUser.ID); err == ErrNil {
 		return false, nil
-	} else {
-		return true, nil
+	} else if err != nil {
+		return false, err
 	}
+	return true, nil
 }
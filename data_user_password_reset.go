@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Create a password reset token for User, which expires after passwordResetTokenTTL.
+// The token remembers the email it's sent to, which is User's current email.
+func (user *User) createPasswordResetToken(store Store) (string, error) {
+	if user.ID == 0 || user.Email == "" {
+		return "", ErrMissingKey
+	}
+
+	token, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.SetEx(fmt.Sprint("passwordResetToken:", token), fmt.Sprint(user.ID, ":", user.Email), passwordResetTokenTTL); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Set a new password for the User of a password reset token.
+// Tokens can only be used once, and the User's existing sessions are invalidated.
+func resetPassword(store Store, token, password string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidPasswordResetToken
+	}
+
+	value, err := store.GetDel(fmt.Sprint("passwordResetToken:", token))
+	if err == ErrNil {
+		return nil, ErrInvalidPasswordResetToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Tokens are "[userID]:[email]", or just the user ID on older tokens
+	parts := strings.SplitN(value, ":", 2)
+	user := &User{}
+	if user.ID, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, err
+	}
+	if err := user.fetch(store); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = string(hashedPassword)
+
+	// The token was sent to the User's email, so the email is verified too,
+	// unless the User has changed it since
+	if len(parts) == 2 && parts[1] == user.Email {
+		user.Verified = true
+	}
+
+	// Log out everywhere
+	user.SessionVersion++
+
+	user.UpdatedAt = time.Now().Unix()
+	if err := store.HMSet(fmt.Sprint("user:", user.ID), map[string]interface{}{
+		"password":       user.Password,
+		"verified":       user.Verified,
+		"sessionVersion": user.SessionVersion,
+		"updatedAt":      user.UpdatedAt,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := user.deleteSessions(store); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Get the version of the User's sessions; sessions of older versions are invalid
+func (user *User) sessionVersion(store Store) (int, error) {
+	if version, err := store.HGet(fmt.Sprint("user:", user.ID), "sessionVersion"); err == ErrNil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	} else {
+		return strconv.Atoi(version)
+	}
+}
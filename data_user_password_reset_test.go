@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Rose", Email: "rose.reset@example.com", Password: "abcd1234"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	token, err := user.createPasswordResetToken(store)
+	if err != nil {
+		t.Fatal("user.createPasswordResetToken:", err)
+	}
+
+	// Unknown tokens don't reset anything
+	if _, err := resetPassword(store, "0123", "new-password"); err != ErrInvalidPasswordResetToken {
+		t.Error("resetPassword: unknown token:", err)
+	}
+
+	// The token sets the new password and invalidates the sessions
+	if _, err := resetPassword(store, token, "new-password"); err != nil {
+		t.Error("resetPassword:", err)
+	}
+	fetched := &User{ID: user.ID}
+	if err := fetched.fetch(store); err != nil {
+		t.Error("user.fetch:", err)
+	} else if err := bcrypt.CompareHashAndPassword([]byte(fetched.Password), []byte("new-password")); err != nil {
+		t.Error("resetPassword: password:", err)
+	}
+	if version, err := user.sessionVersion(store); err != nil || version != user.SessionVersion+1 {
+		t.Error("user.sessionVersion:", version, err)
+	}
+
+	// Tokens can only be used once
+	if _, err := resetPassword(store, token, "other-password"); err != ErrInvalidPasswordResetToken {
+		t.Error("resetPassword: used token:", err)
+	}
+
+	// The token verified the email it was sent to
+	if !fetched.Verified {
+		t.Error("resetPassword: not verified")
+	}
+
+	// Tokens sent to an email that has since changed don't verify the new one
+	if token, err = fetched.createPasswordResetToken(store); err != nil {
+		t.Fatal("user.createPasswordResetToken:", err)
+	}
+	fetched.Email = "rose.changed@example.com"
+	if err := fetched.update(store); err != nil {
+		t.Fatal("user.update:", err)
+	}
+	if err := fetched.setVerified(store, false); err != nil {
+		t.Fatal("user.setVerified:", err)
+	}
+	if reset, err := resetPassword(store, token, "changed-password"); err != nil || reset.Verified {
+		t.Error("resetPassword: changed email:", err)
+	}
+	if err := fetched.fetch(store); err != nil || fetched.Verified {
+		t.Error("resetPassword: changed email verified:", err)
+	}
+}
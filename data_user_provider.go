@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Link a social login identity to User.
+// It fails with ErrProviderAlreadyLinked if the identity belongs to another User.
+func (user *User) linkProvider(store Store, provider, providerUserID string) error {
+	if user.ID == 0 || provider == "" || providerUserID == "" {
+		return ErrMissingKey
+	}
+
+	// Claim the identity, which keeps the User record as it is
+	if _, err := store.Reserve(&Reservation{
+		ID:     user.ID,
+		Prefix: "user:",
+		Claims: []string{providerKey(provider, providerUserID)},
+		Score:  time.Now().Unix(),
+	}); err != nil {
+		if _, ok := err.(*ConflictError); ok {
+			return ErrProviderAlreadyLinked
+		}
+		return err
+	}
+
+	// Add the identity to the User's providers
+	return store.HMSet(fmt.Sprint("user:", user.ID, ":providers"), map[string]interface{}{provider: providerUserID})
+}
+
+// Remove all social login identities of User
+func (user *User) unlinkProviders(store Store) error {
+	providers, err := user.providers(store)
+	if err != nil {
+		return err
+	}
+
+	for provider, providerUserID := range providers {
+		if err := store.Del(providerKey(provider, providerUserID)); err != nil {
+			return err
+		}
+	}
+
+	return store.Del(fmt.Sprint("user:", user.ID, ":providers"))
+}
+
+// Get the social login identities of User, by provider
+func (user *User) providers(store Store) (map[string]string, error) {
+	return store.HGetAll(fmt.Sprint("user:", user.ID, ":providers"))
+}
+
+// Fetch the User that a social login identity is linked to
+func fetchUserByProvider(store Store, provider, providerUserID string) (*User, error) {
+	userID, err := store.Get(providerKey(provider, providerUserID))
+	if err == ErrNil {
+		return nil, ErrEntityNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	user := &User{}
+	if user.ID, err = strconv.Atoi(userID); err != nil {
+		return nil, err
+	}
+	if err := user.fetch(store); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Key of the reference from a social login identity to its User
+func providerKey(provider, providerUserID string) string {
+	return fmt.Sprint("user:provider:", provider, ":", providerUserID)
+}
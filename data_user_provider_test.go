@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestUserProvider(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Paula", Email: "paula.provider@example.com"}
+	otherUser := &User{Firstname: "Otto", Email: "otto.provider@example.com"}
+	for _, u := range []*User{user, otherUser} {
+		if _, err := u.insert(store); err != nil {
+			t.Fatal("user.insert:", err)
+		}
+	}
+	defer otherUser.delete(store)
+
+	// Link a provider identity
+	if err := user.linkProvider(store, "test", "paula-1"); err != nil {
+		t.Error("user.linkProvider:", err)
+	}
+	if fetched, err := fetchUserByProvider(store, "test", "paula-1"); err != nil || fetched.ID != user.ID || fetched.Firstname != "Paula" {
+		t.Error("fetchUserByProvider:", fetched, err)
+	}
+
+	// Linking again is fine, but the identity can't be linked to another User
+	if err := user.linkProvider(store, "test", "paula-1"); err != nil {
+		t.Error("user.linkProvider: again:", err)
+	}
+	if err := otherUser.linkProvider(store, "test", "paula-1"); err != ErrProviderAlreadyLinked {
+		t.Error("user.linkProvider: other user:", err)
+	}
+
+	// Deleting the User unlinks its identities
+	if err := user.delete(store); err != nil {
+		t.Error("user.delete:", err)
+	}
+	if _, err := fetchUserByProvider(store, "test", "paula-1"); err != ErrEntityNotFound {
+		t.Error("fetchUserByProvider: deleted user:", err)
+	}
+}
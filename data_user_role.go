@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Roles of Users
+const (
+	// Everyone who signs up
+	RoleGuest = "guest"
+	// Works the front desk: manages rooms and the stays of guests
+	RoleStaff = "staff"
+	// Also manages long tables, posts, media and the roles of other Users
+	RoleAdmin = "admin"
+)
+
+// Roles from the least to the most privileged; each Role can do everything
+// the Roles before it can
+var roles = []string{RoleGuest, RoleStaff, RoleAdmin}
+
+// Check if User has role, or a more privileged one
+func (user *User) HasRole(role string) bool {
+	required := roleRank(role)
+	return required >= 0 && roleRank(user.Role) >= required
+}
+
+// Set the Role of User
+func (user *User) setRole(store Store, role string) error {
+	if user.ID == 0 {
+		return ErrMissingKey
+	}
+	if !contains(roles, role) {
+		return ErrInvalidRole
+	}
+
+	user.Role = role
+	user.UpdatedAt = time.Now().Unix()
+
+	return store.HMSet(fmt.Sprint("user:", user.ID), map[string]interface{}{
+		"role":      user.Role,
+		"updatedAt": user.UpdatedAt,
+	})
+}
+
+// Get the position of role in roles, or -1 if it isn't a Role
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
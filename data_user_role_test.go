@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUserRole(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	// Users start out as guests
+	user := &User{Firstname: "Rolf", Email: "rolf.role@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+	if !user.HasRole(RoleGuest) || user.HasRole(RoleStaff) {
+		t.Error("user.HasRole: guest:", user.Role)
+	}
+
+	// More privileged roles include the less privileged ones
+	if err := user.setRole(store, RoleStaff); err != nil {
+		t.Error("user.setRole:", err)
+	}
+	fetched := &User{ID: user.ID}
+	if err := fetched.fetch(store); err != nil {
+		t.Fatal("user.fetch:", err)
+	}
+	if fetched.Role != RoleStaff || !fetched.HasRole(RoleGuest) || !fetched.HasRole(RoleStaff) || fetched.HasRole(RoleAdmin) {
+		t.Error("user.HasRole: staff:", fetched.Role)
+	}
+
+	// Unknown roles can't be set or had
+	if err := user.setRole(store, "owner"); err != ErrInvalidRole {
+		t.Error("user.setRole: unknown role:", err)
+	}
+	if user.HasRole("owner") {
+		t.Error("user.HasRole: unknown role")
+	}
+
+	// Users from before roles keep their admin privilege
+	legacy := &User{Firstname: "Lea", Email: "lea.role@example.com"}
+	if _, err := legacy.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer legacy.delete(store)
+	key := fmt.Sprint("user:", legacy.ID)
+	if err := store.Del(key); err != nil {
+		t.Fatal("store.Del:", err)
+	}
+	if err := store.HMSet(key, map[string]interface{}{"id": legacy.ID, "firstname": "Lea", "privilege": "admin"}); err != nil {
+		t.Fatal("store.HMSet:", err)
+	}
+	fetched = &User{ID: legacy.ID}
+	if err := fetched.fetch(store); err != nil || fetched.Role != RoleAdmin {
+		t.Error("user.fetch: legacy admin:", fetched.Role, err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// UserSession is the record of a logged in session of a User, e.g. on one device
+type UserSession struct {
+	ID         string `redis:"id"`
+	UserID     int    `redis:"userID"`
+	UserAgent  string `redis:"userAgent"`
+	IP         string `redis:"ip"`
+	CreatedAt  int64  `redis:"createdAt"`
+	LastSeenAt int64  `redis:"lastSeenAt"`
+}
+
+// UserSessionView is the JSON representation of a UserSession
+type UserSessionView struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt"`
+	Current    bool   `json:"current"`
+}
+
+// Get the JSON representation of UserSession, marking whether it's the session
+// with currentID
+func (userSession *UserSession) view(currentID string) UserSessionView {
+	return UserSessionView{
+		ID:         userSession.ID,
+		UserAgent:  userSession.UserAgent,
+		IP:         userSession.IP,
+		CreatedAt:  userSession.CreatedAt,
+		LastSeenAt: userSession.LastSeenAt,
+		Current:    userSession.ID == currentID,
+	}
+}
+
+// Get the JSON representation of UserSessions
+func userSessionViews(userSessions []UserSession, currentID string) []UserSessionView {
+	views := []UserSessionView{}
+	for i := range userSessions {
+		views = append(views, userSessions[i].view(currentID))
+	}
+	return views
+}
+
+// Fetch UserSession by its ID
+func (userSession *UserSession) fetch(store Store) error {
+	if userSession.ID == "" {
+		return ErrMissingKey
+	}
+
+	if fields, err := store.HGetAll(fmt.Sprint("session:", userSession.ID)); err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return ErrEntityNotFound
+	} else {
+		return scanHash(fields, userSession)
+	}
+}
+
+// Insert UserSession with a new random ID.
+// It expires once it hasn't been seen for userSessionIdleTimeout.
+func (userSession *UserSession) insert(store Store) (string, error) {
+	if userSession.UserID == 0 {
+		return "", ErrMissingKey
+	}
+
+	id, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+	userSession.ID = id
+
+	now := time.Now().Unix()
+	userSession.CreatedAt = now
+	userSession.LastSeenAt = now
+
+	// Set session
+	key := fmt.Sprint("session:", id)
+	if err := store.HMSet(key, hashFields(userSession)); err != nil {
+		return "", err
+	}
+	if err := store.Expire(key, userSessionIdleTimeout); err != nil {
+		return "", err
+	}
+
+	// Add session to userSessions:[userID] list
+	if err := store.ZAdd(fmt.Sprint("userSessions:", userSession.UserID), now, id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Delete UserSession, which logs out whoever uses it
+func (userSession *UserSession) delete(store Store) error {
+	// Fetch the rest of the UserSession so that all its references can be removed
+	if err := userSession.fetch(store); err != nil {
+		return err
+	}
+
+	// Delete session
+	if err := store.Del(fmt.Sprint("session:", userSession.ID)); err != nil {
+		return err
+	}
+
+	// Remove session from userSessions:[userID] list
+	if err := store.ZRem(fmt.Sprint("userSessions:", userSession.UserID), userSession.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Record that UserSession was just used, which also keeps it from expiring.
+// To save writes, this is done at most once per userSessionSeenInterval.
+func (userSession *UserSession) touch(store Store) error {
+	now := time.Now()
+	if now.Sub(time.Unix(userSession.LastSeenAt, 0)) < userSessionSeenInterval {
+		return nil
+	}
+
+	userSession.LastSeenAt = now.Unix()
+
+	key := fmt.Sprint("session:", userSession.ID)
+	if err := store.HMSet(key, map[string]interface{}{"lastSeenAt": userSession.LastSeenAt}); err != nil {
+		return err
+	}
+	return store.Expire(key, userSessionIdleTimeout)
+}
+
+// Get the active UserSessions of User, oldest first
+func (user *User) sessions(store Store) ([]UserSession, error) {
+	var userSessions []UserSession
+
+	key := fmt.Sprint("userSessions:", user.ID)
+	sessionIDs, err := store.ZRange(key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sessionID := range sessionIDs {
+		userSession := UserSession{ID: sessionID}
+		if err := userSession.fetch(store); err == ErrEntityNotFound {
+			// The session expired, so drop it from the list too
+			if err := store.ZRem(key, sessionID); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		userSessions = append(userSessions, userSession)
+	}
+
+	return userSessions, nil
+}
+
+// Delete all UserSessions of User, which logs it out everywhere
+func (user *User) deleteSessions(store Store) error {
+	key := fmt.Sprint("userSessions:", user.ID)
+	sessionIDs, err := store.ZRange(key, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := store.Del(fmt.Sprint("session:", sessionID)); err != nil {
+			return err
+		}
+	}
+
+	return store.Del(key)
+}
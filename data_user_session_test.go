@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUserSession(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Sesto", Email: "sesto.session@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	// Record a session per device
+	phone := &UserSession{UserID: user.ID, UserAgent: "Phone", IP: "192.0.2.1"}
+	laptop := &UserSession{UserID: user.ID, UserAgent: "Laptop", IP: "192.0.2.2"}
+	for _, userSession := range []*UserSession{phone, laptop} {
+		if _, err := userSession.insert(store); err != nil {
+			t.Fatal("userSession.insert:", err)
+		}
+	}
+	if phone.ID == laptop.ID {
+		t.Error("userSession.insert: same ID:", phone.ID)
+	}
+
+	fetched := &UserSession{ID: phone.ID}
+	if err := fetched.fetch(store); err != nil || fetched.UserID != user.ID || fetched.UserAgent != "Phone" || fetched.IP != "192.0.2.1" {
+		t.Error("userSession.fetch:", fetched, err)
+	}
+
+	// The last seen time is only updated once in a while
+	fetched.LastSeenAt -= int64(userSessionSeenInterval/time.Second) + 1
+	lastSeenAt := fetched.LastSeenAt
+	if err := fetched.touch(store); err != nil || fetched.LastSeenAt <= lastSeenAt {
+		t.Error("userSession.touch:", fetched.LastSeenAt, err)
+	}
+	lastSeenAt = fetched.LastSeenAt
+	if err := fetched.touch(store); err != nil || fetched.LastSeenAt != lastSeenAt {
+		t.Error("userSession.touch: again:", fetched.LastSeenAt, err)
+	}
+
+	// List the sessions, leaving out the ones that expired
+	if userSessions, err := user.sessions(store); err != nil || len(userSessions) != 2 {
+		t.Error("user.sessions:", userSessions, err)
+	}
+	if err := store.Expire(fmt.Sprint("session:", laptop.ID), time.Millisecond); err != nil {
+		t.Fatal("store.Expire:", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if userSessions, err := user.sessions(store); err != nil || len(userSessions) != 1 || userSessions[0].ID != phone.ID {
+		t.Error("user.sessions: expired:", userSessions, err)
+	}
+
+	// Revoke a session
+	if err := phone.delete(store); err != nil {
+		t.Error("userSession.delete:", err)
+	}
+	if err := (&UserSession{ID: phone.ID}).fetch(store); err != ErrEntityNotFound {
+		t.Error("userSession.fetch: deleted:", err)
+	}
+
+	// Revoke all sessions
+	tablet := &UserSession{UserID: user.ID, UserAgent: "Tablet"}
+	if _, err := tablet.insert(store); err != nil {
+		t.Fatal("userSession.insert:", err)
+	}
+	if err := user.deleteSessions(store); err != nil {
+		t.Error("user.deleteSessions:", err)
+	}
+	if userSessions, err := user.sessions(store); err != nil || len(userSessions) != 0 {
+		t.Error("user.sessions: all deleted:", userSessions, err)
+	}
+	if err := (&UserSession{ID: tablet.ID}).fetch(store); err != ErrEntityNotFound {
+		t.Error("userSession.fetch: all deleted:", err)
+	}
+}
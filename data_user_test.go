@@ -1,61 +1,207 @@
 package main
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 func TestUser(t *testing.T) {
+	t.Parallel()
+
 	var err error
 
-	if db, err = redis.Dial("tcp", ":6379"); err != nil {
-		t.Error(err)
-	}
-	defer db.Close()
+	store := newTestStore(t)
+	defer store.Close()
 
 	// Insert user
-	user := User{
-		"firstname": "Jane",
-		"lastname":  "Doe",
-		"email":     "jane.doe@example.com",
-		"password":  "abcd1234",
-		"imageURL":  "content/jane_doe.jpg",
+	user := &User{
+		Firstname: "Jane",
+		Lastname:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "abcd1234",
+		ImageURL:  "content/jane_doe.jpg",
+		Interests: []string{"food"},
 	}
 
-	var userID int
-	if userID, err = user.insert(); err != nil {
+	if _, err = user.insert(store); err != nil {
 		t.Error("user.insert:", err)
 	}
-	user["id"] = userID
 
 	// Update user
-	user["firstname"] = "John"
-	user["lastname"] = "Cook"
-	user["email"] = "john.cook@example.com"
-	user["password"] = "1234abcd"
-	user["imageURL"] = "content/john_cook.jpg"
-	if err := user.update(); err != nil {
+	user.Firstname = "John"
+	user.Lastname = "Cook"
+	user.Email = "john.cook@example.com"
+	user.Password = "1234abcd"
+	user.ImageURL = "content/john_cook.jpg"
+	if err := user.update(store); err != nil {
 		t.Error("updateUser:", err)
 	}
 
 	// Get user
-	if _, err = user.fetch(); err != nil {
+	fetched := &User{ID: user.ID}
+	if err = fetched.fetch(store); err != nil {
 		t.Error("user.fetch:", err)
+	} else if fetched.Firstname != "John" || !fetched.InterestedIn("food") {
+		t.Error("user.fetch:", fetched)
+	}
+
+	// Updating a stale copy doesn't undo the role, block, verification or session version
+	stale := *fetched
+	if err := fetched.setRole(store, RoleAdmin); err != nil {
+		t.Error("user.setRole:", err)
+	}
+	if err := fetched.setBlocked(store, true); err != nil {
+		t.Error("user.setBlocked:", err)
+	}
+	stale.Role, stale.Blocked, stale.Verified, stale.SessionVersion = RoleGuest, false, true, 5
+	stale.Nickname = "Cookie"
+	if err := stale.update(store); err != nil {
+		t.Error("updateUser: stale:", err)
+	}
+	fetched = &User{ID: user.ID}
+	if err = fetched.fetch(store); err != nil {
+		t.Error("user.fetch:", err)
+	} else if fetched.Nickname != "Cookie" || fetched.Role != RoleAdmin || !fetched.Blocked || fetched.Verified || fetched.SessionVersion != 0 {
+		t.Error("updateUser: stale:", fetched)
+	}
+
+	// Get user by email
+	fetched = &User{Email: "john.cook@example.com"}
+	if err = fetched.fetch(store); err != nil || fetched.ID != user.ID {
+		t.Error("user.fetch:", err)
+	}
+
+	// The old email doesn't lead to the user anymore
+	if (&User{Email: "jane.doe@example.com"}).exists(store, true) {
+		t.Error("user.exists: old email")
 	}
 
 	// Has user
-	if ok, _ := user._exists(); !ok {
+	if ok, _ := user._exists(store); !ok {
 		t.Error("user._exists")
 	}
 
 	// Get users
-	if users, err := fetchUsers(map[string]interface{}{"count": 5}); err != nil || len(users) < 1 {
+	if users, err := fetchUsers(store, map[string]interface{}{"count": 5}); err != nil || len(users) < 1 {
 		t.Error("fetchUsers:", err)
 	}
 
 	// Delete user
-	if err = user.delete(); err != nil {
+	if err = user.delete(store); err != nil {
 		t.Error("user.delete:", err)
 	}
 }
+
+func TestUserVisibility(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Ann", Email: "ann.visibility@example.com", Password: "abcd1234", WechatNumber: "ann_wechat"}
+	otherUser := &User{Firstname: "Bob", Email: "bob.visibility@example.com", Password: "abcd1234"}
+	for _, u := range []*User{user, otherUser} {
+		if _, err := u.insert(store); err != nil {
+			t.Fatal("user.insert:", err)
+		}
+		defer u.delete(store)
+	}
+
+	// Guests and unconnected users only see the public view
+	for _, viewer := range []*User{nil, otherUser} {
+		if view, err := user.viewFor(store, viewer); err != nil {
+			t.Error("user.viewFor:", err)
+		} else if view.Email != "" || view.WechatNumber != "" || view.Firstname != "Ann" {
+			t.Error("user.viewFor: public view", view)
+		}
+	}
+
+	// Connecting to a user only asks them, so neither sees more than the public view
+	if err := otherUser.addUser(store, user); err != nil {
+		t.Fatal("user.addUser:", err)
+	}
+	defer user.removeUser(store, otherUser)
+	for _, pair := range [][2]*User{{user, otherUser}, {otherUser, user}} {
+		if view, err := pair[0].viewFor(store, pair[1]); err != nil {
+			t.Error("user.viewFor:", err)
+		} else if view.Email != "" || view.WechatNumber != "" {
+			t.Error("user.viewFor: one-sided connection", view)
+		}
+	}
+	if requests, err := user.connectionRequests(store); err != nil || len(requests) != 1 || requests[0].ID != otherUser.ID {
+		t.Error("user.connectionRequests:", requests, err)
+	}
+
+	// Connecting back accepts, after which they see contact numbers
+	if err := user.addUser(store, otherUser); err != nil {
+		t.Fatal("user.addUser:", err)
+	}
+	if view, err := user.viewFor(store, otherUser); err != nil {
+		t.Error("user.viewFor:", err)
+	} else if view.Email != user.Email || view.WechatNumber != "ann_wechat" {
+		t.Error("user.viewFor: connected view", view)
+	}
+	if requests, err := user.connectionRequests(store); err != nil || len(requests) != 0 {
+		t.Error("user.connectionRequests: accepted:", requests, err)
+	}
+	if connections, err := otherUser.connections(store); err != nil || len(connections) != 1 || connections[0].ID != user.ID {
+		t.Error("user.connections:", connections, err)
+	}
+
+	// No view includes the password
+	if data, err := json.Marshal(user.view(VisibilitySelf)); err != nil {
+		t.Error("json.Marshal:", err)
+	} else if strings.Contains(string(data), "password") {
+		t.Error("user.view: password", string(data))
+	}
+}
+
+func TestUserDeleteBookings(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Deleted", Email: "deleted.bookings@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("User.insert:", err)
+	}
+
+	longTable := &LongTable{Name: "Deleted user's longTable", NumSeats: 2}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+	longTableBooking := &LongTableBooking{LongTableID: longTable.ID, UserID: user.ID, Date: "18-12-2030", PartySize: 2}
+	if _, err := longTableBooking.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+
+	room := &Room{Name: "104", Type: "test-deleted-user", Capacity: 1}
+	if _, err := room.insert(store); err != nil {
+		t.Fatal("Room.insert:", err)
+	}
+	defer room.delete(store)
+	roomBooking := &RoomBooking{RoomID: room.ID, UserID: user.ID, CheckinDate: "10-04-2030", CheckoutDate: "12-04-2030"}
+	if _, err := roomBooking.insert(store); err != nil {
+		t.Fatal("RoomBooking.insert:", err)
+	}
+
+	// Deleting the User deletes its bookings, and frees what they held
+	if err := user.delete(store); err != nil {
+		t.Fatal("User.delete:", err)
+	}
+	if (&LongTableBooking{ID: longTableBooking.ID}).exists(store, false) {
+		t.Error("User.delete: long table booking wasn't deleted")
+	}
+	if available, err := longTable.fetchAvailableSeats(store, longTableBooking.Date, ""); err != nil || len(available) != 2 {
+		t.Error("LongTable.fetchAvailableSeats:", available, err)
+	}
+	if (&RoomBooking{ID: roomBooking.ID}).exists(store, false) {
+		t.Error("User.delete: room booking wasn't deleted")
+	}
+	if rooms, err := getAvailableRooms(store, roomBooking.CheckinDate, roomBooking.CheckoutDate, map[string]interface{}{"type": room.Type}); err != nil || len(rooms) != 1 {
+		t.Error("getAvailableRooms:", rooms, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+)
+
+// TokensView is the JSON representation of the tokens of a UserSession, for API
+// clients that send an Authorization header instead of keeping cookies
+type TokensView struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// Create an access token and a refresh token for UserSession.
+// The access token expires after accessTokenTTL, after which the refresh token
+// gets a new pair of tokens.
+func (userSession *UserSession) createTokens(store Store) (TokensView, error) {
+	if userSession.ID == "" {
+		return TokensView{}, ErrMissingKey
+	}
+
+	accessToken, err := randomCode()
+	if err != nil {
+		return TokensView{}, err
+	}
+	refreshToken, err := randomCode()
+	if err != nil {
+		return TokensView{}, err
+	}
+
+	if err := store.SetEx(fmt.Sprint("accessToken:", accessToken), userSession.ID, accessTokenTTL); err != nil {
+		return TokensView{}, err
+	}
+	if err := store.SetEx(fmt.Sprint("refreshToken:", refreshToken), userSession.ID, userSessionIdleTimeout); err != nil {
+		return TokensView{}, err
+	}
+
+	return TokensView{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Exchange a refresh token for a new pair of tokens of the same UserSession.
+// Refresh tokens can only be used once, and not after their session was revoked.
+func refreshTokens(store Store, refreshToken string) (*UserSession, TokensView, error) {
+	if refreshToken == "" {
+		return nil, TokensView{}, ErrInvalidToken
+	}
+
+	sessionID, err := store.GetDel(fmt.Sprint("refreshToken:", refreshToken))
+	if err == ErrNil {
+		return nil, TokensView{}, ErrInvalidToken
+	} else if err != nil {
+		return nil, TokensView{}, err
+	}
+
+	userSession := &UserSession{ID: sessionID}
+	if err := userSession.fetch(store); err == ErrEntityNotFound {
+		return nil, TokensView{}, ErrInvalidToken
+	} else if err != nil {
+		return nil, TokensView{}, err
+	}
+
+	tokens, err := userSession.createTokens(store)
+	if err != nil {
+		return nil, TokensView{}, err
+	}
+
+	return userSession, tokens, nil
+}
+
+// Fetch the UserSession of an access token
+func fetchSessionByAccessToken(store Store, accessToken string) (*UserSession, error) {
+	if accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	sessionID, err := store.Get(fmt.Sprint("accessToken:", accessToken))
+	if err == ErrNil {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	userSession := &UserSession{ID: sessionID}
+	if err := userSession.fetch(store); err == ErrEntityNotFound {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	return userSession, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestUserToken(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Toby", Email: "toby.token@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	userSession := &UserSession{UserID: user.ID, UserAgent: "App"}
+	if _, err := userSession.insert(store); err != nil {
+		t.Fatal("userSession.insert:", err)
+	}
+
+	tokens, err := userSession.createTokens(store)
+	if err != nil {
+		t.Fatal("userSession.createTokens:", err)
+	}
+	if tokens.AccessToken == tokens.RefreshToken || tokens.TokenType != "Bearer" || tokens.ExpiresIn != 3600 {
+		t.Error("userSession.createTokens:", tokens)
+	}
+
+	// The access token leads to the session
+	if fetched, err := fetchSessionByAccessToken(store, tokens.AccessToken); err != nil || fetched.ID != userSession.ID {
+		t.Error("fetchSessionByAccessToken:", err)
+	}
+	if _, err := fetchSessionByAccessToken(store, tokens.RefreshToken); err != ErrInvalidToken {
+		t.Error("fetchSessionByAccessToken: refresh token:", err)
+	}
+
+	// Refresh tokens are rotated
+	refreshed, newTokens, err := refreshTokens(store, tokens.RefreshToken)
+	if err != nil || refreshed.ID != userSession.ID || newTokens.AccessToken == tokens.AccessToken {
+		t.Error("refreshTokens:", newTokens, err)
+	}
+	if _, _, err := refreshTokens(store, tokens.RefreshToken); err != ErrInvalidToken {
+		t.Error("refreshTokens: used twice:", err)
+	}
+	if _, err := fetchSessionByAccessToken(store, newTokens.AccessToken); err != nil {
+		t.Error("fetchSessionByAccessToken: new token:", err)
+	}
+
+	// Revoking the session revokes its tokens
+	if err := userSession.delete(store); err != nil {
+		t.Fatal("userSession.delete:", err)
+	}
+	if _, err := fetchSessionByAccessToken(store, newTokens.AccessToken); err != ErrInvalidToken {
+		t.Error("fetchSessionByAccessToken: revoked:", err)
+	}
+	if _, _, err := refreshTokens(store, newTokens.RefreshToken); err != ErrInvalidToken {
+		t.Error("refreshTokens: revoked:", err)
+	}
+}
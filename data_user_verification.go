@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Create a verification code for User, which expires after verificationCodeTTL
+func (user *User) createVerificationCode(store Store) (string, error) {
+	if user.ID == 0 {
+		return "", ErrMissingKey
+	}
+
+	code, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.SetEx(fmt.Sprint("verificationCode:", code), user.ID, verificationCodeTTL); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// Mark the User of a verification code as verified.
+// Codes can only be used once.
+func verifyUser(store Store, code string) (*User, error) {
+	if code == "" {
+		return nil, ErrInvalidVerificationCode
+	}
+
+	userID, err := store.GetDel(fmt.Sprint("verificationCode:", code))
+	if err == ErrNil {
+		return nil, ErrInvalidVerificationCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	user := &User{}
+	if user.ID, err = strconv.Atoi(userID); err != nil {
+		return nil, err
+	}
+	if err := user.fetch(store); err != nil {
+		return nil, err
+	}
+
+	if err := user.setVerified(store, true); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Set whether User has verified their email
+func (user *User) setVerified(store Store, verified bool) error {
+	if user.ID == 0 {
+		return ErrMissingKey
+	}
+
+	user.Verified = verified
+	user.UpdatedAt = time.Now().Unix()
+
+	return store.HMSet(fmt.Sprint("user:", user.ID), map[string]interface{}{
+		"verified":  user.Verified,
+		"updatedAt": user.UpdatedAt,
+	})
+}
+
+// Generate a random code that's safe to put in URLs
+func randomCode() (string, error) {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestUserVerification(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Vera", Email: "vera.verification@example.com", Password: "abcd1234"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	// Send the code by email
+	path := filepath.Join(t.TempDir(), "mail")
+	s := &Server{store: store, mailer: newFileMailer(path)}
+	if err := s.sendVerificationCode(user); err != nil {
+		t.Fatal("Server.sendVerificationCode:", err)
+	}
+
+	mail, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("ioutil.ReadFile:", err)
+	}
+	match := regexp.MustCompile(`To: (.*)\n(?s:.*)code=([0-9a-f]+)`).FindSubmatch(mail)
+	if match == nil || string(match[1]) != user.Email {
+		t.Fatal("FileMailer.Send:", string(mail))
+	}
+	code := string(match[2])
+
+	// Unknown codes don't verify anyone
+	if _, err := verifyUser(store, "0123"); err != ErrInvalidVerificationCode {
+		t.Error("verifyUser: unknown code:", err)
+	}
+
+	// The code verifies the User
+	if verified, err := verifyUser(store, code); err != nil || verified.ID != user.ID {
+		t.Error("verifyUser:", err)
+	}
+	fetched := &User{ID: user.ID}
+	if err := fetched.fetch(store); err != nil || !fetched.Verified {
+		t.Error("user.fetch: verified:", fetched.Verified, err)
+	}
+
+	// Codes can only be used once
+	if _, err := verifyUser(store, code); err != ErrInvalidVerificationCode {
+		t.Error("verifyUser: used code:", err)
+	}
+}
+
+func TestUserHandlerEmail(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	user := &User{Firstname: "Edna", Email: "edna.email@example.com", Verified: true}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	path := filepath.Join(t.TempDir(), "mail")
+	s := &Server{store: store, mailer: newFileMailer(path)}
+	cookies := loginCookies(t, s, user)
+
+	patch := func(form url.Values) {
+		r := httptest.NewRequest("PATCH", "/api/user", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.userHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatal("userHandler: PATCH:", form, w.Code, w.Body)
+		}
+	}
+	fetch := func() *User {
+		fetched := &User{ID: user.ID}
+		if err := fetched.fetch(store); err != nil {
+			t.Fatal("user.fetch:", err)
+		}
+		return fetched
+	}
+
+	// Leaving the email out, or sending the same one, keeps it verified
+	patch(url.Values{"nickname": {"Ed"}})
+	patch(url.Values{"nickname": {"Ed"}, "email": {user.Email}})
+	if fetched := fetch(); fetched.Email != user.Email || !fetched.Verified || fetched.Nickname != "Ed" {
+		t.Error("userHandler: PATCH: email kept:", fetched.Email, fetched.Verified, fetched.Nickname)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("userHandler: PATCH: code sent for the same email:", err)
+	}
+
+	// A new email has to be verified
+	patch(url.Values{"email": {"edna.new@example.com"}})
+	if fetched := fetch(); fetched.Email != "edna.new@example.com" || fetched.Verified {
+		t.Error("userHandler: PATCH: email changed:", fetched.Email, fetched.Verified)
+	}
+	if mail, err := ioutil.ReadFile(path); err != nil || !strings.Contains(string(mail), "To: edna.new@example.com") {
+		t.Error("userHandler: PATCH: no code sent:", string(mail), err)
+	}
+}
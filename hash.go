@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Convert a pointer to struct to Redis hash fields.
+// Fields are named by their `redis` tag; fields without one, or with
+// `redis:"-"`, aren't stored.
+func hashFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("redis")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = value.Field(i).Interface()
+	}
+
+	return fields
+}
+
+// Set the fields of a pointer to struct from a Redis hash, matching the
+// hash fields to the `redis` tags of the struct fields.
+// Hash fields without a matching struct field are ignored.
+func scanHash(fields map[string]string, v interface{}) error {
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("redis")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		s, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(s)
+		case reflect.Int, reflect.Int64:
+			if s == "" {
+				field.SetInt(0)
+			} else if n, err := strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			} else {
+				field.SetInt(n)
+			}
+		case reflect.Bool:
+			if s == "" {
+				field.SetBool(false)
+			} else if b, err := strconv.ParseBool(s); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			} else {
+				field.SetBool(b)
+			}
+		default:
+			return fmt.Errorf("%s: unsupported type %s", name, field.Type())
+		}
+	}
+
+	return nil
+}
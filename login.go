@@ -1,48 +1,250 @@
 package main
 
 import (
+	"context"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-func loggedIn(w http.ResponseWriter, r *http.Request, fetchUser bool) (bool, User) {
-	session, err := ss.Get(r, "session")
+type contextKey int
+
+// Key of the logged in User in the context of requests that passed requireRole
+const userContextKey contextKey = iota
+
+func (s *Server) loggedIn(w http.ResponseWriter, r *http.Request, fetchUser bool) (bool, *User) {
+	userSession, err := s.requestSession(r)
 	if err != nil {
-		log.Println(err)
 		return false, nil
 	}
 
-	val := session.Values["userID"]
-	if userID, ok := val.(int); !ok {
+	user := &User{ID: userSession.UserID}
+	if exists := user.exists(s.store, fetchUser); !exists {
 		return false, nil
-	} else {
-		user := User{"id": userID}
-		if exists, user := user.exists(fetchUser); !exists {
-			return false, nil
-		} else {
-			return true, user
+	}
+
+	// Blocked Users are logged out
+	if blocked, err := user.isBlocked(s.store); err != nil {
+		log.Println(err)
+		return false, nil
+	} else if blocked {
+		return false, nil
+	}
+
+	if err := userSession.touch(s.store); err != nil {
+		log.Println(err)
+	}
+
+	return true, user
+}
+
+// Get the UserSession of a request, from its bearer token or else its cookie.
+// It fails if the session was revoked or expired.
+func (s *Server) requestSession(r *http.Request) (*UserSession, error) {
+	// API clients send an access token
+	if token, ok := bearerToken(r); ok {
+		return fetchSessionByAccessToken(s.store, token)
+	}
+
+	session, err := ss.Get(r, "session")
+	if err != nil {
+		return nil, err
+	}
+
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return nil, ErrNotLoggedIn
+	}
+
+	// Sessions from before the User's password was reset are no longer valid
+	sessionVersion, _ := session.Values["sessionVersion"].(int)
+	if version, err := (&User{ID: userID}).sessionVersion(s.store); err != nil {
+		return nil, err
+	} else if version != sessionVersion {
+		return nil, ErrNotLoggedIn
+	}
+
+	sessionID, _ := session.Values["sessionID"].(string)
+	userSession := &UserSession{ID: sessionID}
+	if err := userSession.fetch(s.store); err != nil {
+		return nil, err
+	} else if userSession.UserID != userID {
+		return nil, ErrNotLoggedIn
+	}
+
+	return userSession, nil
+}
+
+// Get the bearer token of the Authorization header of a request
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:]), true
+	}
+	return "", false
+}
+
+// Find the User with email and check its password.
+// Clients and emails that fail too often are locked out for a while, in which
+// case it fails with ErrTooManyLoginAttempts and how long to wait.
+func (s *Server) checkPassword(r *http.Request, email, password string) (*User, time.Duration, error) {
+	ipSubject := loginIPSubject(clientIP(r))
+	emailSubject := loginEmailSubject(email)
+
+	// Check the lockouts
+	for _, subject := range []string{ipSubject, emailSubject} {
+		if lockout, err := loginLockout(s.store, subject); err != nil {
+			return nil, 0, err
+		} else if lockout > 0 {
+			return nil, lockout, ErrTooManyLoginAttempts
+		}
+	}
+
+	user := &User{Email: email}
+	if exists := user.exists(s.store, true); !exists || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if err := recordLoginFailure(s.store, ipSubject, maxIPLoginFailures); err != nil {
+			return nil, 0, err
+		}
+		if err := recordLoginFailure(s.store, emailSubject, maxEmailLoginFailures); err != nil {
+			return nil, 0, err
 		}
+		return nil, 0, ErrPasswordMismatch
+	}
+
+	// Only tell that the User is blocked to whoever knows its password
+	if user.Blocked {
+		return nil, 0, ErrUserBlocked
 	}
+
+	// The client might share its IP with others, so only the email is forgiven
+	if err := clearLoginFailures(s.store, emailSubject); err != nil {
+		return nil, 0, err
+	}
+
+	return user, 0, nil
+}
+
+// Respond with an error of checkPassword
+func loginError(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	switch err {
+	case ErrTooManyLoginAttempts:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case ErrPasswordMismatch, ErrUserBlocked:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Record a new session of User, from the device of a request
+func (s *Server) startSession(r *http.Request, user *User) (*UserSession, error) {
+	userSession := &UserSession{UserID: user.ID, UserAgent: r.UserAgent(), IP: clientIP(r)}
+	if _, err := userSession.insert(s.store); err != nil {
+		return nil, err
+	}
+	return userSession, nil
 }
 
-func logIn(w http.ResponseWriter, r *http.Request, user User) error {
+func (s *Server) logIn(w http.ResponseWriter, r *http.Request, user *User) error {
+	// Cookies that can't be decoded, e.g. because their key was retired, are
+	// replaced by a new session
 	session, err := ss.Get(r, "session")
+	if err != nil {
+		log.Println(err)
+	}
+
+	// Logging in again replaces the session that was logged in before
+	if sessionID, ok := session.Values["sessionID"].(string); ok {
+		if err := (&UserSession{ID: sessionID}).delete(s.store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+
+	// Logging in starts over with a new session ID and CSRF token, so that
+	// whoever knew them before, e.g. by planting the cookie, can't use them
+	if err := renewSession(s.store, session); err != nil {
+		return err
+	}
+	delete(session.Values, csrfFormField)
+
+	// Record the session so that it can be listed and revoked
+	userSession, err := s.startSession(r, user)
 	if err != nil {
 		return err
 	}
 
-	session.Values["userID"] = user["id"].(int)
-	session.Save(r, w)
-	return nil
+	session.Values["userID"] = user.ID
+	session.Values["sessionVersion"] = user.SessionVersion
+	session.Values["sessionID"] = userSession.ID
+	return session.Save(r, w)
 }
 
-func logOut(w http.ResponseWriter, r *http.Request) error {
+func (s *Server) logOut(w http.ResponseWriter, r *http.Request) error {
+	// API clients log out by revoking the session of their token
+	if _, ok := bearerToken(r); ok {
+		userSession, err := s.requestSession(r)
+		if err == ErrInvalidToken {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return userSession.delete(s.store)
+	}
+
 	session, err := ss.Get(r, "session")
 	if err != nil {
-		return err
+		log.Println(err)
+	}
+
+	// Revoke the session, so that copies of the cookie can't be used anymore
+	if sessionID, ok := session.Values["sessionID"].(string); ok {
+		if err := (&UserSession{ID: sessionID}).delete(s.store); err != nil && err != ErrEntityNotFound {
+			return err
+		}
+	}
+
+	// Delete the session, which also removes it from the store if it's kept there
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	return session.Save(r, w)
+}
+
+// Get the ID of the UserSession of the request, if it's logged in
+func (s *Server) currentSessionID(r *http.Request) string {
+	if userSession, err := s.requestSession(r); err == nil {
+		return userSession.ID
+	}
+	return ""
+}
+
+// Wrap handler so that it's only run for logged in Users with role, or a more
+// privileged one. The User is available to handler through requestUser.
+func (s *Server) requireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loggedIn, user := s.loggedIn(w, r, true)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		if !user.HasRole(role) {
+			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
 	}
+}
 
-	session.Values["userID"] = 0
-	session.Save(r, w)
-	return nil
+// Get the User that requireRole let through
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
 }
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is a goth.Provider that identifies everyone as the same user
+type fakeProvider struct {
+	name string
+	user goth.User
+}
+
+type fakeSession struct {
+	authURL string
+}
+
+func (provider *fakeProvider) Name() string        { return provider.name }
+func (provider *fakeProvider) SetName(name string) { provider.name = name }
+func (provider *fakeProvider) Debug(bool)          {}
+
+func (provider *fakeProvider) BeginAuth(state string) (goth.Session, error) {
+	return &fakeSession{authURL: "https://provider.example.com/auth?state=" + url.QueryEscape(state)}, nil
+}
+
+func (provider *fakeProvider) UnmarshalSession(data string) (goth.Session, error) {
+	return &fakeSession{authURL: data}, nil
+}
+
+func (provider *fakeProvider) FetchUser(session goth.Session) (goth.User, error) {
+	user := provider.user
+	user.Provider = provider.name
+	return user, nil
+}
+
+func (provider *fakeProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	return nil, errors.New("not supported")
+}
+
+func (provider *fakeProvider) RefreshTokenAvailable() bool { return false }
+
+func (session *fakeSession) GetAuthURL() (string, error) { return session.authURL, nil }
+func (session *fakeSession) Marshal() string             { return session.authURL }
+
+func (session *fakeSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	return "token", nil
+}
+
+// Go through the social login flow of a provider with the cookies of the
+// visitor, and return the response of the callback
+func authenticate(s *Server, provider string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	begin := httptest.NewRequest("GET", "/auth/"+provider+"?provider="+provider, nil)
+	beginResponse := httptest.NewRecorder()
+	gothic.BeginAuthHandler(beginResponse, begin)
+
+	location, _ := url.Parse(beginResponse.Header().Get("Location"))
+	callback := httptest.NewRequest("GET", "/auth/"+provider+"/callback?provider="+provider+"&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	for _, cookie := range append(beginResponse.Result().Cookies(), cookies...) {
+		callback.AddCookie(cookie)
+	}
+
+	response := httptest.NewRecorder()
+	s.authHandler(response, callback)
+	return response
+}
+
+// Get the cookies of a logged in User
+func loginCookies(t *testing.T, s *Server, user *User) []*http.Cookie {
+	response := httptest.NewRecorder()
+	if err := s.logIn(response, httptest.NewRequest("GET", "/", nil), user); err != nil {
+		t.Fatal("logIn:", err)
+	}
+	return response.Result().Cookies()
+}
+
+func TestSocialLogin(t *testing.T) {
+	gothic.Store = sessions.NewCookieStore([]byte("test"))
+	goth.UseProviders(
+		&fakeProvider{name: "fake-new", user: goth.User{UserID: "1", Name: "Sam de Vries", Email: "sam.social@example.com"}},
+		&fakeProvider{name: "fake-link", user: goth.User{UserID: "2", FirstName: "Sam"}},
+	)
+
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	// New visitors are signed up
+	if response := authenticate(s, "fake-new", nil); response.Code != http.StatusTemporaryRedirect {
+		t.Fatal("authHandler: sign up:", response.Code, response.Body)
+	}
+	user, err := fetchUserByProvider(store, "fake-new", "1")
+	if err != nil {
+		t.Fatal("fetchUserByProvider:", err)
+	}
+	defer user.delete(store)
+	if user.Firstname != "Sam de" || user.Lastname != "Vries" || !user.Verified {
+		t.Error("authHandler: sign up:", user)
+	}
+
+	// Returning visitors are logged in to the same User
+	response := authenticate(s, "fake-new", nil)
+	if response.Code != http.StatusTemporaryRedirect {
+		t.Error("authHandler: log in:", response.Code, response.Body)
+	}
+	request := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range response.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	if loggedIn, loggedInUser := s.loggedIn(response, request, false); !loggedIn || loggedInUser.ID != user.ID {
+		t.Error("authHandler: log in:", loggedInUser)
+	}
+
+	// Logged in Users link the provider to their account
+	if response := authenticate(s, "fake-link", loginCookies(t, s, user)); response.Code != http.StatusTemporaryRedirect {
+		t.Error("authHandler: link:", response.Code, response.Body)
+	}
+	if linked, err := fetchUserByProvider(store, "fake-link", "2"); err != nil || linked.ID != user.ID {
+		t.Error("authHandler: link:", err)
+	}
+
+	// The provider can't be linked to another User
+	otherUser := &User{Firstname: "Kim", Email: "kim.social@example.com"}
+	if _, err := otherUser.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer otherUser.delete(store)
+	if response := authenticate(s, "fake-link", loginCookies(t, s, otherUser)); response.Code != http.StatusBadRequest {
+		t.Error("authHandler: link to other user:", response.Code, response.Body)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	guest := &User{Firstname: "Gus", Email: "gus.require@example.com"}
+	admin := &User{Firstname: "Ada", Email: "ada.require@example.com", Role: RoleAdmin}
+	for _, user := range []*User{guest, admin} {
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("user.insert:", err)
+		}
+		defer user.delete(store)
+	}
+
+	var handled *User
+	handler := s.requireRole(RoleStaff, func(w http.ResponseWriter, r *http.Request) {
+		handled = requestUser(r)
+	})
+
+	request := func(cookies []*http.Cookie) int {
+		r := httptest.NewRequest("POST", "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// Visitors and Users without the role are turned away
+	if code := request(nil); code != http.StatusForbidden || handled != nil {
+		t.Error("requireRole: visitor:", code)
+	}
+	if code := request(loginCookies(t, s, guest)); code != http.StatusForbidden || handled != nil {
+		t.Error("requireRole: guest:", code)
+	}
+
+	// More privileged Users are let through
+	if code := request(loginCookies(t, s, admin)); code != http.StatusOK || handled == nil || handled.ID != admin.ID {
+		t.Error("requireRole: admin:", code)
+	}
+
+	// Admins assign roles to other Users, but not to themselves
+	assign := func(user *User, role string) int {
+		r := httptest.NewRequest("POST", fmt.Sprint("/api/admin/user/role?id=", user.ID, "&role=", role), nil)
+		for _, cookie := range loginCookies(t, s, admin) {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.requireRole(RoleAdmin, s.adminUserRoleHandler)(w, r)
+		return w.Code
+	}
+	if code := assign(guest, RoleStaff); code != http.StatusOK {
+		t.Error("adminUserRoleHandler:", code)
+	}
+	if code := request(loginCookies(t, s, guest)); code != http.StatusOK {
+		t.Error("requireRole: promoted guest:", code)
+	}
+	if code := assign(guest, "owner"); code != http.StatusBadRequest {
+		t.Error("adminUserRoleHandler: unknown role:", code)
+	}
+	if code := assign(admin, RoleGuest); code != http.StatusForbidden {
+		t.Error("adminUserRoleHandler: self:", code)
+	}
+}
+
+func TestLogOutEverywhere(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	user := &User{Firstname: "Eve", Email: "eve.everywhere@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	// Send a request with cookies to handler
+	request := func(handler http.HandlerFunc, method, target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	loggedIn := func(cookies []*http.Cookie) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		ok, _ := s.loggedIn(httptest.NewRecorder(), r, false)
+		return ok
+	}
+
+	// Log in on two devices
+	phone := loginCookies(t, s, user)
+	laptop := loginCookies(t, s, user)
+	if !loggedIn(phone) || !loggedIn(laptop) {
+		t.Fatal("logIn: not logged in")
+	}
+
+	// List the sessions
+	response := request(s.userSessionsHandler, "GET", "/api/user/sessions", phone)
+	var views []UserSessionView
+	if err := json.Unmarshal(response.Body.Bytes(), &views); err != nil || len(views) != 2 {
+		t.Fatal("userSessionsHandler: GET:", response.Body, err)
+	}
+	var laptopID string
+	for _, view := range views {
+		if !view.Current {
+			laptopID = view.ID
+		}
+	}
+
+	// Revoke the laptop's session from the phone
+	if response := request(s.userSessionHandler, "DELETE", "/api/user/session?id="+laptopID, phone); response.Code != http.StatusOK {
+		t.Error("userSessionHandler: DELETE:", response.Code, response.Body)
+	}
+	if !loggedIn(phone) || loggedIn(laptop) {
+		t.Error("userSessionHandler: DELETE: wrong session revoked")
+	}
+
+	// Logging out revokes the session, even if the cookie was copied
+	tablet := loginCookies(t, s, user)
+	if response := request(s.logoutHandler, "GET", "/api/logout", tablet); response.Code != http.StatusOK {
+		t.Error("logoutHandler:", response.Code)
+	}
+	if loggedIn(tablet) {
+		t.Error("logoutHandler: copied cookie still logged in")
+	}
+
+	// Log out everywhere
+	desktop := loginCookies(t, s, user)
+	if response := request(s.userSessionsHandler, "DELETE", "/api/user/sessions", desktop); response.Code != http.StatusOK {
+		t.Error("userSessionsHandler: DELETE:", response.Code, response.Body)
+	}
+	if loggedIn(phone) || loggedIn(desktop) {
+		t.Error("userSessionsHandler: DELETE: still logged in")
+	}
+}
+
+// Doesn't run in parallel, since it replaces the session store of everyone
+func TestLogInRenewsSession(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	sessionStore, err := newSessionStore(SessionOptions{Keys: []string{"key"}, MaxAge: time.Hour, Store: store})
+	if err != nil {
+		t.Fatal("newSessionStore:", err)
+	}
+	defer func(previous sessions.Store) { ss = previous }(ss)
+	ss = sessionStore
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	user := &User{Firstname: "Fay", Email: "fay.fixation@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+
+	// A visitor gets a session with a CSRF token before logging in
+	r := httptest.NewRequest("GET", "/api/csrf", nil)
+	w := httptest.NewRecorder()
+	s.csrfHandler(w, r)
+	planted := w.Result().Cookies()
+	before, err := loadSession(sessionStore, planted)
+	if err != nil || before.ID == "" {
+		t.Fatal("csrfHandler:", before.ID, err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range planted {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	if err := s.logIn(w, r, user); err != nil {
+		t.Fatal("logIn:", err)
+	}
+
+	// Logging in gives the session a new ID and CSRF token
+	after, err := loadSession(sessionStore, w.Result().Cookies())
+	if err != nil || after.ID == "" || after.ID == before.ID || after.Values["userID"] != user.ID {
+		t.Error("logIn: session not renewed:", before.ID, after.ID, after.Values, err)
+	}
+	if _, ok := after.Values[csrfFormField]; ok {
+		t.Error("logIn: CSRF token kept")
+	}
+
+	// The old ID isn't logged in
+	if ok, err := store.Exists(sessionValuesKey(before.ID)); ok || err != nil {
+		t.Error("logIn: old session kept:", err)
+	}
+	if old, err := loadSession(sessionStore, planted); err != nil || old.Values["userID"] != nil {
+		t.Error("logIn: old session logged in:", old.Values, err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("abcd1234"), bcrypt.MinCost)
+	user := &User{Firstname: "Mo", Email: "mo.bearer@example.com", Password: string(hashedPassword)}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+	defer clearLoginFailures(store, loginIPSubject("192.0.2.1"))
+	defer clearLoginFailures(store, loginEmailSubject(user.Email))
+
+	// Send a form to handler, with an access token unless it's empty
+	request := func(handler http.HandlerFunc, target string, form url.Values, accessToken string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if accessToken != "" {
+			r.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	loggedIn := func(accessToken string) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+		ok, loggedInUser := s.loggedIn(httptest.NewRecorder(), r, false)
+		return ok && loggedInUser.ID == user.ID
+	}
+
+	// Wrong passwords don't get tokens
+	if response := request(s.tokenHandler, "/api/token", url.Values{"email": {user.Email}, "password": {"wrong-password"}}, ""); response.Code != http.StatusForbidden {
+		t.Error("tokenHandler: wrong password:", response.Code)
+	}
+
+	// Log in with the password
+	response := request(s.tokenHandler, "/api/token", url.Values{"email": {user.Email}, "password": {"abcd1234"}}, "")
+	var tokens TokensView
+	if err := json.Unmarshal(response.Body.Bytes(), &tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatal("tokenHandler:", response.Code, response.Body)
+	}
+	if !loggedIn(tokens.AccessToken) || loggedIn("unknown") {
+		t.Error("loggedIn: bearer token")
+	}
+
+	// Refresh the tokens
+	response = request(s.tokenRefreshHandler, "/api/token/refresh", url.Values{"refreshToken": {tokens.RefreshToken}}, "")
+	var newTokens TokensView
+	if err := json.Unmarshal(response.Body.Bytes(), &newTokens); err != nil || !loggedIn(newTokens.AccessToken) {
+		t.Fatal("tokenRefreshHandler:", response.Code, response.Body)
+	}
+	if response := request(s.tokenRefreshHandler, "/api/token/refresh", url.Values{"refreshToken": {tokens.RefreshToken}}, ""); response.Code != http.StatusForbidden {
+		t.Error("tokenRefreshHandler: used twice:", response.Code)
+	}
+
+	// Logging out revokes the tokens
+	if response := request(s.logoutHandler, "/api/logout", nil, newTokens.AccessToken); response.Code != http.StatusOK {
+		t.Error("logoutHandler:", response.Code)
+	}
+	if loggedIn(newTokens.AccessToken) {
+		t.Error("logoutHandler: still logged in")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("abcd1234"), bcrypt.MinCost)
+	user := &User{Firstname: "Lou", Email: "lou.lockout@example.com", Password: string(hashedPassword)}
+	admin := &User{Firstname: "Ada", Email: "ada.lockout@example.com", Role: RoleAdmin}
+	for _, u := range []*User{user, admin} {
+		if _, err := u.insert(store); err != nil {
+			t.Fatal("user.insert:", err)
+		}
+		defer u.delete(store)
+	}
+
+	// Clients get their own IP, so that they don't lock each other out
+	const ip = "198.51.100.19"
+	defer clearLoginFailures(store, loginIPSubject(ip))
+	defer clearLoginFailures(store, loginEmailSubject(user.Email))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/login", strings.NewReader(url.Values{"email": {user.Email}, "password": {password}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		s.loginHandler(w, r)
+		return w
+	}
+	asAdmin := func(handler http.HandlerFunc) int {
+		r := httptest.NewRequest("POST", fmt.Sprint("/api/admin/user?id=", user.ID), nil)
+		for _, cookie := range loginCookies(t, s, admin) {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.requireRole(RoleAdmin, handler)(w, r)
+		return w.Code
+	}
+
+	// Too many wrong passwords lock the email out, even with the right password
+	for i := 0; i < maxEmailLoginFailures; i++ {
+		if response := login("wrong-password"); response.Code != http.StatusForbidden {
+			t.Fatal("loginHandler: wrong password:", response.Code)
+		}
+	}
+	response := login("abcd1234")
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Error("loginHandler: locked out:", response.Code, response.Header())
+	}
+
+	// Admins unlock the email
+	if code := asAdmin(s.adminUserUnlockHandler); code != http.StatusOK {
+		t.Error("adminUserUnlockHandler:", code)
+	}
+	response = login("abcd1234")
+	if response.Code != http.StatusOK {
+		t.Fatal("loginHandler: unlocked:", response.Code, response.Body)
+	}
+	cookies := response.Result().Cookies()
+
+	// Blocked Users are logged out and can't log in again
+	if code := asAdmin(s.adminUserBlockHandler); code != http.StatusOK {
+		t.Error("adminUserBlockHandler:", code)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	if loggedIn, _ := s.loggedIn(httptest.NewRecorder(), r, false); loggedIn {
+		t.Error("loggedIn: blocked")
+	}
+	if response := login("abcd1234"); response.Code != http.StatusForbidden || !strings.Contains(response.Body.String(), ErrUserBlocked.Error()) {
+		t.Error("loginHandler: blocked:", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer writes emails to the log instead of sending them, for local development
+type LogMailer struct{}
+
+func (mailer LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// FileMailer appends emails to a file instead of sending them, so that they can
+// be read back, e.g. by tests
+type FileMailer struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func newFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+func (mailer *FileMailer) Send(to, subject, body string) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	file, err := os.OpenFile(mailer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
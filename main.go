@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,15 +11,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/codegangsta/negroni"
-	"github.com/garyburd/redigo/redis"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/pat"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
@@ -28,16 +30,34 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var db redis.Conn
-var ss = sessions.NewCookieStore([]byte("HbeA9vqJ7Wk+rLGmYzyp9SAdHxmK4EIVtylo/aXZ/ZA="))
+// Sessions of visitors, configured from the command-line flags in main
+var ss sessions.Store = sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
 var templates *template.Template
 
 // Command-line flags
 var address = flag.String("address", "http://localhost:8080", "server address")
 var port = flag.String("port", "8080", "server port")
 var serveTest = flag.Bool("serve-test", false, "serve front-end test sample")
+var ratelimits = flag.String("ratelimits", "search=30/1m:60/1m,api=300/1m:600/1m", "requests allowed per period to each group of routes (search or api), for anonymous clients and logged in users; empty for no limits")
+var trustproxy = flag.Bool("trustproxy", false, "take client IP addresses from the X-Forwarded-For header of a reverse proxy")
 var dbhost = flag.String("dbhost", "", "database host")
 var dbport = flag.String("dbport", "6379", "database port")
+var dbmaxidle = flag.Int("dbmaxidle", 10, "maximum number of idle database connections")
+var dbmaxactive = flag.Int("dbmaxactive", 100, "maximum number of open database connections, 0 for no limit")
+var dbidletimeout = flag.Duration("dbidletimeout", 4*time.Minute, "close idle database connections after this duration")
+var dbtimeout = flag.Duration("dbtimeout", 5*time.Second, "database connect, read and write timeout")
+var mediadir = flag.String("mediadir", "media", "folder of uploaded media files")
+var timezone = flag.String("timezone", "Local", "timezone of the property, e.g. Asia/Singapore, in which booking dates and opening hours are")
+var bookinghorizon = flag.Int("bookinghorizon", 60, "how many days ahead long tables can be booked, 0 for no limit")
+var waitlistclaimwindow = flag.Duration("waitlistclaimwindow", 2*time.Hour, "how long guests have to claim a long table seat offered from the waitlist before it passes to the next")
+var mailfile = flag.String("mailfile", "", "append emails to this file instead of logging them")
+var sessionkeys = flag.String("sessionkeys", os.Getenv("SESSION_KEYS"), "comma-separated keys that sign session cookies, newest first; each can be followed by ':' and a 16, 24 or 32 byte encryption key (default $SESSION_KEYS)")
+var sessionstore = flag.String("sessionstore", "cookie", "where session values are kept: cookie, or redis so that logging out revokes them")
+var cookiedomain = flag.String("cookiedomain", "", "domain of session cookies")
+var cookiesecure = flag.Bool("cookiesecure", false, "only send session cookies over HTTPS")
+var cookiehttponly = flag.Bool("cookiehttponly", true, "hide session cookies from JavaScript")
+var cookiesamesite = flag.String("cookiesamesite", "lax", "SameSite attribute of session cookies: lax, strict, none, or empty to leave it out")
+var cookiemaxage = flag.Duration("cookiemaxage", 30*24*time.Hour, "how long sessions last, 0 to end them with the browser")
 
 // Errors
 var (
@@ -48,50 +68,228 @@ var (
 	ErrNicknameTooShort  = errors.New("Nickname too short")
 	ErrInvalidGender     = errors.New("Invalid gender")
 
-	ErrNotLoggedIn         = errors.New("User is not logged in")
-	ErrPasswordMismatch    = errors.New("Password mismatch")
-	ErrWrongDateFormat     = errors.New("Wrong date format")
-	ErrTypeAssertionFailed = errors.New("Type assertion failed")
-	ErrEntityNotFound      = errors.New("Entity not found")
-	ErrEmptyParameter      = errors.New("Empty parameter")
-	ErrMissingKey          = errors.New("Missing key")
-	ErrIDMismach           = errors.New("ID mismatch")
-	ErrPermissionDenied    = errors.New("Permission denied")
-	ErrUserAlreadyBooked   = errors.New("User already booked")
-	ErrSeatIsUnavailable   = errors.New("Seat is unavailable")
+	ErrNotLoggedIn               = errors.New("User is not logged in")
+	ErrPasswordMismatch          = errors.New("Password mismatch")
+	ErrWrongDateFormat           = errors.New("Wrong date format")
+	ErrTypeAssertionFailed       = errors.New("Type assertion failed")
+	ErrEntityNotFound            = errors.New("Entity not found")
+	ErrEmptyParameter            = errors.New("Empty parameter")
+	ErrMissingKey                = errors.New("Missing key")
+	ErrIDMismach                 = errors.New("ID mismatch")
+	ErrPermissionDenied          = errors.New("Permission denied")
+	ErrUserAlreadyBooked         = errors.New("User already booked")
+	ErrSeatIsUnavailable         = errors.New("Seat is unavailable")
+	ErrRoomIsUnavailable         = errors.New("Room is unavailable")
+	ErrCheckoutBeforeCheckin     = errors.New("Checkout date must be after checkin date")
+	ErrStayTooLong               = errors.New("Stay is too long")
+	ErrRoomHasBookings           = errors.New("Room has upcoming bookings")
+	ErrInvalidCapacity           = errors.New("Invalid capacity")
+	ErrInvalidPostType           = errors.New("Invalid post type")
+	ErrUnsupportedMediaType      = errors.New("Unsupported media type")
+	ErrInvalidVerificationCode   = errors.New("Invalid verification code")
+	ErrNotVerified               = errors.New("User is not verified")
+	ErrAlreadyVerified           = errors.New("User is already verified")
+	ErrInvalidPasswordResetToken = errors.New("Invalid password reset token")
+	ErrProviderAlreadyLinked     = errors.New("Provider is already linked to another user")
+	ErrEmailAlreadyRegistered    = errors.New("Email is already registered")
+	ErrInvalidRole               = errors.New("Invalid role")
+	ErrUnknownCommand            = errors.New("Unknown command")
+	ErrInvalidSessionKey         = errors.New("Session encryption keys must be 16, 24 or 32 bytes long")
+	ErrInvalidSameSite           = errors.New("Invalid SameSite attribute")
+	ErrUnknownSessionStore       = errors.New("Unknown session store")
+	ErrInvalidToken              = errors.New("Invalid token")
+	ErrInvalidCSRFToken          = errors.New("Invalid CSRF token")
+	ErrTooManyLoginAttempts      = errors.New("Too many login attempts")
+	ErrUserBlocked               = errors.New("User is blocked")
+	ErrTooManyRequests           = errors.New("Too many requests")
+	ErrInvalidRateLimit          = errors.New("Invalid rate limit")
+	ErrLongTableClosed           = errors.New("Long table is closed")
+	ErrBookingInPast             = errors.New("Booking date is in the past")
+	ErrBookingAfterClosingTime   = errors.New("Long table has closed for the day")
+	ErrBookingTooFarAhead        = errors.New("Booking date is too far ahead")
+	ErrInvalidSitting            = errors.New("Invalid sitting")
+	ErrUnknownSitting            = errors.New("Unknown sitting")
+	ErrAlreadyWaitlisted         = errors.New("User is already on the waitlist")
+	ErrSeatsAvailable            = errors.New("Long table still has available seats")
+	ErrNoWaitlistOffer           = errors.New("Booking is not a waitlist offer")
+	ErrWaitlistOfferExpired      = errors.New("Waitlist offer has expired")
+	ErrUnknownSeatStrategy       = errors.New("Unknown seat strategy")
+	ErrInvalidPartySize          = errors.New("Party size must be at least one and at most the number of seats")
+	ErrUnknownTimezone           = errors.New("Unknown timezone")
+	ErrNil                       = errors.New("Nil reply")
 )
 
 // Constants
 const (
 	DateFormat = "02-01-2006"
 	TimeFormat = "15:04"
+
+	// Largest media upload, in bytes
+	maxMediaSize = 20 << 20
+
+	// How long email verification codes can be used
+	verificationCodeTTL = 24 * time.Hour
+
+	// How long password reset tokens can be used
+	passwordResetTokenTTL = time.Hour
+
+	// How long logged in sessions last without being used
+	userSessionIdleTimeout = 30 * 24 * time.Hour
+
+	// How often the last seen time of logged in sessions is updated
+	userSessionSeenInterval = time.Minute
+
+	// How long API access tokens can be used before they have to be refreshed
+	accessTokenTTL = time.Hour
+
+	// Failed logins within loginFailureWindow that lock out an email or a client IP
+	maxEmailLoginFailures = 5
+	maxIPLoginFailures    = 20
+	loginFailureWindow    = 15 * time.Minute
+
+	// The first lockout of an email or a client IP, which doubles every time up to maxLoginLockout
+	loginLockoutBase = time.Minute
+	maxLoginLockout  = 24 * time.Hour
+
+	// How often waitlist offers that weren't claimed in time are passed on
+	waitlistSweepInterval = time.Minute
 )
 
+// Server holds the dependencies of the HTTP handlers
+type Server struct {
+	store  Store
+	mailer Mailer
+
+	// Timezone of the property, and how many days ahead it can be booked
+	location       *time.Location
+	bookingHorizon int
+
+	// How long Users have to claim seats offered from waitlists, and how they're told
+	claimWindow time.Duration
+	notifier    WaitlistNotifier
+}
+
+// Get the current time in the timezone of the property
+func (s *Server) now() time.Time {
+	if s.location == nil {
+		return time.Now()
+	}
+	return time.Now().In(s.location)
+}
+
 func main() {
-	var err error
+	// Parse command-line flags
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\n"+commandUsage)
+	}
+	flag.Parse()
+
+	// Connect to database
+	s := &Server{
+		store: newRedisStore(*dbhost+":"+*dbport, RedisStoreOptions{
+			MaxIdle:     *dbmaxidle,
+			MaxActive:   *dbmaxactive,
+			IdleTimeout: *dbidletimeout,
+			Timeout:     *dbtimeout,
+		}),
+		mailer:         LogMailer{},
+		bookingHorizon: *bookinghorizon,
+		claimWindow:    *waitlistclaimwindow,
+	}
+
+	// Find the timezone of the property
+	if location, err := time.LoadLocation(*timezone); err != nil {
+		log.Fatal(ErrUnknownTimezone, ": ", *timezone)
+	} else {
+		s.location = location
+	}
+
+	// Write emails to a file instead of the log
+	if *mailfile != "" {
+		s.mailer = newFileMailer(*mailfile)
+	}
+	s.notifier = MailWaitlistNotifier{Mailer: s.mailer}
+
+	// Run a command against the database instead of the server
+	if flag.NArg() > 0 {
+		err := runCommand(s.store, os.Stdout, flag.Args())
+		s.store.Close()
+		if err == ErrUnknownCommand {
+			flag.Usage()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Handle OS signals
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, os.Kill)
 	go func() {
 		sig := <-c
-		if db != nil {
-			db.Close()
-		}
+		s.store.Close()
 		log.Println("Received signal:", sig)
 		os.Exit(0)
 	}()
 
-	// Parse command-line flags
-	flag.Parse()
+	// Room bookings from before rooms listed them need to be listed, or they'd outlive their rooms
+	if err := indexRoomBookingsByRoom(s.store); err != nil {
+		log.Fatal(err)
+	}
 
-	// Connect to database
-	if db, err = redis.Dial("tcp", *dbhost+":"+*dbport); err != nil {
+	// Bookings from before seats were claimed need their claims, or their seats could be booked again
+	longTables, err := _getLongTables(s.store, "longTables", 0, -1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range longTables {
+		if claimed, err := longTables[i].claimLegacySeats(s.store); err != nil {
+			log.Fatal(err)
+		} else if claimed > 0 {
+			log.Println("Claimed", claimed, "seats of bookings from before seat claims at long table", longTables[i].ID)
+		}
+	}
+
+	// Setup sessions
+	if strings.TrimSpace(*sessionkeys) == "" {
+		log.Println("No session keys are configured, so sessions won't survive restarts")
+		*sessionkeys = base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	sameSite, err := parseSameSite(*cookiesamesite)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessionOptions := SessionOptions{
+		Keys:     strings.Split(*sessionkeys, ","),
+		Domain:   *cookiedomain,
+		Secure:   *cookiesecure,
+		HttpOnly: *cookiehttponly,
+		SameSite: sameSite,
+		MaxAge:   *cookiemaxage,
+	}
+	switch *sessionstore {
+	case "cookie":
+	case "redis":
+		sessionOptions.Store = s.store
+	default:
+		log.Fatal(ErrUnknownSessionStore)
+	}
+	if ss, err = newSessionStore(sessionOptions); err != nil {
 		log.Fatal(err)
 	}
 
-	// Setup social logins
-	gothic.Store = sessions.NewFilesystemStore(os.TempDir(), []byte("coo"))
+	// Setup social logins. Their session has to be sent along when the provider
+	// redirects back, which strict cookies aren't.
+	gothicOptions := sessionOptions
+	if gothicOptions.SameSite == http.SameSiteStrictMode {
+		gothicOptions.SameSite = http.SameSiteLaxMode
+	}
+	if gothic.Store, err = newSessionStore(gothicOptions); err != nil {
+		log.Fatal(err)
+	}
 	goth.UseProviders(
 		facebook.New(os.Getenv("FACEBOOK_KEY"), os.Getenv("FACEBOOK_SECRET"), *address+"/auth/facebook/callback"),
 		instagram.New(os.Getenv("INSTAGRAM_KEY"), os.Getenv("INSTAGRAM_SECRET"), *address+"/auth/instagram/callback"),
@@ -101,26 +299,61 @@ func main() {
 	// Prepare web server
 	router := mux.NewRouter()
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.HandleFunc("/login", loginHandler)
-	apiRouter.HandleFunc("/signup", signupHandler)
-	apiRouter.HandleFunc("/logout", logoutHandler)
-	apiRouter.HandleFunc("/user", userHandler)
-	apiRouter.HandleFunc("/user/connection", userConnectionHandler)
-	apiRouter.HandleFunc("/user/longTableBookings", userLongTableBookingsHandler)
-	apiRouter.HandleFunc("/user/similarUsers", userSimilarUsersHandler)
-	apiRouter.HandleFunc("/users", usersHandler)
-	apiRouter.HandleFunc("/longtable", longTableHandler)
-	apiRouter.HandleFunc("/longtable/booking", longTableBookingHandler)
-	apiRouter.HandleFunc("/longtable/availableSeats", longTableAvailableSeatsHandler)
-	apiRouter.HandleFunc("/longtables", longTablesHandler)
+	apiRouter.Use(s.csrfMiddleware)
+	apiRouter.HandleFunc("/csrf", s.csrfHandler)
+	apiRouter.HandleFunc("/login", s.loginHandler)
+	apiRouter.HandleFunc("/signup", s.signupHandler)
+	apiRouter.HandleFunc("/logout", s.logoutHandler)
+	apiRouter.HandleFunc("/token", s.tokenHandler)
+	apiRouter.HandleFunc("/token/refresh", s.tokenRefreshHandler)
+	apiRouter.HandleFunc("/verify", s.verifyHandler)
+	apiRouter.HandleFunc("/verify/resend", s.verifyResendHandler)
+	apiRouter.HandleFunc("/password/forgot", s.passwordForgotHandler)
+	apiRouter.HandleFunc("/password/reset", s.passwordResetHandler)
+	apiRouter.HandleFunc("/user", s.userHandler)
+	apiRouter.HandleFunc("/user/connection", s.userConnectionHandler)
+	apiRouter.HandleFunc("/user/longTableBookings", s.userLongTableBookingsHandler)
+	apiRouter.HandleFunc("/user/similarUsers", s.userSimilarUsersHandler)
+	apiRouter.HandleFunc("/users", s.usersHandler)
+	apiRouter.HandleFunc("/longtable", s.requireRole(RoleAdmin, s.longTableHandler)).Methods("POST", "PATCH", "DELETE")
+	apiRouter.HandleFunc("/longtable", s.longTableHandler)
+	apiRouter.HandleFunc("/longtable/booking", s.longTableBookingHandler)
+	apiRouter.HandleFunc("/longtable/availableSeats", s.longTableAvailableSeatsHandler)
+	apiRouter.HandleFunc("/longtable/capacity", s.requireRole(RoleAdmin, s.longTableCapacityHandler)).Methods("POST", "DELETE")
+	apiRouter.HandleFunc("/longtable/capacity", s.longTableCapacityHandler)
+	apiRouter.HandleFunc("/longtable/closure", s.requireRole(RoleAdmin, s.longTableClosureHandler)).Methods("POST", "DELETE")
+	apiRouter.HandleFunc("/longtable/closure", s.longTableClosureHandler)
+	apiRouter.HandleFunc("/longtable/sitting", s.requireRole(RoleAdmin, s.longTableSittingHandler)).Methods("POST", "DELETE")
+	apiRouter.HandleFunc("/longtable/sitting", s.longTableSittingHandler)
+	apiRouter.HandleFunc("/longtable/waitlist", s.longTableWaitlistHandler)
+	apiRouter.HandleFunc("/longtable/waitlist/claim", s.longTableWaitlistClaimHandler)
+	apiRouter.HandleFunc("/longtables", s.longTablesHandler)
+	apiRouter.HandleFunc("/room", s.requireRole(RoleStaff, s.roomHandler)).Methods("POST", "PATCH", "DELETE")
+	apiRouter.HandleFunc("/room", s.roomHandler)
+	apiRouter.HandleFunc("/rooms", s.roomsHandler)
+	apiRouter.HandleFunc("/rooms/available", s.roomsAvailableHandler)
+	apiRouter.HandleFunc("/roombooking", s.roomBookingHandler)
+	apiRouter.HandleFunc("/post", s.requireRole(RoleAdmin, s.postHandler)).Methods("POST", "PATCH", "DELETE")
+	apiRouter.HandleFunc("/post", s.postHandler)
+	apiRouter.HandleFunc("/posts", s.postsHandler)
+	apiRouter.HandleFunc("/media", s.requireRole(RoleAdmin, s.mediaHandler)).Methods("POST", "PATCH", "DELETE")
+	apiRouter.HandleFunc("/media", s.mediaHandler)
+	apiRouter.HandleFunc("/media/collection", s.mediaCollectionHandler)
+	router.HandleFunc("/media/{id:[0-9]+}", s.mediaFileHandler)
+	apiRouter.HandleFunc("/user/roomBookings", s.userRoomBookingsHandler)
+	apiRouter.HandleFunc("/user/session", s.userSessionHandler)
+	apiRouter.HandleFunc("/user/sessions", s.userSessionsHandler)
+	apiRouter.HandleFunc("/admin/user/role", s.requireRole(RoleAdmin, s.adminUserRoleHandler))
+	apiRouter.HandleFunc("/admin/user/block", s.requireRole(RoleAdmin, s.adminUserBlockHandler))
+	apiRouter.HandleFunc("/admin/user/unlock", s.requireRole(RoleAdmin, s.adminUserUnlockHandler))
 
 	// Extra
-	apiRouter.HandleFunc("/longtable/booking/delete", longTableBookingDeleteHandlerFunc)
-	apiRouter.HandleFunc("/user/connection/delete", userConnectionDeleteHandlerFunc)
+	apiRouter.HandleFunc("/longtable/booking/delete", s.longTableBookingDeleteHandlerFunc)
+	apiRouter.HandleFunc("/user/connection/delete", s.userConnectionDeleteHandlerFunc)
 
 	// Prepare social login authenticators
 	patHandler := pat.New()
-	patHandler.Get("/auth/{provider}/callback", authHandler)
+	patHandler.Get("/auth/{provider}/callback", s.authHandler)
 	patHandler.Get("/auth/{provider}", gothic.BeginAuthHandler)
 	router.PathPrefix("/auth").Handler(patHandler)
 
@@ -128,7 +361,7 @@ func main() {
 	if *serveTest {
 		funcMap := template.FuncMap{
 			"longtables": func(count int) []LongTable {
-				if longTables, err := getLongTables(map[string]interface{}{"count": count}); err != nil {
+				if longTables, err := getLongTables(s.store, map[string]interface{}{"count": count}); err != nil {
 					return nil
 				} else {
 					return longTables
@@ -137,9 +370,13 @@ func main() {
 			"minus": func(a, b int) int {
 				return a - b
 			},
+			// Replaced by the token of the request in render
+			"csrfToken": func() string {
+				return ""
+			},
 		}
 		templates = template.Must(template.New("main").Funcs(funcMap).ParseGlob("test/*.html"))
-		setupTemplateHandlers(router)
+		s.setupTemplateHandlers(router)
 	}
 
 	// Run web server
@@ -149,24 +386,70 @@ func main() {
 	} else {
 		n = negroni.Classic()
 	}
+	limits, err := parseRateLimits(*ratelimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.Use(newRateLimiter(s, limits))
 	n.UseHandler(router)
+
+	go s.sweepWaitlists(waitlistSweepInterval)
+
 	n.Run(":" + *port)
 }
 
-func setupTemplateHandlers(router *mux.Router) {
+// Render a template for a request, with the CSRF token that its forms need
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	token, err := csrfToken(w, r)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	t, err := templates.Clone()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	t.Funcs(template.FuncMap{"csrfToken": func() string { return token }})
+
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) setupTemplateHandlers(router *mux.Router) {
 	// Index
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, _ := loggedIn(w, r, false); loggedIn {
+		if loggedIn, _ := s.loggedIn(w, r, false); loggedIn {
 			http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
 		} else {
-			templates.ExecuteTemplate(w, "index", nil)
+			s.render(w, r, "index", nil)
 		}
 	})
 
 	// Dashboard
 	router.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, user := loggedIn(w, r, true); loggedIn {
-			templates.ExecuteTemplate(w, "dashboard", user)
+		if loggedIn, user := s.loggedIn(w, r, true); loggedIn {
+			longTableBookings, err := user.longTableBookings(s.store)
+			if err != nil {
+				log.Println(err)
+			}
+			similarUsers, err := user.similarUsers(s.store)
+			if err != nil {
+				log.Println(err)
+			}
+			similarUserViews, err := userViews(s.store, similarUsers, user)
+			if err != nil {
+				log.Println(err)
+			}
+			s.render(w, r, "dashboard", map[string]interface{}{
+				"user":              user,
+				"longTableBookings": longTableBookings,
+				"similarUsers":      similarUserViews,
+			})
 		} else {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		}
@@ -174,8 +457,8 @@ func setupTemplateHandlers(router *mux.Router) {
 
 	// Profile
 	router.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, user := loggedIn(w, r, true); loggedIn {
-			templates.ExecuteTemplate(w, "profile", user)
+		if loggedIn, user := s.loggedIn(w, r, true); loggedIn {
+			s.render(w, r, "profile", user)
 		} else {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		}
@@ -183,18 +466,26 @@ func setupTemplateHandlers(router *mux.Router) {
 
 	// Profile (with ID)
 	router.HandleFunc("/profile/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, user := loggedIn(w, r, true); loggedIn {
+		if loggedIn, user := s.loggedIn(w, r, true); loggedIn {
 			vars := mux.Vars(r)
 			if otherUserID, err := strconv.Atoi(vars["id"]); err != nil {
-				templates.ExecuteTemplate(w, "profile", user)
-			} else if user["id"].(int) == otherUserID {
-				templates.ExecuteTemplate(w, "profile", user)
+				s.render(w, r, "profile", user)
+			} else if user.ID == otherUserID {
+				s.render(w, r, "profile", user)
 			} else {
-				otherUser := User{"id": otherUserID}
-				if otherUser, err := otherUser.fetch(); err != nil {
+				otherUser := &User{ID: otherUserID}
+				if err := otherUser.fetch(s.store); err != nil {
 					http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 				} else {
-					templates.ExecuteTemplate(w, "profile", map[string]interface{}{"user": user, "otherUser": otherUser})
+					visibility, err := otherUser.visibilityTo(s.store, user)
+					if err != nil {
+						log.Println(err)
+					}
+					s.render(w, r, "profile", map[string]interface{}{
+						"user":      user,
+						"otherUser": otherUser.view(visibility),
+						"connected": visibility == VisibilityConnected,
+					})
 				}
 			}
 		} else {
@@ -204,8 +495,8 @@ func setupTemplateHandlers(router *mux.Router) {
 
 	// LongTables
 	router.HandleFunc("/longtables", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, user := loggedIn(w, r, true); loggedIn {
-			templates.ExecuteTemplate(w, "longtables", user)
+		if loggedIn, user := s.loggedIn(w, r, true); loggedIn {
+			s.render(w, r, "longtables", user)
 		} else {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		}
@@ -213,14 +504,16 @@ func setupTemplateHandlers(router *mux.Router) {
 
 	// LongTable
 	router.HandleFunc("/longtable/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
-		if loggedIn, user := loggedIn(w, r, true); loggedIn {
+		if loggedIn, user := s.loggedIn(w, r, true); loggedIn {
 			vars := mux.Vars(r)
 			id, _ := strconv.Atoi(vars["id"])
-			longTable := LongTable{"id": id}
-			if longTable, err := longTable.fetch(); err != nil {
+			longTable := &LongTable{ID: id}
+			if err := longTable.fetch(s.store); err != nil {
 				w.WriteHeader(http.StatusNotFound)
+			} else if sittings, err := longTable.sittings(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 			} else {
-				templates.ExecuteTemplate(w, "longtable", map[string]interface{}{"user": user, "longtable": longTable})
+				s.render(w, r, "longtable", map[string]interface{}{"user": user, "longtable": longTable, "sittings": sittings})
 			}
 		} else {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -228,7 +521,7 @@ func setupTemplateHandlers(router *mux.Router) {
 	})
 }
 
-func authHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) authHandler(w http.ResponseWriter, r *http.Request) {
 	authuser, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
 		log.Println(err)
@@ -236,68 +529,97 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check if the provider identified the user
+	if authuser.Provider == "" || authuser.UserID == "" {
+		http.Error(w, ErrMissingKey.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Check if User is logged in
-	if loggedIn, _ := loggedIn(w, r, true); loggedIn {
-		switch authuser.Provider {
-		case "facebook":
-			//
-		case "instagram":
-			//
-		case "twitter":
-			//
-		default:
-			w.WriteHeader(http.StatusBadRequest)
+	// If so, link the provider to her account
+	if loggedIn, user := s.loggedIn(w, r, false); loggedIn {
+		if err := user.linkProvider(s.store, authuser.Provider, authuser.UserID); err != nil {
+			if err == ErrProviderAlreadyLinked {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
 			return
 		}
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	user := User{}
-	switch authuser.Provider {
-	case "facebook":
-		//
-	case "instagram":
-		//
-	case "twitter":
-		//
-	default:
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Check if User already exists
+	// Check if the provider is linked to a User
 	// If so, log her in
-	if exists, user := user.exists(true); exists {
-		if err := logIn(w, r, user); err != nil {
+	if user, err := fetchUserByProvider(s.store, authuser.Provider, authuser.UserID); err == nil {
+		if user.Blocked {
+			http.Error(w, ErrUserBlocked.Error(), http.StatusForbidden)
+			return
+		}
+		if err := s.logIn(w, r, user); err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
+	} else if err != ErrEntityNotFound {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	name := strings.Split(authuser.Name, " ")
-	if len(name) > 1 {
-		user["firstname"] = strings.Join(name[:len(name)-1], " ")
-		user["lastname"] = name[len(name)-1]
-	} else {
-		user["firstname"] = name[0]
+	// Don't take over an account with the same email, which has to log in and
+	// link the provider instead
+	if authuser.Email != "" {
+		if exists := (&User{Email: authuser.Email}).exists(s.store, true); exists {
+			http.Error(w, ErrEmailAlreadyRegistered.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Sign the visitor up
+	user := &User{
+		Firstname:   authuser.FirstName,
+		Lastname:    authuser.LastName,
+		Nickname:    authuser.NickName,
+		Description: authuser.Description,
+		Email:       authuser.Email,
+		ImageURL:    authuser.AvatarURL,
+		Verified:    authuser.Email != "",
+	}
+	if user.Firstname == "" && user.Lastname == "" {
+		name := strings.Split(authuser.Name, " ")
+		if len(name) > 1 {
+			user.Firstname = strings.Join(name[:len(name)-1], " ")
+			user.Lastname = name[len(name)-1]
+		} else {
+			user.Firstname = name[0]
+		}
 	}
-	user["description"] = authuser.Description
-	user["email"] = authuser.Email
-	user["imageURL"] = authuser.AvatarURL
 
 	// Insert User
-	if user["id"], err = user.insert(); err != nil {
+	if _, err = user.insert(s.store); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Link the provider to the new User, which is removed again if another
+	// request linked the provider first
+	if err := user.linkProvider(s.store, authuser.Provider, authuser.UserID); err != nil {
 		log.Println(err)
+		if err := user.delete(s.store); err != nil {
+			log.Println(err)
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	// Log User in
-	if err := logIn(w, r, user); err != nil {
+	if err := s.logIn(w, r, user); err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -306,17 +628,17 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Check if User is logged in
-		if ok, user := loggedIn(w, r, true); !ok {
+		if ok, user := s.loggedIn(w, r, true); !ok {
 			w.WriteHeader(http.StatusForbidden)
 		} else {
 			if *serveTest {
 				http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
 			} else {
-				if data, err := json.Marshal(user); err != nil {
+				if data, err := json.Marshal(user.view(VisibilitySelf)); err != nil {
 					log.Println(err)
 					w.WriteHeader(http.StatusInternalServerError)
 				} else {
@@ -343,33 +665,92 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		user := User{"email": email}
-
-		// Check if User exists
-		if exists, user := user.exists(true); exists {
-			if err := bcrypt.CompareHashAndPassword([]byte(user["password"].(string)), []byte(password)); err != nil {
-				w.WriteHeader(http.StatusForbidden)
+		// Check if User exists and the password matches
+		if user, retryAfter, err := s.checkPassword(r, email, password); err != nil {
+			loginError(w, err, retryAfter)
+		} else {
+			if err := s.logIn(w, r, user); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
 			} else {
-				if err := logIn(w, r, user); err != nil {
-					log.Println(err)
-					w.WriteHeader(http.StatusInternalServerError)
+				if *serveTest {
+					http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
 				} else {
-					if *serveTest {
-						http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
-					} else {
-						w.WriteHeader(http.StatusOK)
-					}
+					w.WriteHeader(http.StatusOK)
 				}
 			}
-		} else {
-			w.WriteHeader(http.StatusForbidden)
 		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func signupHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		// Check if User exists and the password matches
+		user, retryAfter, err := s.checkPassword(r, r.FormValue("email"), r.FormValue("password"))
+		if err != nil {
+			loginError(w, err, retryAfter)
+			return
+		}
+
+		// Start a session for the client, which is listed and revoked like
+		// the sessions of cookies
+		userSession, err := s.startSession(r, user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tokens, err := userSession.createTokens(s.store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(tokens)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) tokenRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		// Swap the refresh token for new tokens
+		userSession, tokens, err := refreshTokens(s.store, r.FormValue("refreshToken"))
+		if err == ErrInvalidToken {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := userSession.touch(s.store); err != nil {
+			log.Println(err)
+		}
+
+		data, err := json.Marshal(tokens)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		email := r.FormValue("email")
@@ -442,31 +823,36 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Initialize User data
-		user := User{
-			"firstname": firstname,
-			"lastname":  lastname,
-			"nickname":  nickname,
-			"email":     email,
-			"password":  string(hashedPassword),
-			"imageURL":  imageURL,
-			"birthdate": birthdate,
-			"gender":    gender,
+		user := &User{
+			Firstname: firstname,
+			Lastname:  lastname,
+			Nickname:  nickname,
+			Email:     email,
+			Password:  string(hashedPassword),
+			ImageURL:  imageURL,
+			Birthdate: birthdate,
+			Gender:    gender,
 		}
 
 		// Set User interests if exist
 		if interests, ok := r.Form["interests"]; ok {
-			user["interests"] = interests
+			user.Interests = interests
 		}
 
 		// Insert User
-		if user["id"], err = user.insert(); err != nil {
+		if _, err = user.insert(s.store); err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		// Send the verification code, which can be sent again if it's lost
+		if err = s.sendVerificationCode(user); err != nil {
+			log.Println(err)
+		}
+
 		// Log User in
-		if err = logIn(w, r, user); err != nil {
+		if err = s.logIn(w, r, user); err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -482,9 +868,9 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Log User out
-	if err := logOut(w, r); err != nil {
+	if err := s.logOut(w, r); err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -496,38 +882,41 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func userHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) userHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		fallthrough
 	case "PATCH":
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		}
 
 		birthdate := r.FormValue("birthdate")
+		oldEmail := user.Email
 
 		// Set User info
-		s := Setter{}
+		setter := Setter{}
 		if birthdate != "" {
-			s.setDate(user, "birthdate", birthdate)
-		}
-		s.set(user, "firstname", r.FormValue("firstname"))
-		s.set(user, "lastname", r.FormValue("lastname"))
-		s.set(user, "nickname", r.FormValue("nickname"))
-		s.set(user, "email", r.FormValue("email"))
-		s.set(user, "gender", r.FormValue("gender"))
-		s.set(user, "travellingAs", r.FormValue("travellingAs"))
-		s.set(user, "wechatNumber", r.FormValue("wechatNumber"))
-		s.set(user, "lineNumber", r.FormValue("lineNumber"))
-		s.set(user, "facebookNumber", r.FormValue("facebookNumber"))
-		s.set(user, "skypeNumber", r.FormValue("skypeNumber"))
-		s.set(user, "whatsappNumber", r.FormValue("whatsappNumber"))
-		if s.err != nil {
-			http.Error(w, s.err.Error(), http.StatusBadRequest)
+			setter.setDate(&user.Birthdate, birthdate)
+		}
+		setter.set(&user.Firstname, r.FormValue("firstname"))
+		setter.set(&user.Lastname, r.FormValue("lastname"))
+		setter.set(&user.Nickname, r.FormValue("nickname"))
+		if email := r.FormValue("email"); email != "" {
+			setter.set(&user.Email, email)
+		}
+		setter.set(&user.Gender, r.FormValue("gender"))
+		setter.set(&user.TravellingAs, r.FormValue("travellingAs"))
+		setter.set(&user.WechatNumber, r.FormValue("wechatNumber"))
+		setter.set(&user.LineNumber, r.FormValue("lineNumber"))
+		setter.set(&user.FacebookNumber, r.FormValue("facebookNumber"))
+		setter.set(&user.SkypeNumber, r.FormValue("skypeNumber"))
+		setter.set(&user.WhatsappNumber, r.FormValue("whatsappNumber"))
+		if setter.err != nil {
+			http.Error(w, setter.err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -537,7 +926,7 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 		// Process valid input (both passwords are at least the minimum length)
 		if len(oldPassword) >= 8 && len(newPassword) >= 8 {
 			// Check if old password matches
-			if err := bcrypt.CompareHashAndPassword([]byte(user["password"].(string)), []byte(oldPassword)); err != nil {
+			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPassword)); err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
@@ -549,7 +938,7 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			user["password"] = string(hashedPassword)
+			user.Password = string(hashedPassword)
 
 			// Invalid input (at least one of the password is less than minimum length
 		} else if len(oldPassword) > 0 && len(newPassword) > 0 {
@@ -565,31 +954,45 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			} else if destination != "" {
 				// Check if User previously has an image, if so remove it
-				if imageURL, ok := user["imageURL"]; ok {
-					if imageURL, ok := imageURL.(string); ok && imageURL != "" {
-						if err := os.Remove(imageURL); err != nil {
-							log.Println(err)
-						}
+				if user.ImageURL != "" {
+					if err := os.Remove(user.ImageURL); err != nil {
+						log.Println(err)
 					}
-
-					// Successfully copied so set the destination path as the image URL
-					user["imageURL"] = destination
 				}
+
+				// Successfully copied so set the destination path as the image URL
+				user.ImageURL = destination
 			}
 		}
 
 		// Set User interests if exist
 		if interests, ok := r.Form["interests"]; ok {
-			user["interests"] = interests
+			user.Interests = interests
+		}
+
+		// A new email has to be verified again, but leaving it out keeps the old one
+		emailChanged := user.Email != oldEmail
+		if emailChanged {
+			if err := user.setVerified(s.store, false); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
 
 		// Update User
-		if err := user.update(); err != nil {
+		if err := user.update(s.store); err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		if emailChanged {
+			if err := s.sendVerificationCode(user); err != nil {
+				log.Println(err)
+			}
+		}
+
 		if *serveTest {
 			http.Redirect(w, r, "/profile", http.StatusTemporaryRedirect)
 		} else {
@@ -597,14 +1000,14 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	case "DELETE":
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		}
 
 		// Delete User
-		if err := user.delete(); err != nil {
+		if err := user.delete(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -615,11 +1018,11 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func userConnectionHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) userConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
@@ -633,15 +1036,15 @@ func userConnectionHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		} else {
-			user := User{"id": otherUserID}
-			if _, err := user.exists(false); err != nil {
+			otherUser := &User{ID: otherUserID}
+			if ok := otherUser.exists(s.store, false); !ok {
 				http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
 				return
 			}
 		}
 
-		// Add the other User as new connection of current User
-		if err = user.addUser(User{"id": otherUserID}); err != nil {
+		// Connect current User to the other User, which accepts its request if it made one
+		if err = user.addUser(s.store, &User{ID: otherUserID}); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -653,29 +1056,29 @@ func userConnectionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "DELETE":
-		userConnectionDeleteHandlerFunc(w, r)
+		s.userConnectionDeleteHandlerFunc(w, r)
 
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func userLongTableBookingsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) userLongTableBookingsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		}
 
 		// Get Users that match the parameters
-		if longTableBookings, err := user.longTableBookings(); err != nil {
+		if longTableBookings, err := user.longTableBookings(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
-			data, err := json.Marshal(longTableBookings)
+			data, err := json.Marshal(longTableBookingViews(longTableBookings))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -688,22 +1091,25 @@ func userLongTableBookingsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func userSimilarUsersHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) userSimilarUsersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		}
 
 		// Get similar Users
-		if users, err := user.SimilarUsers(); err != nil {
+		if users, err := user.similarUsers(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if views, err := userViews(s.store, users, user); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
-			data, err := json.Marshal(users)
+			data, err := json.Marshal(views)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -715,7 +1121,7 @@ func userSimilarUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func usersHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) usersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		var count int
@@ -734,12 +1140,18 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 			params["interests"] = interests
 		}
 
+		// Guests only see the public view of Users
+		_, viewer := s.loggedIn(w, r, false)
+
 		// Get Users that match the parameters
-		if users, err := fetchUsers(params); err != nil {
+		if users, err := fetchUsers(s.store, params); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if views, err := userViews(s.store, users, viewer); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
-			data, err := json.Marshal(users)
+			data, err := json.Marshal(views)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -751,25 +1163,25 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func longTableHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) longTableHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		longTable := LongTable{}
+		longTable := &LongTable{}
 
 		// Check if 'id' query parameter is valid
 		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
 			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["id"] = id
+			longTable.ID = id
 		}
 
 		// Get LongTable with set 'id'
-		if _, err := longTable.fetch(); err != nil {
+		if err := longTable.fetch(s.store); err != nil {
 			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
 			return
 		} else {
-			data, err := json.Marshal(longTable)
+			data, err := json.Marshal(longTable.view())
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -778,18 +1190,8 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "POST":
-		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
-		if !loggedIn {
-			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
-			return
-		}
-
-		// Check privilege
-		if privilege, ok := user["privilege"]; !ok || privilege != "admin" {
-			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
-			return
-		}
+		// Get the User that requireRole let through
+		user := requestUser(r)
 
 		name := r.FormValue("name")
 
@@ -800,14 +1202,14 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Initialize LongTable
-		longTable := LongTable{"userID": user["id"], "name": name}
+		longTable := &LongTable{UserID: user.ID, Name: name}
 
 		// Check if 'numSeats' query parameter is valid
 		if numSeats, err := strconv.Atoi(r.FormValue("numSeats")); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["numSeats"] = numSeats
+			longTable.NumSeats = numSeats
 		}
 
 		// Check if 'openingTime' query parameter is valid
@@ -816,7 +1218,7 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["openingTime"] = openingTime
+			longTable.OpeningTime = openingTime
 		}
 
 		// Check if 'closingTime' query parameter is valid
@@ -825,11 +1227,11 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["closingTime"] = closingTime
+			longTable.ClosingTime = closingTime
 		}
 
 		// Insert LongTable
-		if longTableID, err := longTable.insert(); err != nil {
+		if longTableID, err := longTable.insert(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
@@ -841,26 +1243,17 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "PATCH":
-		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
-		if !loggedIn {
-			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
-			return
-		}
-
-		// Check privilege
-		if privilege, ok := user["admin"]; !ok || privilege != "admin" {
-			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
-			return
-		}
-
-		// Initialize LongTable
-		longTable := LongTable{"userID": user["id"]}
+		// Get LongTable with set 'id'
+		longTable := &LongTable{}
 		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
 			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["id"] = id
+			longTable.ID = id
+		}
+		if err := longTable.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		name := r.FormValue("name")
@@ -870,7 +1263,7 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["name"] = name
+			longTable.Name = name
 		}
 
 		// Check if 'numSeats' query parameter is valid
@@ -878,7 +1271,7 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["numSeats"] = numSeats
+			longTable.NumSeats = numSeats
 		}
 
 		// Check if 'openingTime' query parameter is valid
@@ -887,7 +1280,7 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["openingTime"] = openingTime
+			longTable.OpeningTime = openingTime
 		}
 
 		// Check if 'closingTime' query parameter is valid
@@ -896,42 +1289,29 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["closingTime"] = closingTime
+			longTable.ClosingTime = closingTime
 		}
 
 		// Update LongTable
-		if err := longTable.update(); err != nil {
+		if err := longTable.update(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			w.Write([]byte(strconv.Itoa(longTable["id"].(int))))
+			w.Write([]byte(strconv.Itoa(longTable.ID)))
 		}
 
 	case "DELETE":
-		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
-		if !loggedIn {
-			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
-			return
-		}
-
-		// Check privilege
-		if privilege, ok := user["admin"]; !ok || privilege != "admin" {
-			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
-			return
-		}
-
-		longTable := LongTable{}
+		longTable := &LongTable{}
 
 		// Check LongTable ID
 		if longTableID, err := strconv.Atoi(r.FormValue("id")); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
-			longTable["id"] = longTableID
+			longTable.ID = longTableID
 		}
 
-		if err := longTable.delete(); err != nil {
+		if err := longTable.delete(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		} else {
@@ -944,22 +1324,30 @@ func longTableHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func longTableBookingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) longTableBookingHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		var longTableID, seatPosition int
 		var date string
+		var strategy SeatStrategy
+		var longTable *LongTable
 		var err error
 
 		// Check if User is logged in
-		loggedIn, user := loggedIn(w, r, true)
+		loggedIn, user := s.loggedIn(w, r, true)
 		if !loggedIn {
 			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 			return
 		}
 
+		// Check if User has verified their email
+		if !user.Verified {
+			http.Error(w, ErrNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+
 		// Initialize LongTableBooking
-		longTableBooking := LongTableBooking{"userID": user["id"]}
+		longTableBooking := &LongTableBooking{UserID: user.ID}
 
 		// Check if 'longTableID' query parameter is valid
 		if longTableID, err = strconv.Atoi(r.FormValue("longTableID")); err != nil {
@@ -972,73 +1360,125 @@ func longTableBookingHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			} else {
-				longTableBooking["date"] = date
+				longTableBooking.Date = date
+			}
+
+			// Check if 'seatPosition' query parameter is valid, or else pick a
+			// seat with the 'seatStrategy' query parameter
+			if r.FormValue("seatPosition") == "" {
+				strategyName := r.FormValue("seatStrategy")
+				if strategyName == "" {
+					strategyName = defaultSeatStrategy
+				}
+				if strategy = seatStrategies[strategyName]; strategy == nil {
+					http.Error(w, ErrUnknownSeatStrategy.Error(), http.StatusBadRequest)
+					return
+				}
+			} else if seatPosition, err = strconv.Atoi(r.FormValue("seatPosition")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else {
+				longTableBooking.SeatPosition = seatPosition
+			}
+
+			// Check if 'partySize' query parameter is valid, one guest by default
+			if r.FormValue("partySize") != "" {
+				if longTableBooking.PartySize, err = strconv.Atoi(r.FormValue("partySize")); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				} else if longTableBooking.PartySize < 1 {
+					http.Error(w, ErrInvalidPartySize.Error(), http.StatusBadRequest)
+					return
+				}
 			}
 
-			// Check if user already booked at this date
-			if booked, err := user.bookedLongTable(LongTable{"id": longTableID}, date); err != nil {
+			// Get LongTable with set 'longTableID'
+			longTable = &LongTable{ID: longTableID}
+			if err := longTable.fetch(s.store); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
-			} else if booked {
-				http.Error(w, ErrUserAlreadyBooked.Error(), http.StatusBadRequest)
+			}
+
+			// Check if 'sitting' query parameter is one of the LongTable's sittings
+			sitting, err := longTable.sitting(s.store, r.FormValue("sitting"))
+			if err == ErrUnknownSitting {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			longTableBooking.Sitting = sitting.Name
 
-			// Check if 'seatPosition' query parameter is valid
-			if seatPosition, err = strconv.Atoi(r.FormValue("seatPosition")); err != nil {
+			// Check that the sitting at 'date' hasn't passed and isn't too far ahead
+			if err := sitting.checkBookingTime(date, s.now(), s.bookingHorizon); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
-			} else {
-				longTableBooking["seatPosition"] = seatPosition
 			}
 
-			longTable := LongTable{"id": longTableID}
-			if available, err := longTable.isSeatAvailable(date, seatPosition); err != nil {
+			// Check that the LongTable is open at 'date'
+			if closed, err := longTable.isClosed(s.store, date); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
-			} else if !available {
-				http.Error(w, ErrSeatIsUnavailable.Error(), http.StatusBadRequest)
+			} else if closed {
+				http.Error(w, ErrLongTableClosed.Error(), http.StatusBadRequest)
 				return
 			}
 
-			// Get LongTable with set 'longTableID'
-			if longTable, err := longTable.fetch(); err != nil {
+			// Check that the party's seats are in the LongTable's range at 'date'
+			if numSeats, err := longTable.numSeatsAt(s.store, date); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
-			} else {
-				// Check if seatPosition is equal to or higher than numSeats
-				if numSeats, ok := longTable["numSeats"].(int); !ok {
-					http.Error(w, ErrTypeAssertionFailed.Error(), http.StatusInternalServerError)
-					return
-				} else if seatPosition >= numSeats {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
+			} else if longTableBooking.partySize() > numSeats {
+				http.Error(w, ErrInvalidPartySize.Error(), http.StatusBadRequest)
+				return
+			} else if strategy == nil && (seatPosition < 0 || seatPosition+longTableBooking.partySize() > numSeats) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
-			longTableBooking["longTableID"] = longTableID
+			longTableBooking.LongTableID = longTableID
 		}
 
-		// Insert LongTableBooking
-		if longTableBookingID, err := longTableBooking.insert(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Insert LongTableBooking, which also checks the seat and the User's other bookings
+		var longTableBookingID int
+		if strategy != nil {
+			longTableBookingID, err = longTableBooking.insertAtAnySeat(s.store, longTable, strategy)
 		} else {
-			if *serveTest {
-				http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
-			} else {
-				w.Write([]byte(strconv.Itoa(longTableBookingID)))
+			longTableBookingID, err = longTableBooking.insert(s.store)
+		}
+		if err != nil {
+			switch err {
+			case ErrSeatIsUnavailable, ErrUserAlreadyBooked:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
+			return
+		}
+
+		if *serveTest {
+			http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
+		} else if strategy != nil {
+			// Tell the User which seats they got
+			data, err := json.Marshal(longTableBooking.view())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		} else {
+			w.Write([]byte(strconv.Itoa(longTableBookingID)))
 		}
 
 	case "DELETE":
-		longTableBookingDeleteHandlerFunc(w, r)
+		s.longTableBookingDeleteHandlerFunc(w, r)
 
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func longTableAvailableSeatsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) longTableAvailableSeatsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		var longTableID int
@@ -1056,10 +1496,25 @@ func longTableAvailableSeatsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		longTable := LongTable{"id": longTableID}
+		// Get LongTable with set 'longTableID'
+		longTable := &LongTable{ID: longTableID}
+		if exists := longTable.exists(s.store, true); !exists {
+			http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Check if 'sitting' query parameter is one of the LongTable's sittings
+		sitting, err := longTable.sitting(s.store, r.FormValue("sitting"))
+		if err == ErrUnknownSitting {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		// Get availabe seats on the longtable
-		if seats, err := longTable.fetchAvailableSeats(date); err != nil {
+		if seats, err := longTable.fetchAvailableSeats(s.store, date, sitting.Name); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
@@ -1076,7 +1531,7 @@ func longTableAvailableSeatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func longTablesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) longTablesHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		var count int
@@ -1091,11 +1546,163 @@ func longTablesHandler(w http.ResponseWriter, r *http.Request) {
 		params := map[string]interface{}{"count": count}
 
 		// Get longtables that match the parameters
-		if longTables, err := getLongTables(params); err != nil {
+		if longTables, err := getLongTables(s.store, params); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(longTableViews(longTables))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) longTableCapacityHandler(w http.ResponseWriter, r *http.Request) {
+	// Get LongTable with set 'longTableID'
+	longTable := &LongTable{}
+	if longTableID, err := strconv.Atoi(r.FormValue("longTableID")); err != nil {
+		http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+		return
+	} else {
+		longTable.ID = longTableID
+	}
+	if exists := longTable.exists(s.store, false); !exists {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Get the capacity overrides by date and weekday
+		if capacities, err := longTable.capacities(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(capacities)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST":
+		// Check if 'numSeats' query parameter is valid
+		numSeats, err := strconv.Atoi(r.FormValue("numSeats"))
+		if err != nil {
+			http.Error(w, ErrInvalidCapacity.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Override the capacity at 'day', a date or weekday
+		if err := longTable.setCapacity(s.store, r.FormValue("day"), numSeats); err != nil {
+			switch err {
+			case ErrWrongDateFormat, ErrInvalidCapacity:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		if err := longTable.removeCapacity(s.store, r.FormValue("day")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) longTableClosureHandler(w http.ResponseWriter, r *http.Request) {
+	// Get LongTable with set 'longTableID'
+	longTable := &LongTable{}
+	if longTableID, err := strconv.Atoi(r.FormValue("longTableID")); err != nil {
+		http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+		return
+	} else {
+		longTable.ID = longTableID
+	}
+	if exists := longTable.exists(s.store, false); !exists {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Get the closures with their reasons by date
+		if closures, err := longTable.closures(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(closures)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST":
+		// Close the LongTable at 'date'
+		if err := longTable.close(s.store, r.FormValue("date"), r.FormValue("reason")); err != nil {
+			if err == ErrWrongDateFormat {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		if err := longTable.reopen(s.store, r.FormValue("date")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) longTableSittingHandler(w http.ResponseWriter, r *http.Request) {
+	// Get LongTable with set 'longTableID'
+	longTable := &LongTable{}
+	if longTableID, err := strconv.Atoi(r.FormValue("longTableID")); err != nil {
+		http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+		return
+	} else {
+		longTable.ID = longTableID
+	}
+	if exists := longTable.exists(s.store, false); !exists {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Get the sittings by opening time
+		if sittings, err := longTable.sittings(s.store); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else {
-			data, err := json.Marshal(longTables)
+			data, err := json.Marshal(sittingViews(sittings))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -1103,14 +1710,40 @@ func longTablesHandler(w http.ResponseWriter, r *http.Request) {
 			w.Write(data)
 		}
 
+	case "POST":
+		// Add the sitting, or change its hours
+		sitting := &Sitting{
+			Name:        r.FormValue("name"),
+			OpeningTime: r.FormValue("openingTime"),
+			ClosingTime: r.FormValue("closingTime"),
+		}
+		if err := longTable.setSitting(s.store, sitting); err != nil {
+			if err == ErrInvalidSitting {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		if err := longTable.removeSitting(s.store, r.FormValue("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func longTableBookingDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
+func (s *Server) longTableBookingDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	// Check if User is logged in
-	loggedIn, user := loggedIn(w, r, true)
+	loggedIn, user := s.loggedIn(w, r, true)
 	if !loggedIn {
 		http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 		return
@@ -1131,12 +1764,26 @@ func longTableBookingDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	longTableBooking := LongTableBooking{"id": longTableBookingID, "userID": user["id"], "date": date}
-	if err := longTableBooking.delete(); err != nil {
+	// Check if the LongTableBooking belongs to the User
+	longTableBooking := &LongTableBooking{ID: longTableBookingID}
+	if exists := longTableBooking.exists(s.store, true); !exists {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
+		return
+	} else if longTableBooking.UserID != user.ID || longTableBooking.Date != date {
+		http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := longTableBooking.delete(s.store); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Tell whoever got the seat from the waitlist right away
+	if err := s.processWaitlists(); err != nil {
+		log.Println(err)
+	}
+
 	if *serveTest {
 		http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
 	} else {
@@ -1144,12 +1791,12 @@ func longTableBookingDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func userConnectionDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
+func (s *Server) userConnectionDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	var otherUserID int
 	var err error
 
 	// Check if User is logged in
-	loggedIn, user := loggedIn(w, r, true)
+	loggedIn, user := s.loggedIn(w, r, true)
 	if !loggedIn {
 		http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 		return
@@ -1160,15 +1807,15 @@ func userConnectionDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
 		return
 	} else {
-		user := User{"id": otherUserID}
-		if ok, err := user.exists(false); !ok || err != nil {
+		otherUser := &User{ID: otherUserID}
+		if ok := otherUser.exists(s.store, false); !ok {
 			http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
 	// Remove other User from current User's connection
-	if err = user.removeUser(User{"id": otherUserID}); err != nil {
+	if err = user.removeUser(s.store, &User{ID: otherUserID}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1179,3 +1826,957 @@ func userConnectionDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func (s *Server) roomBookingHandler(w http.ResponseWriter, r *http.Request) {
+	// Check if User is logged in
+	loggedIn, user := s.loggedIn(w, r, true)
+	if !loggedIn {
+		http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if roomBooking, ok := s.fetchOwnRoomBooking(w, r, user); ok {
+			data, err := json.Marshal(roomBooking.view())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST":
+		// Initialize RoomBooking
+		roomBooking := &RoomBooking{
+			UserID:       user.ID,
+			CheckinDate:  r.FormValue("checkinDate"),
+			CheckoutDate: r.FormValue("checkoutDate"),
+		}
+
+		// Check if 'roomID' query parameter is valid
+		if roomID, err := strconv.Atoi(r.FormValue("roomID")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if room := (&Room{ID: roomID}); !room.exists(s.store, false) {
+			http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
+			return
+		} else {
+			roomBooking.RoomID = roomID
+		}
+
+		// Insert RoomBooking, which also checks the dates and the other stays in the Room
+		if roomBookingID, err := roomBooking.insert(s.store); err != nil {
+			http.Error(w, err.Error(), roomBookingStatus(err))
+			return
+		} else {
+			if *serveTest {
+				http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
+			} else {
+				w.Write([]byte(strconv.Itoa(roomBookingID)))
+			}
+		}
+
+	case "PATCH":
+		roomBooking, ok := s.fetchOwnRoomBooking(w, r, user)
+		if !ok {
+			return
+		}
+
+		// Set RoomBooking dates
+		setter := Setter{}
+		if checkinDate := r.FormValue("checkinDate"); checkinDate != "" {
+			setter.setDate(&roomBooking.CheckinDate, checkinDate)
+		}
+		if checkoutDate := r.FormValue("checkoutDate"); checkoutDate != "" {
+			setter.setDate(&roomBooking.CheckoutDate, checkoutDate)
+		}
+		if setter.err != nil {
+			http.Error(w, setter.err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := roomBooking.update(s.store); err != nil {
+			http.Error(w, err.Error(), roomBookingStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		roomBooking, ok := s.fetchOwnRoomBooking(w, r, user)
+		if !ok {
+			return
+		}
+
+		if err := roomBooking.delete(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Fetch the RoomBooking set by the 'id' query parameter if it belongs to the
+// User or the User is staff, otherwise write the error response
+func (s *Server) fetchOwnRoomBooking(w http.ResponseWriter, r *http.Request, user *User) (*RoomBooking, bool) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	roomBooking := &RoomBooking{ID: id}
+	if exists := roomBooking.exists(s.store, true); !exists {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusBadRequest)
+		return nil, false
+	} else if roomBooking.UserID != user.ID && !user.HasRole(RoleStaff) {
+		http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+		return nil, false
+	}
+
+	return roomBooking, true
+}
+
+// Get the status code for an error from inserting or updating a RoomBooking
+func roomBookingStatus(err error) int {
+	switch err {
+	case ErrRoomIsUnavailable, ErrWrongDateFormat, ErrCheckoutBeforeCheckin, ErrStayTooLong:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) userRoomBookingsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, true)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Get the User's stays
+		if roomBookings, err := user.roomBookings(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(roomBookingViews(roomBookings))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) roomHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		room := &Room{}
+
+		// Check if 'id' query parameter is valid
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			room.ID = id
+		}
+
+		// Get Room with set 'id'
+		if err := room.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			data, err := json.Marshal(room.view())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST", "PATCH", "DELETE":
+		// Get the User that requireRole let through
+		user := requestUser(r)
+
+		// Initialize a new Room or get the Room with set 'id'
+		room := &Room{UserID: user.ID}
+		if r.Method != "POST" {
+			if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+				http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+				return
+			} else {
+				room.ID = id
+			}
+			if err := room.fetch(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if r.Method == "DELETE" {
+			// Rooms that guests are staying in or will stay in can't be deleted
+			if upcoming, err := room.hasUpcomingBookings(s.store, s.now()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if upcoming {
+				http.Error(w, ErrRoomHasBookings.Error(), http.StatusConflict)
+				return
+			}
+
+			if err := room.delete(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Check if 'name' and 'type' query parameters are valid
+		room.Name = r.FormValue("name")
+		room.Type = r.FormValue("type")
+		if room.Name == "" || room.Type == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Check if 'capacity' query parameter is valid
+		if capacity, err := strconv.Atoi(r.FormValue("capacity")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if capacity < 1 {
+			http.Error(w, ErrInvalidCapacity.Error(), http.StatusBadRequest)
+			return
+		} else {
+			room.Capacity = capacity
+		}
+
+		if r.Method == "POST" {
+			// Insert Room
+			if roomID, err := room.insert(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				w.Write([]byte(strconv.Itoa(roomID)))
+			}
+		} else {
+			// Update Room
+			if err := room.update(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				w.Write([]byte(strconv.Itoa(room.ID)))
+			}
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) roomsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var count int
+		var err error
+
+		// Set default 'count' if not set by the query
+		if count, err = strconv.Atoi(r.FormValue("count")); err != nil {
+			count = 100
+		}
+
+		// Prepare parameters
+		params := map[string]interface{}{"count": count, "type": r.FormValue("type")}
+
+		// Get Rooms that match the parameters
+		if rooms, err := getRooms(s.store, params); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(roomViews(rooms))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) roomsAvailableHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Prepare parameters
+		params := map[string]interface{}{"type": r.FormValue("type")}
+
+		// Set 'guests' parameter if exists
+		if guests := r.FormValue("guests"); guests != "" {
+			if n, err := strconv.Atoi(guests); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else {
+				params["guests"] = n
+			}
+		}
+
+		// Get Rooms that are free for the whole stay
+		if rooms, err := getAvailableRooms(s.store, r.FormValue("checkinDate"), r.FormValue("checkoutDate"), params); err != nil {
+			http.Error(w, err.Error(), roomBookingStatus(err))
+			return
+		} else {
+			data, err := json.Marshal(roomViews(rooms))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) postHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		post := &Post{}
+
+		// Check if 'id' query parameter is valid
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			post.ID = id
+		}
+
+		// Get Post with set 'id'
+		if err := post.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			data, err := json.Marshal(post.view())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST", "PATCH", "DELETE":
+		// Get the User that requireRole let through
+		user := requestUser(r)
+
+		// Initialize a new Post or get the Post with set 'id'
+		post := &Post{UserID: user.ID}
+		if r.Method != "POST" {
+			if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+				http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+				return
+			} else {
+				post.ID = id
+			}
+			if err := post.fetch(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if r.Method == "DELETE" {
+			if err := post.delete(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Check if 'type' and 'title' query parameters are valid
+		post.Type = r.FormValue("type")
+		if !contains(postTypes, post.Type) {
+			http.Error(w, ErrInvalidPostType.Error(), http.StatusBadRequest)
+			return
+		}
+		post.Title = r.FormValue("title")
+		if post.Title == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+		post.Description = r.FormValue("description")
+		post.Link = r.FormValue("link")
+		post.ImageURL = r.FormValue("imageURL")
+
+		// Set meta from 'meta.[key]' query parameters
+		post.Meta = map[string]string{}
+		for k, v := range r.Form {
+			if key := strings.TrimPrefix(k, "meta."); key != k && key != "" && len(v) > 0 {
+				post.Meta[key] = v[0]
+			}
+		}
+
+		if r.Method == "POST" {
+			// Insert Post
+			if postID, err := post.insert(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				w.Write([]byte(strconv.Itoa(postID)))
+			}
+		} else {
+			// Update Post
+			if err := post.update(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				w.Write([]byte(strconv.Itoa(post.ID)))
+			}
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) postsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Prepare parameters
+		params := map[string]interface{}{"type": r.FormValue("type")}
+
+		// Set 'page' and 'count' parameters if set by the query
+		if page, err := strconv.Atoi(r.FormValue("page")); err == nil {
+			params["page"] = page
+		}
+		if count, err := strconv.Atoi(r.FormValue("count")); err == nil {
+			params["count"] = count
+		}
+
+		// Get Posts that match the parameters
+		if posts, err := getPosts(s.store, params); err != nil {
+			if err == ErrInvalidPostType {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		} else {
+			data, err := json.Marshal(postViews(posts))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		media := &Media{}
+
+		// Check if 'id' query parameter is valid
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			media.ID = id
+		}
+
+		// Get Media with set 'id'
+		if err := media.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			data, err := json.Marshal(media.view())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	case "POST":
+		// Get the User that requireRole let through
+		user := requestUser(r)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize)
+
+		// Check if 'collection' query parameter is valid
+		media := &Media{UserID: user.ID, Collection: r.FormValue("collection")}
+		if media.Collection == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Check if the uploaded file is an image or a PDF
+		file, fileheader, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contentType, err := detectContentType(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		extension, ok := mediaContentTypes[contentType]
+		if !ok {
+			http.Error(w, ErrUnsupportedMediaType.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		media.Name = fileheader.Filename
+		if name := r.FormValue("name"); name != "" {
+			media.Name = name
+		}
+		media.ContentType = contentType
+		media.Size = fileheader.Size
+		media.Filename = randomFilename() + extension
+
+		// Copy uploaded file to the media folder
+		if _, err := copyFile(r, "file", *mediadir, media.Filename); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Insert Media
+		if mediaID, err := media.insert(s.store); err != nil {
+			os.Remove(filepath.Join(*mediadir, media.Filename))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			w.Write([]byte(strconv.Itoa(mediaID)))
+		}
+
+	case "PATCH", "DELETE":
+		// Get Media with set 'id'
+		media := &Media{}
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			media.ID = id
+		}
+		if err := media.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == "DELETE" {
+			if err := media.delete(s.store); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// Remove the file, which is no longer referenced
+			if err := os.Remove(filepath.Join(*mediadir, media.Filename)); err != nil {
+				log.Println(err)
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Set Media info, keeping the fields that aren't set by the query
+		if name := r.FormValue("name"); name != "" {
+			media.Name = name
+		}
+		if collection := r.FormValue("collection"); collection != "" {
+			media.Collection = collection
+		}
+
+		// Update Media
+		if err := media.update(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			w.Write([]byte(strconv.Itoa(media.ID)))
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) mediaCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		collection := r.FormValue("name")
+		if collection == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Get the Media in the collection
+		if media, err := getMediaCollection(s.store, collection); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data, err := json.Marshal(mediaViews(media))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Serve the file of a Media with its detected content type
+func (s *Server) mediaFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	media := &Media{ID: id}
+	if err := media.fetch(s.store); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(*mediadir, media.Filename))
+	if err != nil {
+		log.Println(err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", media.ContentType)
+	http.ServeContent(w, r, media.Name, time.Unix(media.CreatedAt, 0), file)
+}
+
+// Send a new verification code to User's email
+func (s *Server) sendVerificationCode(user *User) error {
+	code, err := user.createVerificationCode(s.store)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(user.Email, "Verify your email", fmt.Sprint(
+		"Hello ", user.Firstname, ",\n\n",
+		"Please verify your email by opening this link:\n",
+		*address, "/api/verify?code=", code, "\n\n",
+		"The link expires in ", int(verificationCodeTTL.Hours()), " hours.",
+	))
+}
+
+func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	// The link in the email is opened with GET
+	case "GET", "POST":
+		if _, err := verifyUser(s.store, r.FormValue("code")); err != nil {
+			if err == ErrInvalidVerificationCode {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if *serveTest {
+			http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) verifyResendHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, true)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		if user.Verified {
+			http.Error(w, ErrAlreadyVerified.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.sendVerificationCode(user); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) passwordForgotHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		email := r.FormValue("email")
+		if email == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Only send the token if the User exists, without telling whether it does
+		user := &User{Email: email}
+		if exists := user.exists(s.store, true); exists {
+			if token, err := user.createPasswordResetToken(s.store); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if err := s.mailer.Send(user.Email, "Reset your password", fmt.Sprint(
+				"Hello ", user.Firstname, ",\n\n",
+				"Use this token to reset your password:\n",
+				token, "\n\n",
+				"It expires in ", int(passwordResetTokenTTL.Minutes()), " minutes. ",
+				"If you didn't ask to reset your password, you can ignore this email.",
+			)); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) passwordResetHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		password := r.FormValue("password")
+
+		// Check password length
+		if len(password) < 8 {
+			http.Error(w, ErrPasswordTooShort.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Set the new password, which logs the User out everywhere
+		user, err := resetPassword(s.store, r.FormValue("token"), password)
+		if err != nil {
+			if err == ErrInvalidPasswordResetToken {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Whoever reset the password owns the email, so lift its lockout
+		if err := clearLoginFailures(s.store, loginEmailSubject(user.Email)); err != nil {
+			log.Println(err)
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) adminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		admin := requestUser(r)
+
+		// Get User with set 'id'
+		user := &User{}
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			user.ID = id
+		}
+		if err := user.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		// Admins can't demote themselves, so that there's always an admin left
+		if user.ID == admin.ID {
+			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Set the role
+		if err := user.setRole(s.store, r.FormValue("role")); err == ErrInvalidRole {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(user.view(VisibilitySelf))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) adminUserBlockHandler(w http.ResponseWriter, r *http.Request) {
+	s.adminSetBlocked(w, r, true)
+}
+
+func (s *Server) adminUserUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	s.adminSetBlocked(w, r, false)
+}
+
+// Block the User with set 'id', or unblock it and lift the lockout of its email
+func (s *Server) adminSetBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	switch r.Method {
+	case "POST":
+		admin := requestUser(r)
+
+		// Get User with set 'id'
+		user := &User{}
+		if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			user.ID = id
+		}
+		if err := user.fetch(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		// Admins can't block themselves
+		if blocked && user.ID == admin.ID {
+			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		if err := user.setBlocked(s.store, blocked); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) userSessionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "DELETE":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, false)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Get the User's session with set 'id'
+		userSession := &UserSession{ID: r.FormValue("id")}
+		if userSession.ID == "" {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := userSession.fetch(s.store); err != nil || userSession.UserID != user.ID {
+			http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		// Revoking the current session logs the User out here too
+		if userSession.ID == s.currentSessionID(r) {
+			if err := s.logOut(w, r); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else if err := userSession.delete(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) userSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, false)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		userSessions, err := user.sessions(s.store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(userSessionViews(userSessions, s.currentSessionID(r)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	case "DELETE":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, false)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Log out everywhere, including here
+		if err := user.deleteSessions(s.store); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.logOut(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
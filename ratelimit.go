@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Groups of routes that share a rate limit, from the most to the least specific.
+// Paths ending in '/' match every path under them.
+var rateLimitGroups = []struct {
+	name  string
+	paths []string
+}{
+	// Reads that fan out to many records
+	{"search", []string{"/api/users", "/api/user/similarUsers", "/api/rooms/available", "/api/longtable/availableSeats", "/api/posts", "/api/longtables"}},
+	{"api", []string{"/api/"}},
+}
+
+// RateLimit allows Requests per Period, in bursts of up to Requests
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Get the token bucket of the rate limit at key
+func (rateLimit RateLimit) bucket(key string) TokenBucket {
+	return TokenBucket{
+		Key:      key,
+		Capacity: rateLimit.Requests,
+		Interval: rateLimit.Period / time.Duration(rateLimit.Requests),
+	}
+}
+
+// RateLimits of a group of routes, for anonymous clients by IP and for logged in Users
+type RateLimits struct {
+	Anonymous RateLimit
+	User      RateLimit
+}
+
+// RateLimiter is a negroni middleware that limits the requests of every client
+// to each group of routes, responding with 429 Too Many Requests once it's used
+// up its limit
+type RateLimiter struct {
+	server *Server
+	limits map[string]RateLimits
+}
+
+func newRateLimiter(s *Server, limits map[string]RateLimits) *RateLimiter {
+	return &RateLimiter{server: s, limits: limits}
+}
+
+func (limiter *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	group := rateLimitGroup(r.URL.Path)
+	limits, ok := limiter.limits[group]
+	if !ok {
+		next(w, r)
+		return
+	}
+
+	// Logged in Users are limited by their ID, so that they don't share the
+	// limit of everyone behind the same IP
+	rateLimit := limits.Anonymous
+	subject := loginIPSubject(clientIP(r))
+	if userSession, err := limiter.server.requestSession(r); err == nil {
+		rateLimit = limits.User
+		subject = fmt.Sprint("user:", userSession.UserID)
+	}
+
+	remaining, wait, err := limiter.server.store.TakeToken(rateLimit.bucket(fmt.Sprint("rateLimit:", group, ":", subject)))
+	if err != nil {
+		// Don't take the API down with the store; the handlers report its errors
+		next(w, r)
+		return
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rateLimit.Requests))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, ErrTooManyRequests.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	next(w, r)
+}
+
+// Get the name of the rate limit group of a path, or "" if it's in none
+func rateLimitGroup(path string) string {
+	for _, group := range rateLimitGroups {
+		for _, groupPath := range group.paths {
+			if path == groupPath || (strings.HasSuffix(groupPath, "/") && strings.HasPrefix(path, groupPath)) {
+				return group.name
+			}
+		}
+	}
+	return ""
+}
+
+// Parse rate limits of groups, e.g. "search=20/1m:60/1m,api=120/1m:600/1m" for
+// 20 requests a minute to search by anonymous clients and 60 by logged in Users
+func parseRateLimits(value string) (map[string]RateLimits, error) {
+	limits := map[string]RateLimits{}
+	for _, groupLimits := range strings.Split(value, ",") {
+		if groupLimits = strings.TrimSpace(groupLimits); groupLimits == "" {
+			continue
+		}
+
+		parts := strings.SplitN(groupLimits, "=", 2)
+		if len(parts) != 2 || !rateLimitGroupExists(parts[0]) {
+			return nil, ErrInvalidRateLimit
+		}
+		group, rates := parts[0], strings.Split(parts[1], ":")
+		if len(rates) != 2 {
+			return nil, ErrInvalidRateLimit
+		}
+
+		anonymous, err := parseRateLimit(rates[0])
+		if err != nil {
+			return nil, err
+		}
+		user, err := parseRateLimit(rates[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[group] = RateLimits{Anonymous: anonymous, User: user}
+	}
+	return limits, nil
+}
+
+// Parse a rate limit, e.g. "20/1m" for 20 requests a minute
+func parseRateLimit(value string) (RateLimit, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 1 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period < time.Duration(requests)*time.Millisecond {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+
+	return RateLimit{Requests: requests, Period: period}, nil
+}
+
+// Check if there is a rate limit group with name
+func rateLimitGroupExists(name string) bool {
+	for _, group := range rateLimitGroups {
+		if group.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	t.Parallel()
+
+	limits, err := parseRateLimits("search=20/1m:60/1m, api=2/1s:4/1s")
+	if err != nil {
+		t.Fatal("parseRateLimits:", err)
+	}
+	if limits["search"] != (RateLimits{RateLimit{20, time.Minute}, RateLimit{60, time.Minute}}) || limits["api"].User.Requests != 4 {
+		t.Error("parseRateLimits:", limits)
+	}
+	if limits, err := parseRateLimits(""); err != nil || len(limits) != 0 {
+		t.Error("parseRateLimits: empty:", limits, err)
+	}
+
+	for _, value := range []string{"nothing=1/1m:1/1m", "api=1/1m", "api=0/1m:1/1m", "api=1/forever:1/1m", "api=2000/1s:1/1m"} {
+		if _, err := parseRateLimits(value); err != ErrInvalidRateLimit {
+			t.Error("parseRateLimits:", value, err)
+		}
+	}
+
+	for path, group := range map[string]string{"/api/users": "search", "/api/user": "api", "/api/user/similarUsers": "search", "/media/1": ""} {
+		if rateLimitGroup(path) != group {
+			t.Error("rateLimitGroup:", path, rateLimitGroup(path))
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	s := &Server{store: store, mailer: LogMailer{}}
+
+	user := &User{Firstname: "Ray", Email: "ray.ratelimit@example.com"}
+	if _, err := user.insert(store); err != nil {
+		t.Fatal("user.insert:", err)
+	}
+	defer user.delete(store)
+	cookies := loginCookies(t, s, user)
+
+	// Clients get their own IP, so that they don't share limits with other tests
+	const ip = "198.51.100.20"
+	defer store.Del("rateLimit:search:ip:"+ip, fmt.Sprint("rateLimit:search:user:", user.ID))
+
+	limiter := newRateLimiter(s, map[string]RateLimits{"search": {RateLimit{2, time.Minute}, RateLimit{3, time.Minute}}})
+	request := func(path string, loggedIn bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":1234"
+		if loggedIn {
+			for _, cookie := range cookies {
+				r.AddCookie(cookie)
+			}
+		}
+		w := httptest.NewRecorder()
+		limiter.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {})
+		return w
+	}
+
+	// Anonymous clients use up their limit
+	for i := 0; i < 2; i++ {
+		if response := request("/api/users", false); response.Code != http.StatusOK || response.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatal("RateLimiter: anonymous:", response.Code, response.Header())
+		}
+	}
+	response := request("/api/users", false)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "30" || response.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Error("RateLimiter: anonymous limit:", response.Code, response.Header())
+	}
+
+	// Logged in Users have a limit of their own
+	for i := 0; i < 3; i++ {
+		if response := request("/api/users", true); response.Code != http.StatusOK || response.Header().Get("X-RateLimit-Limit") != "3" {
+			t.Fatal("RateLimiter: user:", response.Code, response.Header())
+		}
+	}
+	if response := request("/api/users", true); response.Code != http.StatusTooManyRequests {
+		t.Error("RateLimiter: user limit:", response.Code)
+	}
+
+	// Routes without limits aren't limited
+	if response := request("/api/user", false); response.Code != http.StatusOK || response.Header().Get("X-RateLimit-Limit") != "" {
+		t.Error("RateLimiter: unlimited:", response.Code, response.Header())
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// SessionOptions configures how the sessions of visitors are kept
+type SessionOptions struct {
+	// Keys that sign session cookies, each optionally followed by ':' and a key
+	// that encrypts them. The first key signs new cookies, and the rest are
+	// still accepted so that keys can be rotated without logging everyone out.
+	Keys []string
+
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	MaxAge   time.Duration
+
+	// Keep session values in Store, so that the cookie only holds the session ID
+	// and deleting a session revokes it. Sessions are kept in the cookie if nil.
+	Store Store
+}
+
+// Create a session store from options
+func newSessionStore(options SessionOptions) (sessions.Store, error) {
+	keyPairs, err := sessionKeyPairs(options.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keyPairs) == 0 {
+		return nil, ErrMissingKey
+	}
+
+	cookieOptions := &sessions.Options{
+		Path:     "/",
+		Domain:   options.Domain,
+		MaxAge:   int(options.MaxAge / time.Second),
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+		SameSite: options.SameSite,
+	}
+
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if codec, ok := codec.(*securecookie.SecureCookie); ok {
+			codec.MaxAge(cookieOptions.MaxAge)
+		}
+	}
+
+	if options.Store != nil {
+		return &ServerSessionStore{store: options.Store, Codecs: codecs, Options: cookieOptions}, nil
+	}
+	return &sessions.CookieStore{Codecs: codecs, Options: cookieOptions}, nil
+}
+
+// Get the key pairs of securecookie from session keys
+func sessionKeyPairs(keys []string) ([][]byte, error) {
+	var keyPairs [][]byte
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+
+		hashKey, blockKey := key, ""
+		if i := strings.Index(key, ":"); i >= 0 {
+			hashKey, blockKey = key[:i], key[i+1:]
+		}
+
+		// Encryption keys select AES-128, AES-192 or AES-256
+		switch len(blockKey) {
+		case 0:
+			keyPairs = append(keyPairs, []byte(hashKey), nil)
+		case 16, 24, 32:
+			keyPairs = append(keyPairs, []byte(hashKey), []byte(blockKey))
+		default:
+			return nil, ErrInvalidSessionKey
+		}
+	}
+	return keyPairs, nil
+}
+
+// Parse the SameSite attribute of cookies: lax, strict, none, or empty to leave it out
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, ErrInvalidSameSite
+	}
+}
+
+// ServerSessionStore is a sessions.Store that keeps session values in a Store
+// and only the signed session ID in the cookie
+type ServerSessionStore struct {
+	store   Store
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// Get a session from the registry of the request
+func (s *ServerSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// Load the session of the request's cookie, or start a new one
+func (s *ServerSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	// Sessions that expired or were deleted start over with a new ID
+	data, err := s.store.Get(sessionValuesKey(session.ID))
+	if err == ErrNil {
+		session.ID = ""
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save the session values in the Store and its ID in the cookie.
+// A negative MaxAge deletes the session.
+func (s *ServerSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.store.Del(sessionValuesKey(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := randomCode()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	if session.Options.MaxAge > 0 {
+		err = s.store.SetEx(sessionValuesKey(session.ID), data, time.Duration(session.Options.MaxAge)*time.Second)
+	} else {
+		err = s.store.Set(sessionValuesKey(session.ID), data)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// Forget the ID of session and the values kept under it, so that it's saved
+// with a new ID. Sessions that are kept in the cookie have no ID.
+func renewSession(store Store, session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	if err := store.Del(sessionValuesKey(session.ID)); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// Key of the values of a session kept in the Store
+func sessionValuesKey(id string) string {
+	return "sessionValues:" + id
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Save a session with a userID in sessionStore and return its cookies
+func saveSession(t *testing.T, sessionStore sessions.Store, userID int) []*http.Cookie {
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := sessionStore.Get(r, "session")
+	if err != nil {
+		t.Fatal("sessionStore.Get:", err)
+	}
+	session.Values["userID"] = userID
+
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal("session.Save:", err)
+	}
+	return w.Result().Cookies()
+}
+
+// Load the session of cookies from sessionStore
+func loadSession(sessionStore sessions.Store, cookies []*http.Cookie) (*sessions.Session, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return sessionStore.Get(r, "session")
+}
+
+func TestSessionKeys(t *testing.T) {
+	t.Parallel()
+
+	// Encryption keys have to fit AES
+	if _, err := sessionKeyPairs([]string{"signing-key:too-short"}); err != ErrInvalidSessionKey {
+		t.Error("sessionKeyPairs: short encryption key:", err)
+	}
+	if pairs, err := sessionKeyPairs([]string{"new-key:0123456789abcdef", " ", "old-key"}); err != nil || len(pairs) != 4 || pairs[3] != nil {
+		t.Error("sessionKeyPairs:", pairs, err)
+	}
+	if _, err := newSessionStore(SessionOptions{}); err != ErrMissingKey {
+		t.Error("newSessionStore: no keys:", err)
+	}
+
+	oldStore, err := newSessionStore(SessionOptions{Keys: []string{"old-key"}, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal("newSessionStore:", err)
+	}
+	rotatedStore, _ := newSessionStore(SessionOptions{Keys: []string{"new-key:0123456789abcdef", "old-key"}, MaxAge: time.Hour})
+	newStore, _ := newSessionStore(SessionOptions{Keys: []string{"new-key:0123456789abcdef"}, MaxAge: time.Hour})
+
+	// Cookies signed with the old key are still accepted after the rotation
+	cookies := saveSession(t, oldStore, 1)
+	if session, err := loadSession(rotatedStore, cookies); err != nil || session.Values["userID"] != 1 {
+		t.Error("rotated key:", session.Values, err)
+	}
+
+	// New cookies are signed with the new key
+	cookies = saveSession(t, rotatedStore, 2)
+	if session, err := loadSession(newStore, cookies); err != nil || session.Values["userID"] != 2 {
+		t.Error("new key:", session.Values, err)
+	}
+
+	// Once the old key is retired, its cookies are rejected
+	if session, err := loadSession(newStore, saveSession(t, oldStore, 3)); err == nil || session.Values["userID"] != nil {
+		t.Error("retired key:", session.Values)
+	}
+}
+
+func TestSessionCookieOptions(t *testing.T) {
+	t.Parallel()
+
+	if _, err := parseSameSite("sometimes"); err != ErrInvalidSameSite {
+		t.Error("parseSameSite:", err)
+	}
+	sameSite, err := parseSameSite("Strict")
+	if err != nil {
+		t.Fatal("parseSameSite:", err)
+	}
+
+	sessionStore, err := newSessionStore(SessionOptions{
+		Keys:     []string{"key"},
+		Domain:   "example.com",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite,
+		MaxAge:   2 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal("newSessionStore:", err)
+	}
+
+	cookies := saveSession(t, sessionStore, 1)
+	if len(cookies) != 1 {
+		t.Fatal("session.Save:", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Domain != "example.com" || !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 7200 {
+		t.Error("session.Save:", cookie)
+	}
+}
+
+func TestServerSessionStore(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	sessionStore, err := newSessionStore(SessionOptions{Keys: []string{"key"}, MaxAge: time.Hour, Store: store})
+	if err != nil {
+		t.Fatal("newSessionStore:", err)
+	}
+
+	// The values are kept in the store
+	cookies := saveSession(t, sessionStore, 1)
+	session, err := loadSession(sessionStore, cookies)
+	if err != nil || session.IsNew || session.Values["userID"] != 1 {
+		t.Fatal("ServerSessionStore.New:", session.Values, err)
+	}
+	if ok, err := store.Exists(sessionValuesKey(session.ID)); !ok || err != nil {
+		t.Error("ServerSessionStore.Save:", err)
+	}
+
+	// Deleting the session revokes it, even if its cookie is kept
+	session.Options.MaxAge = -1
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Error("ServerSessionStore.Save: delete:", err)
+	}
+	if revoked, err := loadSession(sessionStore, cookies); err != nil || !revoked.IsNew || revoked.ID != "" || len(revoked.Values) != 0 {
+		t.Error("ServerSessionStore.New: revoked:", revoked.Values, err)
+	}
+}
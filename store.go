@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Store is the database used by the data types.
+// Its methods mirror the Redis commands of the same name; missing keys
+// make Get, GetDel, HGet, HGetDel, ZScore and ZPopMin return ErrNil.
+type Store interface {
+	Exists(key string) (bool, error)
+	Get(key string) (string, error)
+	Set(key string, value interface{}) error
+	SetEx(key string, value interface{}, ttl time.Duration) error
+	GetDel(key string) (string, error)
+	Del(keys ...string) error
+	Expire(key string, ttl time.Duration) error
+	Incr(key string) (int, error)
+
+	HGet(key, field string) (string, error)
+	HGetAll(key string) (map[string]string, error)
+	HMSet(key string, fields map[string]interface{}) error
+	HDel(key string, fields ...string) error
+	// HGetDel removes a field and returns its value, so that it's only taken
+	// once, however many take it at the same time
+	HGetDel(key, field string) (string, error)
+
+	ZAdd(key string, score int64, member interface{}) error
+	ZRem(key string, member interface{}) error
+	ZRange(key string, start, stop int) ([]string, error)
+	ZRevRange(key string, start, stop int) ([]string, error)
+	ZScore(key string, member interface{}) (int64, error)
+	// ZPopMin removes the member with the lowest score and returns it, so that
+	// each member is only taken once, however many take at the same time
+	ZPopMin(key string) (member string, score int64, err error)
+
+	// Reserve writes a record in a single atomic step, see Reservation
+	Reserve(reservation *Reservation) (int, error)
+
+	// TakeToken takes a token from a TokenBucket in a single atomic step,
+	// returning how many are left. If the bucket is empty, no token is taken
+	// and it returns how long until there is one.
+	TakeToken(bucket TokenBucket) (remaining int, wait time.Duration, err error)
+
+	Close() error
+}
+
+// Reservation is a hash record that is only written if the keys it claims are
+// free. Claims are plain keys holding the ID of the record that owns them, e.g.
+// a seat at a LongTable on particular date.
+type Reservation struct {
+	ID      int    // ID of an existing record, or zero to allocate one from Counter
+	Counter string // Key of the ID counter, e.g. "nextLongTableBookingID"
+	Prefix  string // The record is stored at Prefix + ID, e.g. "longTableBooking:"
+	Fields  map[string]interface{}
+
+	Absent  []string // Keys that must not exist
+	Claims  []string // Keys that must be free or already held by the record
+	Release []string // Claims held by the record that are given up
+	Indexes []string // Sorted sets the record is added to
+	Unindex []string // Sorted sets the record is removed from
+	Score   int64    // Score of the record in Indexes
+}
+
+// ConflictError is returned by Store.Reserve when one of the Absent keys exists
+// or one of the Claims is held by another record
+type ConflictError struct {
+	Key string
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprint("Conflict on ", err.Key)
+}
+
+// TokenBucket is a rate limit that holds up to Capacity tokens, and regains a
+// token every Interval. It's stored as a hash that expires once it's full again.
+type TokenBucket struct {
+	Key      string
+	Capacity int
+	Interval time.Duration
+}
+
+// Convert the members of a sorted set to IDs
+func ids(members []string, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(members))
+	for i, member := range members {
+		if ids[i], err = strconv.Atoi(member); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps its data in memory instead of Redis, so it
+// needs no server and every instance starts empty, e.g. for tests.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]int64
+	expires map[string]time.Time
+}
+
+func newMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		strings: map[string]string{},
+		hashes:  map[string]map[string]string{},
+		zsets:   map[string]map[string]int64{},
+		expires: map[string]time.Time{},
+	}
+}
+
+// Format a value the way it's sent to Redis
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Delete the keys whose time to live has passed, like Redis does when they're accessed
+func (store *MemoryStore) purge() {
+	now := time.Now()
+	for key, expiry := range store.expires {
+		if !now.Before(expiry) {
+			store.del(key)
+		}
+	}
+}
+
+func (store *MemoryStore) exists(key string) bool {
+	if _, ok := store.strings[key]; ok {
+		return true
+	}
+	if _, ok := store.hashes[key]; ok {
+		return true
+	}
+	if _, ok := store.zsets[key]; ok {
+		return true
+	}
+	return false
+}
+
+func (store *MemoryStore) del(key string) {
+	delete(store.expires, key)
+	delete(store.strings, key)
+	delete(store.hashes, key)
+	delete(store.zsets, key)
+}
+
+func (store *MemoryStore) hmset(key string, fields map[string]interface{}) {
+	hash, ok := store.hashes[key]
+	if !ok {
+		hash = map[string]string{}
+		store.hashes[key] = hash
+	}
+	for k, v := range fields {
+		hash[k] = formatValue(v)
+	}
+}
+
+func (store *MemoryStore) zadd(key string, score int64, member interface{}) {
+	zset, ok := store.zsets[key]
+	if !ok {
+		zset = map[string]int64{}
+		store.zsets[key] = zset
+	}
+	zset[formatValue(member)] = score
+}
+
+func (store *MemoryStore) zrem(key string, member interface{}) {
+	if zset, ok := store.zsets[key]; ok {
+		delete(zset, formatValue(member))
+
+		// Like Redis, empty sorted sets don't exist
+		if len(zset) == 0 {
+			store.del(key)
+		}
+	}
+}
+
+func (store *MemoryStore) zrange(key string, start, stop int, reverse bool) []string {
+	zset := store.zsets[key]
+
+	// Order members by score, then lexicographically
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]] != reverse
+		}
+		return members[i] < members[j] != reverse
+	})
+
+	// Negative indexes count from the end, like in Redis
+	if start < 0 {
+		start += len(members)
+	}
+	if stop < 0 {
+		stop += len(members)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(members) {
+		stop = len(members) - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+
+	return members[start : stop+1]
+}
+
+func (store *MemoryStore) Exists(key string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	return store.exists(key), nil
+}
+
+func (store *MemoryStore) Get(key string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	if value, ok := store.strings[key]; ok {
+		return value, nil
+	}
+	return "", ErrNil
+}
+
+func (store *MemoryStore) Set(key string, value interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	store.del(key)
+	store.strings[key] = formatValue(value)
+	return nil
+}
+
+func (store *MemoryStore) SetEx(key string, value interface{}, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	store.del(key)
+	store.strings[key] = formatValue(value)
+	store.expires[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (store *MemoryStore) Expire(key string, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	if store.exists(key) {
+		store.expires[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (store *MemoryStore) GetDel(key string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	if value, ok := store.strings[key]; ok {
+		store.del(key)
+		return value, nil
+	}
+	return "", ErrNil
+}
+
+func (store *MemoryStore) Del(keys ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	for _, key := range keys {
+		store.del(key)
+	}
+	return nil
+}
+
+func (store *MemoryStore) Incr(key string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	value := 0
+	if s, ok := store.strings[key]; ok {
+		var err error
+		if value, err = strconv.Atoi(s); err != nil {
+			return 0, err
+		}
+	}
+	value++
+	store.strings[key] = strconv.Itoa(value)
+
+	return value, nil
+}
+
+func (store *MemoryStore) HGet(key, field string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	if value, ok := store.hashes[key][field]; ok {
+		return value, nil
+	}
+	return "", ErrNil
+}
+
+func (store *MemoryStore) HGetAll(key string) (map[string]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	fields := map[string]string{}
+	for k, v := range store.hashes[key] {
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+func (store *MemoryStore) HMSet(key string, fields map[string]interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	store.hmset(key, fields)
+	return nil
+}
+
+func (store *MemoryStore) HDel(key string, fields ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	hash, ok := store.hashes[key]
+	if !ok {
+		return nil
+	}
+	for _, field := range fields {
+		delete(hash, field)
+	}
+
+	// Like on Redis, hashes without fields don't exist
+	if len(hash) == 0 {
+		store.del(key)
+	}
+	return nil
+}
+
+func (store *MemoryStore) HGetDel(key, field string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	hash := store.hashes[key]
+	value, ok := hash[field]
+	if !ok {
+		return "", ErrNil
+	}
+	delete(hash, field)
+	if len(hash) == 0 {
+		store.del(key)
+	}
+	return value, nil
+}
+
+func (store *MemoryStore) ZAdd(key string, score int64, member interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	store.zadd(key, score, member)
+	return nil
+}
+
+func (store *MemoryStore) ZRem(key string, member interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	store.zrem(key, member)
+	return nil
+}
+
+func (store *MemoryStore) ZRange(key string, start, stop int) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	return store.zrange(key, start, stop, false), nil
+}
+
+func (store *MemoryStore) ZRevRange(key string, start, stop int) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	return store.zrange(key, start, stop, true), nil
+}
+
+func (store *MemoryStore) ZScore(key string, member interface{}) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	if score, ok := store.zsets[key][formatValue(member)]; ok {
+		return score, nil
+	}
+	return 0, ErrNil
+}
+
+func (store *MemoryStore) ZPopMin(key string) (string, int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	members := store.zrange(key, 0, 0, false)
+	if len(members) == 0 {
+		return "", 0, ErrNil
+	}
+
+	score := store.zsets[key][members[0]]
+	store.zrem(key, members[0])
+	return members[0], score, nil
+}
+
+func (store *MemoryStore) Reserve(reservation *Reservation) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	id := reservation.ID
+
+	for _, key := range reservation.Absent {
+		if store.exists(key) {
+			return 0, &ConflictError{Key: key}
+		}
+	}
+	for _, key := range reservation.Claims {
+		if holder, ok := store.strings[key]; ok && holder != strconv.Itoa(id) {
+			return 0, &ConflictError{Key: key}
+		}
+	}
+
+	if id == 0 {
+		value, _ := strconv.Atoi(store.strings[reservation.Counter])
+		id = value + 1
+		store.strings[reservation.Counter] = strconv.Itoa(id)
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range reservation.Fields {
+		fields[k] = v
+	}
+	fields["id"] = id
+	store.hmset(reservation.Prefix+strconv.Itoa(id), fields)
+
+	for _, key := range reservation.Release {
+		store.del(key)
+	}
+	for _, key := range reservation.Claims {
+		store.del(key)
+		store.strings[key] = strconv.Itoa(id)
+	}
+	for _, key := range reservation.Unindex {
+		store.zrem(key, id)
+	}
+	for _, key := range reservation.Indexes {
+		store.zadd(key, reservation.Score, id)
+	}
+
+	return id, nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+func (store *MemoryStore) TakeToken(bucket TokenBucket) (int, time.Duration, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+
+	now := time.Now()
+	capacity := float64(bucket.Capacity)
+
+	// Refill the bucket for the time since it was last used
+	tokens := capacity
+	if hash, ok := store.hashes[bucket.Key]; ok {
+		var err error
+		if tokens, err = strconv.ParseFloat(hash["tokens"], 64); err != nil {
+			return 0, 0, err
+		}
+		updatedAt, err := strconv.ParseInt(hash["updatedAt"], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if elapsed := now.Sub(time.Unix(0, updatedAt*int64(time.Millisecond))); elapsed > 0 {
+			tokens = math.Min(capacity, tokens+float64(elapsed)/float64(bucket.Interval))
+		}
+	}
+
+	var wait time.Duration
+	if tokens >= 1 {
+		tokens--
+	} else {
+		wait = time.Duration(math.Ceil((1 - tokens) * float64(bucket.Interval)))
+	}
+
+	store.hmset(bucket.Key, map[string]interface{}{
+		"tokens":    tokens,
+		"updatedAt": now.UnixNano() / int64(time.Millisecond),
+	})
+	store.expires[bucket.Key] = now.Add(time.Duration((capacity - tokens) * float64(bucket.Interval)))
+
+	return int(tokens), wait, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisStoreOptions configures the connection pool of a RedisStore
+type RedisStoreOptions struct {
+	MaxIdle     int           // Maximum number of idle connections
+	MaxActive   int           // Maximum number of open connections, zero for no limit
+	IdleTimeout time.Duration // Idle connections are closed after this duration
+	Timeout     time.Duration // Timeout for connecting, reading and writing
+}
+
+// RedisStore is a Store backed by a pool of Redis connections.
+// Unlike a single redis.Conn, it's safe for use by multiple goroutines.
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+func newRedisStore(address string, options RedisStoreOptions) *RedisStore {
+	return &RedisStore{
+		pool: &redis.Pool{
+			MaxIdle:     options.MaxIdle,
+			MaxActive:   options.MaxActive,
+			IdleTimeout: options.IdleTimeout,
+			Wait:        true,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address,
+					redis.DialConnectTimeout(options.Timeout),
+					redis.DialReadTimeout(options.Timeout),
+					redis.DialWriteTimeout(options.Timeout))
+			},
+			TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+				if time.Since(t) < time.Minute {
+					return nil
+				}
+				_, err := conn.Do("PING")
+				return err
+			},
+		},
+	}
+}
+
+// Run a command on a connection from the pool
+func (store *RedisStore) do(command string, args ...interface{}) (interface{}, error) {
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do(command, args...)
+	if err == nil && reply == nil {
+		err = ErrNil
+	}
+	return reply, err
+}
+
+func (store *RedisStore) Exists(key string) (bool, error) {
+	return redis.Bool(store.do("EXISTS", key))
+}
+
+func (store *RedisStore) Get(key string) (string, error) {
+	return redis.String(store.do("GET", key))
+}
+
+func (store *RedisStore) Set(key string, value interface{}) error {
+	_, err := store.do("SET", key, value)
+	return err
+}
+
+func (store *RedisStore) SetEx(key string, value interface{}, ttl time.Duration) error {
+	_, err := store.do("SET", key, value, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (store *RedisStore) Expire(key string, ttl time.Duration) error {
+	_, err := store.do("PEXPIRE", key, int64(ttl/time.Millisecond))
+	return err
+}
+
+// Get and delete a key in one step, like GETDEL on Redis 6.2 and later
+var getDelScript = redis.NewScript(1, `
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("DEL", KEYS[1])
+end
+return value
+`)
+
+func (store *RedisStore) GetDel(key string) (string, error) {
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	reply, err := getDelScript.Do(conn, key)
+	if err == nil && reply == nil {
+		err = ErrNil
+	}
+	return redis.String(reply, err)
+}
+
+func (store *RedisStore) Del(keys ...string) error {
+	_, err := store.do("DEL", redis.Args{}.AddFlat(keys)...)
+	return err
+}
+
+func (store *RedisStore) Incr(key string) (int, error) {
+	return redis.Int(store.do("INCR", key))
+}
+
+func (store *RedisStore) HGet(key, field string) (string, error) {
+	return redis.String(store.do("HGET", key, field))
+}
+
+func (store *RedisStore) HGetAll(key string) (map[string]string, error) {
+	return redis.StringMap(store.do("HGETALL", key))
+}
+
+func (store *RedisStore) HMSet(key string, fields map[string]interface{}) error {
+	_, err := store.do("HMSET", redis.Args{key}.AddFlat(fields)...)
+	return err
+}
+
+func (store *RedisStore) HDel(key string, fields ...string) error {
+	_, err := store.do("HDEL", redis.Args{key}.AddFlat(fields)...)
+	return err
+}
+
+// Get and delete a hash field in one step, like HGETDEL on Redis 8
+var hGetDelScript = redis.NewScript(1, `
+local value = redis.call("HGET", KEYS[1], ARGV[1])
+if value then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+func (store *RedisStore) HGetDel(key, field string) (string, error) {
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	reply, err := hGetDelScript.Do(conn, key, field)
+	if err == nil && reply == nil {
+		err = ErrNil
+	}
+	return redis.String(reply, err)
+}
+
+func (store *RedisStore) ZAdd(key string, score int64, member interface{}) error {
+	_, err := store.do("ZADD", key, score, member)
+	return err
+}
+
+func (store *RedisStore) ZRem(key string, member interface{}) error {
+	_, err := store.do("ZREM", key, member)
+	return err
+}
+
+func (store *RedisStore) ZRange(key string, start, stop int) ([]string, error) {
+	return redis.Strings(store.do("ZRANGE", key, start, stop))
+}
+
+func (store *RedisStore) ZRevRange(key string, start, stop int) ([]string, error) {
+	return redis.Strings(store.do("ZREVRANGE", key, start, stop))
+}
+
+// Scores are doubles, which Redis replies in exponent notation once they're large
+func (store *RedisStore) ZScore(key string, member interface{}) (int64, error) {
+	score, err := redis.Float64(store.do("ZSCORE", key, member))
+	return int64(score), err
+}
+
+// Pop the member with the lowest score in one step, like ZPOPMIN on Redis 5.0 and later
+var zPopMinScript = redis.NewScript(1, `
+local popped = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if popped[1] then
+	redis.call("ZREM", KEYS[1], popped[1])
+end
+return popped
+`)
+
+func (store *RedisStore) ZPopMin(key string) (string, int64, error) {
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	popped, err := redis.Strings(zPopMinScript.Do(conn, key))
+	if err != nil {
+		return "", 0, err
+	} else if len(popped) != 2 {
+		return "", 0, ErrNil
+	}
+
+	score, err := strconv.ParseFloat(popped[1], 64)
+	if err != nil {
+		return "", 0, err
+	}
+	return popped[0], int64(score), nil
+}
+
+// Check the Absent keys and Claims, then write the record, all within one script.
+//
+// KEYS[1] is the ID counter, followed by the Absent, Claims, Release, Indexes
+// and Unindex keys. ARGV[1] is the record ID, ARGV[2] the index score,
+// ARGV[3] the record key prefix and ARGV[4..8] the number of keys of each kind,
+// followed by the record's field/value pairs.
+var reserveScript = redis.NewScript(-1, `
+local id = tonumber(ARGV[1])
+
+local n = 1
+local function keys(count)
+	local list = {}
+	for i = 1, tonumber(count) do
+		n = n + 1
+		table.insert(list, KEYS[n])
+	end
+	return list
+end
+local absent, claims, release, indexes, unindex = keys(ARGV[4]), keys(ARGV[5]), keys(ARGV[6]), keys(ARGV[7]), keys(ARGV[8])
+
+for _, key in ipairs(absent) do
+	if redis.call("EXISTS", key) == 1 then
+		return {0, key}
+	end
+end
+for _, key in ipairs(claims) do
+	local holder = redis.call("GET", key)
+	if holder and tonumber(holder) ~= id then
+		return {0, key}
+	end
+end
+
+if id == 0 then
+	id = redis.call("INCR", KEYS[1])
+end
+
+local fields = {"id", id}
+for i = 9, #ARGV do
+	table.insert(fields, ARGV[i])
+end
+redis.call("HMSET", ARGV[3] .. id, unpack(fields))
+
+for _, key in ipairs(release) do
+	redis.call("DEL", key)
+end
+for _, key in ipairs(claims) do
+	redis.call("SET", key, id)
+end
+for _, key in ipairs(unindex) do
+	redis.call("ZREM", key, id)
+end
+for _, key in ipairs(indexes) do
+	redis.call("ZADD", key, ARGV[2], id)
+end
+
+return {id, ""}
+`)
+
+func (store *RedisStore) Reserve(reservation *Reservation) (int, error) {
+	keys := redis.Args{reservation.Counter}.
+		AddFlat(reservation.Absent).
+		AddFlat(reservation.Claims).
+		AddFlat(reservation.Release).
+		AddFlat(reservation.Indexes).
+		AddFlat(reservation.Unindex)
+
+	args := redis.Args{len(keys)}.AddFlat(keys).Add(
+		reservation.ID,
+		reservation.Score,
+		reservation.Prefix,
+		len(reservation.Absent),
+		len(reservation.Claims),
+		len(reservation.Release),
+		len(reservation.Indexes),
+		len(reservation.Unindex),
+	)
+	for k, v := range reservation.Fields {
+		// 'id' is set by the script
+		if k == "id" {
+			continue
+		}
+		args = args.Add(k, v)
+	}
+
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	var id int
+	var key string
+	if values, err := redis.Values(reserveScript.Do(conn, args...)); err != nil {
+		return 0, err
+	} else if _, err := redis.Scan(values, &id, &key); err != nil {
+		return 0, err
+	} else if key != "" {
+		return 0, &ConflictError{Key: key}
+	}
+
+	return id, nil
+}
+
+// Take a token from a bucket, refilling it for the time since it was last used.
+// KEYS: the bucket
+// ARGV: capacity, milliseconds per token, current time in milliseconds
+var takeTokenScript = redis.NewScript(1, `
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updatedAt")
+local tokens = tonumber(state[1]) or capacity
+local updatedAt = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updatedAt) / interval)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * interval)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updatedAt", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) * interval))
+
+return {math.floor(tokens), wait}
+`)
+
+func (store *RedisStore) TakeToken(bucket TokenBucket) (int, time.Duration, error) {
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	interval := int64(bucket.Interval / time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	var remaining, wait int64
+	if values, err := redis.Values(takeTokenScript.Do(conn, bucket.Key, bucket.Capacity, interval, now)); err != nil {
+		return 0, 0, err
+	} else if _, err := redis.Scan(values, &remaining, &wait); err != nil {
+		return 0, 0, err
+	}
+
+	return int(remaining), time.Duration(wait) * time.Millisecond, nil
+}
+
+func (store *RedisStore) Close() error {
+	return store.pool.Close()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRedis = flag.String("redis", "", "run the tests against the Redis server at this address instead of in memory")
+
+// Create the Store used by a test
+func newTestStore(t *testing.T) Store {
+	if *testRedis != "" {
+		return newRedisStore(*testRedis, RedisStoreOptions{
+			MaxIdle:     10,
+			IdleTimeout: time.Minute,
+			Timeout:     time.Second,
+		})
+	}
+
+	return newMemoryStore()
+}
+
+func TestStoreReserve(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	claim := "testClaim:1"
+	index := "testReservations"
+
+	// Reserve a claim for a new record
+	reservation := &Reservation{
+		Counter: "nextTestReservationID",
+		Prefix:  "testReservation:",
+		Fields:  map[string]interface{}{"name": "first"},
+		Claims:  []string{claim},
+		Indexes: []string{index},
+		Score:   time.Now().Unix(),
+	}
+	id, err := store.Reserve(reservation)
+	if err != nil {
+		t.Fatal("Store.Reserve:", err)
+	}
+
+	// The record, claim and index are written
+	if fields, err := store.HGetAll(reservation.Prefix + strconv.Itoa(id)); err != nil || fields["name"] != "first" || fields["id"] != strconv.Itoa(id) {
+		t.Error("Store.HGetAll:", fields, err)
+	}
+	if holder, err := store.Get(claim); err != nil || holder != strconv.Itoa(id) {
+		t.Error("Store.Get:", holder, err)
+	}
+	if members, err := store.ZRange(index, 0, -1); err != nil || len(members) != 1 {
+		t.Error("Store.ZRange:", members, err)
+	}
+
+	// Another record can't take the claim
+	if _, err := store.Reserve(&Reservation{
+		Counter: reservation.Counter,
+		Prefix:  reservation.Prefix,
+		Fields:  map[string]interface{}{"name": "second"},
+		Claims:  []string{claim},
+	}); err == nil {
+		t.Error("Store.Reserve: claim taken twice")
+	} else if conflict, ok := err.(*ConflictError); !ok || conflict.Key != claim {
+		t.Error("Store.Reserve:", err)
+	}
+
+	// Nor can it be written while an absent key exists
+	if _, err := store.Reserve(&Reservation{
+		Counter: reservation.Counter,
+		Prefix:  reservation.Prefix,
+		Fields:  map[string]interface{}{"name": "second"},
+		Absent:  []string{index},
+	}); err == nil {
+		t.Error("Store.Reserve: absent key ignored")
+	}
+
+	// The holder can move to another claim
+	if _, err := store.Reserve(&Reservation{
+		ID:      id,
+		Prefix:  reservation.Prefix,
+		Fields:  map[string]interface{}{"name": "moved"},
+		Claims:  []string{"testClaim:2"},
+		Release: []string{claim},
+		Unindex: []string{index},
+	}); err != nil {
+		t.Error("Store.Reserve:", err)
+	}
+	if _, err := store.Get(claim); err != ErrNil {
+		t.Error("Store.Get:", err)
+	}
+
+	if err := store.Del(reservation.Prefix+strconv.Itoa(id), "testClaim:2", index); err != nil {
+		t.Error("Store.Del:", err)
+	}
+}
+
+func TestStoreSortedSet(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	key := "testSortedSet"
+	defer store.Del(key)
+
+	for i, member := range []string{"c", "a", "b"} {
+		if err := store.ZAdd(key, int64(i%2), member); err != nil {
+			t.Fatal("Store.ZAdd:", err)
+		}
+	}
+
+	// Members are ordered by score, then lexicographically
+	if members, err := store.ZRange(key, 0, -1); err != nil || strings.Join(members, "") != "bca" {
+		t.Error("Store.ZRange:", members, err)
+	}
+	if members, err := store.ZRange(key, -2, 10); err != nil || strings.Join(members, "") != "ca" {
+		t.Error("Store.ZRange:", members, err)
+	}
+	if members, err := store.ZRevRange(key, 0, 1); err != nil || strings.Join(members, "") != "ac" {
+		t.Error("Store.ZRevRange:", members, err)
+	}
+
+	if score, err := store.ZScore(key, "a"); err != nil || score != 1 {
+		t.Error("Store.ZScore:", score, err)
+	}
+
+	// Popping takes the lowest score
+	if member, score, err := store.ZPopMin(key); err != nil || member != "b" || score != 0 {
+		t.Error("Store.ZPopMin:", member, score, err)
+	}
+
+	// Removing every member removes the sorted set
+	for _, member := range []string{"a", "c"} {
+		if err := store.ZRem(key, member); err != nil {
+			t.Fatal("Store.ZRem:", err)
+		}
+	}
+	if exists, err := store.Exists(key); err != nil || exists {
+		t.Error("Store.Exists:", exists, err)
+	}
+	if _, err := store.ZScore(key, "a"); err != ErrNil {
+		t.Error("Store.ZScore:", err)
+	}
+	if _, _, err := store.ZPopMin(key); err != ErrNil {
+		t.Error("Store.ZPopMin: empty:", err)
+	}
+}
+
+func TestStoreHash(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	key := "testHash"
+	defer store.Del(key)
+
+	if err := store.HMSet(key, map[string]interface{}{"a": 1, "b": "two"}); err != nil {
+		t.Fatal("Store.HMSet:", err)
+	}
+	if value, err := store.HGet(key, "b"); err != nil || value != "two" {
+		t.Error("Store.HGet:", value, err)
+	}
+
+	// HDel removes fields, and the hash along with its last one
+	if err := store.HDel(key, "a", "missing"); err != nil {
+		t.Error("Store.HDel:", err)
+	}
+	if fields, err := store.HGetAll(key); err != nil || len(fields) != 1 || fields["b"] != "two" {
+		t.Error("Store.HGetAll:", fields, err)
+	}
+	if err := store.HDel(key, "b"); err != nil {
+		t.Error("Store.HDel:", err)
+	}
+	if exists, err := store.Exists(key); err != nil || exists {
+		t.Error("Store.Exists: empty hash:", exists, err)
+	}
+
+	// HGetDel returns a field only once
+	if err := store.HMSet(key, map[string]interface{}{"c": 3}); err != nil {
+		t.Fatal("Store.HMSet:", err)
+	}
+	if value, err := store.HGetDel(key, "c"); err != nil || value != "3" {
+		t.Error("Store.HGetDel:", value, err)
+	}
+	if _, err := store.HGetDel(key, "c"); err != ErrNil {
+		t.Error("Store.HGetDel: second time:", err)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	key := "testExpiry"
+	defer store.Del(key)
+
+	if err := store.SetEx(key, 1, 100*time.Millisecond); err != nil {
+		t.Fatal("Store.SetEx:", err)
+	}
+	if value, err := store.Get(key); err != nil || value != "1" {
+		t.Error("Store.Get:", value, err)
+	}
+
+	// The key is gone once its time to live has passed
+	time.Sleep(200 * time.Millisecond)
+	if _, err := store.Get(key); err != ErrNil {
+		t.Error("Store.Get: expired:", err)
+	}
+
+	// Expire sets the time to live of existing keys
+	if err := store.HMSet(key, map[string]interface{}{"a": 1}); err != nil {
+		t.Fatal("Store.HMSet:", err)
+	}
+	if err := store.Expire(key, 100*time.Millisecond); err != nil {
+		t.Error("Store.Expire:", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if fields, err := store.HGetAll(key); err != nil || len(fields) != 0 {
+		t.Error("Store.HGetAll: expired:", fields, err)
+	}
+
+	// GetDel returns a value only once
+	if err := store.SetEx(key, 2, time.Minute); err != nil {
+		t.Fatal("Store.SetEx:", err)
+	}
+	if value, err := store.GetDel(key); err != nil || value != "2" {
+		t.Error("Store.GetDel:", value, err)
+	}
+	if _, err := store.GetDel(key); err != ErrNil {
+		t.Error("Store.GetDel: second time:", err)
+	}
+}
+
+func TestStoreTakeToken(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	bucket := TokenBucket{Key: "testTokenBucket", Capacity: 3, Interval: 100 * time.Millisecond}
+	defer store.Del(bucket.Key)
+
+	// A full bucket allows a burst of Capacity
+	for i := 2; i >= 0; i-- {
+		if remaining, wait, err := store.TakeToken(bucket); err != nil || remaining != i || wait != 0 {
+			t.Fatal("Store.TakeToken:", remaining, wait, err)
+		}
+	}
+
+	// Then it has to wait for the next token
+	remaining, wait, err := store.TakeToken(bucket)
+	if err != nil || remaining != 0 || wait <= 0 || wait > bucket.Interval {
+		t.Error("Store.TakeToken: empty:", remaining, wait, err)
+	}
+	time.Sleep(wait + 20*time.Millisecond)
+	if remaining, wait, err := store.TakeToken(bucket); err != nil || remaining != 0 || wait != 0 {
+		t.Error("Store.TakeToken: refilled:", remaining, wait, err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"io"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -14,18 +16,18 @@ type Setter struct {
 	err error
 }
 
-func (setter *Setter) set(m map[string]interface{}, key, value string) {
+func (setter *Setter) set(field *string, value string) {
 	if setter.err == nil {
-		m[key] = value
+		*field = value
 	}
 }
 
-func (setter *Setter) setDate(m map[string]interface{}, key, value string) {
+func (setter *Setter) setDate(field *string, value string) {
 	if setter.err == nil {
 		if _, err := parseDate(value); err != nil {
 			setter.err = err
 		} else {
-			m[key] = value
+			*field = value
 		}
 	}
 }
@@ -43,7 +45,7 @@ func parseDate(date string) (time.Time, error) {
 func copyFile(r *http.Request, name string, folder, filename string) (destination string, err error) {
 	var fileheader *multipart.FileHeader
 
-	if _, fileheader, err = r.FormFile("image"); err != nil {
+	if _, fileheader, err = r.FormFile(name); err != nil {
 		if err == http.ErrMissingFile {
 			err = nil
 		}
@@ -79,6 +81,23 @@ func copyFile(r *http.Request, name string, folder, filename string) (destinatio
 	return
 }
 
+// Detect the content type of a file from its first 512 bytes
+func detectContentType(file io.ReadSeeker) (string, error) {
+	buffer := make([]byte, 512)
+
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	// Rewind the file for whoever reads it next
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buffer[:n]), nil
+}
+
 // Generates randomized filename
 func randomFilename() string {
 	cmd := exec.Command("openssl", "rand", "-base64", "64")
@@ -97,22 +116,36 @@ func randomFilename() string {
 	return string(output)
 }
 
-// Check if map has all the specified keys
-func hasKeys(m map[string]interface{}, args ...string) bool {
-	for _, key := range args {
-		if _, ok := m[key]; !ok {
-			return false
+// Check if a list of strings contains a value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
-// Check if map has one of the specified keys
-func hasKey(m map[string]interface{}, args ...string) (bool, string) {
-	for _, key := range args {
-		if _, ok := m[key]; ok {
-			return true, key
+// Get keys without the ones that were already listed
+func uniqueKeys(keys ...string) []string {
+	unique := []string{}
+	for _, key := range keys {
+		if !contains(unique, key) {
+			unique = append(unique, key)
 		}
 	}
-	return false, ""
+	return unique
+}
+
+// Get the IP address of the client of a request.
+// Behind a reverse proxy, that's the address the proxy added to X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); *trustproxy && forwardedFor != "" {
+		addresses := strings.Split(forwardedFor, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WaitlistNotifier tells Users that a seat they waited for is offered to them
+type WaitlistNotifier interface {
+	NotifyWaitlistOffer(user *User, offer *LongTableBooking, claimBy time.Time) error
+}
+
+// MailWaitlistNotifier emails waitlist offers
+type MailWaitlistNotifier struct {
+	Mailer Mailer
+}
+
+func (notifier MailWaitlistNotifier) NotifyWaitlistOffer(user *User, offer *LongTableBooking, claimBy time.Time) error {
+	return notifier.Mailer.Send(user.Email, "A seat is waiting for you", fmt.Sprint(
+		"Hello ", user.Firstname, ",\n\n",
+		"A seat at the long table you were waiting for on ", offer.Date, " is free, and we're holding it for you. ",
+		"Claim booking ", offer.ID, " before ", claimBy.Format(time.RFC1123), ", or it goes to the next guest on the waitlist.",
+	))
+}
+
+// WaitlistView is the JSON representation of a User's place on a waitlist
+type WaitlistView struct {
+	Position int `json:"position"`
+	Length   int `json:"length"`
+}
+
+// Pass on the waitlist offers that weren't claimed in time, then tell the Users
+// of new offers about them
+func (s *Server) processWaitlists() error {
+	offers, err := pendingWaitlistOffers(s.store)
+	if err != nil {
+		return err
+	}
+
+	// Deleting an expired offer offers its seat to the next User
+	now := time.Now()
+	for i := range offers {
+		if offers[i].offerExpired(s.claimWindow, now) {
+			if err := offers[i].delete(s.store); err != nil && err != ErrEntityNotFound {
+				return err
+			}
+		}
+	}
+
+	notifier := s.notifier
+	if notifier == nil {
+		notifier = MailWaitlistNotifier{Mailer: s.mailer}
+	}
+
+	offers, err = unnotifiedWaitlistOffers(s.store)
+	if err != nil {
+		return err
+	}
+	for i := range offers {
+		// Offers of Users that were deleted go to the next User
+		user := &User{ID: offers[i].UserID}
+		if err := user.fetch(s.store); err == ErrEntityNotFound {
+			if err := offers[i].delete(s.store); err != nil && err != ErrEntityNotFound {
+				log.Println(err)
+			}
+			continue
+		} else if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// Users that can't be told now are told on the next run
+		claimBy := time.Unix(offers[i].OfferedAt, 0).Add(s.claimWindow)
+		if err := notifier.NotifyWaitlistOffer(user, &offers[i], claimBy); err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := offers[i].offerNotified(s.store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Process the waitlists every interval, for as long as the server runs
+func (s *Server) sweepWaitlists(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.processWaitlists(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (s *Server) longTableWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	// Check if User is logged in
+	loggedIn, user := s.loggedIn(w, r, true)
+	if !loggedIn {
+		http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Get LongTable with set 'longTableID'
+	longTable := &LongTable{}
+	if longTableID, err := strconv.Atoi(r.FormValue("longTableID")); err != nil {
+		http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+		return
+	} else {
+		longTable.ID = longTableID
+	}
+	if err := longTable.fetch(s.store); err != nil {
+		http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Check if 'date' query parameter is valid
+	date := r.FormValue("date")
+	if _, err := time.Parse(DateFormat, date); err != nil {
+		http.Error(w, ErrWrongDateFormat.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check if 'sitting' query parameter is one of the LongTable's sittings
+	sitting, err := longTable.sitting(s.store, r.FormValue("sitting"))
+	if err == ErrUnknownSitting {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Get the User's place on the waitlist
+		userIDs, err := longTable.waitlist(s.store, date, sitting.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		view := WaitlistView{Length: len(userIDs)}
+		for i, userID := range userIDs {
+			if userID == user.ID {
+				view.Position = i + 1
+			}
+		}
+
+		data, err := json.Marshal(view)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+
+	case "POST":
+		// Check if User has verified their email
+		if !user.Verified {
+			http.Error(w, ErrNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Check that the sitting at 'date' can still be booked
+		if err := sitting.checkBookingTime(date, s.now(), s.bookingHorizon); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if closed, err := longTable.isClosed(s.store, date); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if closed {
+			http.Error(w, ErrLongTableClosed.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Only fully booked sittings have a waitlist
+		if seats, err := longTable.fetchAvailableSeats(s.store, date, sitting.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if len(seats) > 0 {
+			http.Error(w, ErrSeatsAvailable.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Users that have a seat don't need to wait for one
+		if booked, err := user.bookedLongTable(s.store, date, sitting.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if booked {
+			http.Error(w, ErrUserAlreadyBooked.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := longTable.joinWaitlist(s.store, date, sitting.Name, user.ID); err == ErrAlreadyWaitlisted {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case "DELETE":
+		if err := longTable.leaveWaitlist(s.store, date, sitting.Name, user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) longTableWaitlistClaimHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		// Check if User is logged in
+		loggedIn, user := s.loggedIn(w, r, false)
+		if !loggedIn {
+			http.Error(w, ErrNotLoggedIn.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Check if the LongTableBooking belongs to the User
+		longTableBooking := &LongTableBooking{}
+		if longTableBookingID, err := strconv.Atoi(r.FormValue("longTableBookingID")); err != nil {
+			http.Error(w, ErrEmptyParameter.Error(), http.StatusBadRequest)
+			return
+		} else {
+			longTableBooking.ID = longTableBookingID
+		}
+		if exists := longTableBooking.exists(s.store, true); !exists {
+			http.Error(w, ErrEntityNotFound.Error(), http.StatusNotFound)
+			return
+		} else if longTableBooking.UserID != user.ID {
+			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Offers that weren't claimed in time are passed on by processWaitlists
+		if longTableBooking.offerExpired(s.claimWindow, time.Now()) {
+			http.Error(w, ErrWaitlistOfferExpired.Error(), http.StatusGone)
+			return
+		}
+
+		if err := longTableBooking.claimOffer(s.store); err == ErrNoWaitlistOffer {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if *serveTest {
+			http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the waitlist offers that Users are told about, failing to tell the
+// Users in unreachable
+type testWaitlistNotifier struct {
+	mutex       sync.Mutex
+	offers      map[int]int
+	unreachable map[int]bool
+}
+
+func (notifier *testWaitlistNotifier) NotifyWaitlistOffer(user *User, offer *LongTableBooking, claimBy time.Time) error {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	if notifier.unreachable[user.ID] {
+		return errors.New("unreachable")
+	}
+	notifier.offers[user.ID] = offer.ID
+	return nil
+}
+
+func TestWaitlistPromotion(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	defer store.Close()
+
+	notifier := &testWaitlistNotifier{offers: map[int]int{}}
+	s := &Server{store: store, mailer: LogMailer{}, claimWindow: time.Hour, notifier: notifier}
+
+	longTable := &LongTable{Name: "Promotion longTable", NumSeats: 1}
+	if _, err := longTable.insert(store); err != nil {
+		t.Fatal("LongTable.insert:", err)
+	}
+	defer longTable.delete(store)
+
+	var users []*User
+	for _, firstname := range []string{"Dee", "Eli", "Fay"} {
+		user := &User{Firstname: firstname, Email: firstname + ".promotion@example.com", Verified: true}
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+		users = append(users, user)
+	}
+
+	date := time.Now().AddDate(0, 0, 1).Format(DateFormat)
+	waitlist := func(user *User, method string) *httptest.ResponseRecorder {
+		form := url.Values{"longTableID": {strconv.Itoa(longTable.ID)}, "date": {date}}
+		r := httptest.NewRequest(method, "/api/longtable/waitlist?"+form.Encode(), nil)
+		for _, cookie := range loginCookies(t, s, user) {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.longTableWaitlistHandler(w, r)
+		return w
+	}
+
+	// The waitlist is only for fully booked LongTables
+	if response := waitlist(users[1], "POST"); response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), ErrSeatsAvailable.Error()) {
+		t.Error("longTableWaitlistHandler: seats available:", response.Code, response.Body)
+	}
+
+	booking := &LongTableBooking{LongTableID: longTable.ID, UserID: users[0].ID, Date: date}
+	if _, err := booking.insert(store); err != nil {
+		t.Fatal("LongTableBooking.insert:", err)
+	}
+	if response := waitlist(users[0], "POST"); response.Code != http.StatusBadRequest {
+		t.Error("longTableWaitlistHandler: already booked:", response.Code, response.Body)
+	}
+	for _, user := range users[1:] {
+		if response := waitlist(user, "POST"); response.Code != http.StatusOK {
+			t.Fatal("longTableWaitlistHandler: join:", response.Code, response.Body)
+		}
+	}
+	if response := waitlist(users[2], "GET"); response.Body.String() != `{"position":2,"length":2}` {
+		t.Error("longTableWaitlistHandler: position:", response.Body)
+	}
+
+	// The first in line is told about the freed seat
+	if err := booking.delete(store); err != nil {
+		t.Fatal("LongTableBooking.delete:", err)
+	}
+	if err := s.processWaitlists(); err != nil {
+		t.Fatal("Server.processWaitlists:", err)
+	}
+	offerID := notifier.offers[users[1].ID]
+	if offerID == 0 || len(notifier.offers) != 1 {
+		t.Fatal("Server.processWaitlists: notified:", notifier.offers)
+	}
+
+	// Only the User it's offered to can claim it
+	claim := func(user *User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/longtable/waitlist/claim?longTableBookingID="+strconv.Itoa(offerID), nil)
+		for _, cookie := range loginCookies(t, s, user) {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.longTableWaitlistClaimHandler(w, r)
+		return w
+	}
+	if response := claim(users[2]); response.Code != http.StatusForbidden {
+		t.Error("longTableWaitlistClaimHandler: someone else:", response.Code)
+	}
+
+	// Offers that aren't claimed in time pass to the next in line
+	s.claimWindow = 0
+	if response := claim(users[1]); response.Code != http.StatusGone {
+		t.Error("longTableWaitlistClaimHandler: expired:", response.Code)
+	}
+	if err := s.processWaitlists(); err != nil {
+		t.Fatal("Server.processWaitlists:", err)
+	}
+	if (&LongTableBooking{ID: offerID}).exists(store, false) {
+		t.Error("Server.processWaitlists: expired offer wasn't withdrawn")
+	}
+	nextOfferID := notifier.offers[users[2].ID]
+	if nextOfferID == 0 {
+		t.Fatal("Server.processWaitlists: next:", notifier.offers)
+	}
+	defer (&LongTableBooking{ID: nextOfferID}).delete(store)
+
+	s.claimWindow = time.Hour
+	offerID = nextOfferID
+	if response := claim(users[2]); response.Code != http.StatusOK {
+		t.Error("longTableWaitlistClaimHandler:", response.Code, response.Body)
+	}
+}
+
+// Doesn't run in parallel, since processWaitlists tells everyone about their offers
+func TestWaitlistNotificationFailures(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	var users []*User
+	for _, firstname := range []string{"Lou", "Max", "Ned"} {
+		user := &User{Firstname: firstname, Email: firstname + ".unreachable@example.com", Verified: true}
+		if _, err := user.insert(store); err != nil {
+			t.Fatal("User.insert:", err)
+		}
+		defer user.delete(store)
+		users = append(users, user)
+	}
+
+	notifier := &testWaitlistNotifier{offers: map[int]int{}, unreachable: map[int]bool{users[0].ID: true}}
+	s := &Server{store: store, mailer: LogMailer{}, claimWindow: time.Hour, notifier: notifier}
+
+	// Each User is offered a seat at a LongTable of their own
+	date := time.Now().AddDate(0, 0, 1).Format(DateFormat)
+	offers := make([]*LongTableBooking, len(users))
+	for i, user := range users {
+		longTable := &LongTable{Name: "Unreachable longTable", NumSeats: 1}
+		if _, err := longTable.insert(store); err != nil {
+			t.Fatal("LongTable.insert:", err)
+		}
+		defer longTable.delete(store)
+		if err := longTable.joinWaitlist(store, date, "", user.ID); err != nil {
+			t.Fatal("LongTable.joinWaitlist:", err)
+		}
+		offer, err := promoteWaitlist(store, &LongTableBooking{LongTableID: longTable.ID, Date: date})
+		if err != nil || offer == nil {
+			t.Fatal("promoteWaitlist:", offer, err)
+		}
+		defer offer.delete(store)
+		offers[i] = offer
+	}
+
+	// A User that is gone loses their offer
+	if err := store.Del(fmt.Sprint("user:", users[2].ID)); err != nil {
+		t.Fatal("Store.Del:", err)
+	}
+
+	// Users that can't be told don't keep the others from being told
+	if err := s.processWaitlists(); err != nil {
+		t.Error("Server.processWaitlists:", err)
+	}
+	if notifier.offers[users[1].ID] != offers[1].ID || len(notifier.offers) != 1 {
+		t.Error("Server.processWaitlists: notified:", notifier.offers)
+	}
+	if !offers[0].exists(store, false) {
+		t.Error("Server.processWaitlists: unreachable offer was withdrawn")
+	}
+	if (&LongTableBooking{ID: offers[2].ID}).exists(store, false) {
+		t.Error("Server.processWaitlists: offer of a deleted User wasn't withdrawn")
+	}
+
+	// They're told on the next run
+	delete(notifier.unreachable, users[0].ID)
+	if err := s.processWaitlists(); err != nil {
+		t.Error("Server.processWaitlists:", err)
+	}
+	if notifier.offers[users[0].ID] != offers[0].ID {
+		t.Error("Server.processWaitlists: retried:", notifier.offers)
+	}
+}